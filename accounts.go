@@ -1,7 +1,7 @@
 package dynamodb
 
 import (
-	_ "context"
+	"context"
 	"errors"
 	"github.com/aaronland/go-auth/account"
 	"github.com/aaronland/go-auth/database"
@@ -9,7 +9,6 @@ import (
 	aws "github.com/aws/aws-sdk-go/aws"
 	aws_session "github.com/aws/aws-sdk-go/aws/session"
 	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
-	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
 	"strconv"
 	"time"
 )
@@ -17,25 +16,80 @@ import (
 const ACCOUNTS_DEFAULT_TABLENAME string = "accounts"
 
 type DynamoDBAccountsDatabaseOptions struct {
-	TableName   string
-	BillingMode string
-	CreateTable bool
+	TableName        string
+	BillingMode      string
+	CreateTable      bool
+	Schema           int
+	Retry            *RetryOptions
+	ConsistentRead   bool
+	LockoutThreshold int
+	LockoutWindow    time.Duration
+	LockoutDuration  time.Duration
+	MFASkew          uint
+
+	PasswordHistorySize int
+	PasswordPolicy      PasswordPolicy
+
+	AuditLog *DynamoDBAuditLog
+
+	KeyProvider KeyProvider
+
+	// ReencryptOnRead, if true, re-encrypts accounts whose data key was wrapped with an old
+	// key when they are read in full with a consistent read. The account is only written
+	// back if it has not been modified since it was read.
+	ReencryptOnRead bool
+
+	EmailCanonicalizer EmailCanonicalizer
+	EmailBlindIndexKey []byte
+
+	ReservedUsernames []string
+
+	EmailVerificationsTableName string
+	EmailVerificationTTL        time.Duration
+
+	// CursorSecret is the key, at least CURSOR_SECRET_MINIMUM_SIZE bytes, used to sign the
+	// cursors returned by ListAccountsPage. It is required to page through accounts and must
+	// be the same for every process serving the same table or cursors will not be portable
+	// between them.
+	CursorSecret []byte
+
+	// FallbackTableName, if set, is read from when an account can not be found in TableName.
+	// This allows a new table to be put in to service while items are still being copied to
+	// it from the old one. Accounts read from the fallback table are copied to TableName
+	// (unless they have been written there in the meantime) before being returned, and
+	// accounts are removed from both tables. Otherwise writes, listings and batch reads
+	// only ever use TableName.
+	FallbackTableName string
 }
 
 type DynamoDBAccount struct {
-	ID      int64            `json:"id"`
-	Created int64            `json:"created"`
-	Email   string           `json:"email"`
-	URL     string           `json:"url"`
-	Account *account.Account `json:"account"`
+	ID        int64            `json:"id"`
+	Created   int64            `json:"created"`
+	Email     string           `json:"email"`
+	URL       string           `json:"url"`
+	Skeleton  string           `json:"skeleton,omitempty"`
+	Account   *account.Account `json:"account"`
+	Encrypted *EncryptedFields `json:"encrypted,omitempty"`
 }
 
 func DefaultDynamoDBAccountsDatabaseOptions() *DynamoDBAccountsDatabaseOptions {
 
 	opts := DynamoDBAccountsDatabaseOptions{
-		TableName:   ACCOUNTS_DEFAULT_TABLENAME,
-		BillingMode: "PAY_PER_REQUEST",
-		CreateTable: false,
+		TableName:        ACCOUNTS_DEFAULT_TABLENAME,
+		BillingMode:      "PAY_PER_REQUEST",
+		CreateTable:      false,
+		Schema:           ACCOUNT_SCHEMA_NESTED,
+		Retry:            DefaultRetryOptions(),
+		ConsistentRead:   false,
+		LockoutThreshold: 5,
+		LockoutWindow:    15 * time.Minute,
+		LockoutDuration:  15 * time.Minute,
+		MFASkew:          1,
+
+		PasswordHistorySize: PASSWORD_HISTORY_DEFAULT_SIZE,
+
+		EmailVerificationsTableName: EMAILVERIFICATIONS_DEFAULT_TABLENAME,
+		EmailVerificationTTL:        24 * time.Hour,
 	}
 
 	return &opts
@@ -43,8 +97,10 @@ func DefaultDynamoDBAccountsDatabaseOptions() *DynamoDBAccountsDatabaseOptions {
 
 type DynamoDBAccountsDatabase struct {
 	database.AccountsDatabase
-	client  *aws_dynamodb.DynamoDB
-	options *DynamoDBAccountsDatabaseOptions
+	client        *aws_dynamodb.DynamoDB
+	options       *DynamoDBAccountsDatabaseOptions
+	cursor_secret []byte
+	ctx           context.Context
 }
 
 func NewDynamoDBAccountsDatabaseWithDSN(dsn string, opts *DynamoDBAccountsDatabaseOptions) (database.AccountsDatabase, error) {
@@ -60,7 +116,19 @@ func NewDynamoDBAccountsDatabaseWithDSN(dsn string, opts *DynamoDBAccountsDataba
 
 func NewDynamoDBAccountsDatabaseWithSession(sess *aws_session.Session, opts *DynamoDBAccountsDatabaseOptions) (database.AccountsDatabase, error) {
 
-	client := aws_dynamodb.New(sess)
+	err := validateSchemaOptions(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = validateBlindIndexOptions(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	client := newDynamoDBClient(sess, opts.Retry)
 
 	if opts.CreateTable {
 
@@ -69,71 +137,215 @@ func NewDynamoDBAccountsDatabaseWithSession(sess *aws_session.Session, opts *Dyn
 		if err != nil {
 			return nil, err
 		}
+
+		_, err = CreateEmailVerificationsTable(client, opts)
+
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	db := DynamoDBAccountsDatabase{
-		client:  client,
-		options: opts,
+		client:        client,
+		options:       opts,
+		cursor_secret: opts.CursorSecret,
+		ctx:           context.Background(),
 	}
 
 	return &db, nil
 }
 
+// WithContext returns a shallow copy of db which uses ctx for the changes it makes, for
+// example to record the actor set with WithAuditActor in the audit log.
+func (db *DynamoDBAccountsDatabase) WithContext(ctx context.Context) *DynamoDBAccountsDatabase {
+
+	copy := *db
+	copy.ctx = ctx
+
+	return &copy
+}
+
 func (db *DynamoDBAccountsDatabase) GetAccountByID(id int64) (*account.Account, error) {
+	return db.GetAccountByIDWithReadOptions(id, db.readOptions())
+}
+
+func (db *DynamoDBAccountsDatabase) GetAccountByIDWithReadOptions(id int64, read_opts *ReadOptions) (*account.Account, error) {
+	return db.getAccountByID(id, nil, read_opts)
+}
+
+// GetPartialAccountByID returns the account with ID id with only the account fields in
+// fields read. See PartialAccount for details.
+func (db *DynamoDBAccountsDatabase) GetPartialAccountByID(id int64, fields []string, read_opts *ReadOptions) (*PartialAccount, error) {
+	return db.getPartialAccount(fields, func() (*account.Account, error) {
+		return db.getAccountByID(id, fields, read_opts)
+	})
+}
+
+// GetPartialAccountByEmailAddress returns the account with the email address addr with only
+// the account fields in fields read. See PartialAccount for details.
+func (db *DynamoDBAccountsDatabase) GetPartialAccountByEmailAddress(addr string, fields []string, read_opts *ReadOptions) (*PartialAccount, error) {
+	return db.getPartialAccount(fields, func() (*account.Account, error) {
+		return db.getAccountByPointer("email", "email", emailIndexValue(db.options, addr), fields, read_opts)
+	})
+}
+
+// GetPartialAccountByURL returns the account with the URL url with only the account fields
+// in fields read. See PartialAccount for details.
+func (db *DynamoDBAccountsDatabase) GetPartialAccountByURL(url string, fields []string, read_opts *ReadOptions) (*PartialAccount, error) {
+	return db.getPartialAccount(fields, func() (*account.Account, error) {
+		return db.getAccountByPointer("url", "url", urlIndexValue(db.options, url), fields, read_opts)
+	})
+}
+
+func (db *DynamoDBAccountsDatabase) getPartialAccount(fields []string, get func() (*account.Account, error)) (*PartialAccount, error) {
+
+	if len(fields) == 0 {
+		return nil, errors.New("Missing account fields")
+	}
+
+	acct, err := get()
+
+	if err != nil {
+		return nil, err
+	}
+
+	partial := &PartialAccount{
+		Account: acct,
+		Fields:  fields,
+	}
+
+	return partial, nil
+}
+
+// getAccountByID reads the account with ID id. If fields is not empty only those account
+// fields are read, and the account must not be written back.
+func (db *DynamoDBAccountsDatabase) getAccountByID(id int64, fields []string, read_opts *ReadOptions) (*account.Account, error) {
+
+	if read_opts == nil {
+		read_opts = db.readOptions()
+	}
 
 	str_id := strconv.FormatInt(id, 10)
 
-	req := &aws_dynamodb.GetItemInput{
-		TableName: aws.String(db.options.TableName),
-		Key: map[string]*aws_dynamodb.AttributeValue{
-			"id": {
-				N: aws.String(str_id),
+	projection, projection_names, err := accountProjection(fields)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var item map[string]*aws_dynamodb.AttributeValue
+	var item_table string
+
+	for _, table := range readTables(db.options.TableName, db.options.FallbackTableName) {
+
+		req := &aws_dynamodb.GetItemInput{
+			TableName: aws.String(table),
+			Key: map[string]*aws_dynamodb.AttributeValue{
+				"id": {
+					N: aws.String(str_id),
+				},
 			},
-		},
+			ConsistentRead: aws.Bool(read_opts.ConsistentRead),
+		}
+
+		if projection != "" {
+			req.ProjectionExpression = aws.String(projection)
+			req.ExpressionAttributeNames = projection_names
+		}
+
+		rsp, err := db.client.GetItem(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		item = rsp.Item
+		item_table = table
+
+		if len(item) > 0 {
+			break
+		}
 	}
 
-	rsp, err := db.client.GetItem(req)
+	dynamodb_acct, err := itemToDynamoDBAccount(item)
 
 	if err != nil {
 		return nil, err
 	}
 
-	return itemToAccount(rsp.Item)
+	acct, err := dynamodbAccountToAccount(db.options, dynamodb_acct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Copy accounts read from the fallback table forward, so that subsequent updates
+	// (which are only ever made to TableName) find them
+
+	if len(item) > 0 && item_table != db.options.TableName {
+
+		if projection != "" {
+			err = db.copyAccountForward(id)
+		} else {
+			err = db.putAccountItemForward(item)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Lazily re-encrypt accounts whose data key was wrapped with an old key (but never
+	// write back an account that has only been partially read, read from the fallback
+	// table or read inconsistently, since it may be stale). The write is conditional on the
+	// stored account being unchanged, and losing that race is not an error.
+
+	if db.options.ReencryptOnRead && read_opts.ConsistentRead && projection == "" && item_table == db.options.TableName && needsEncryption(db.options.KeyProvider, dynamodb_acct.Encrypted) {
+
+		req, err := newAccountRewrite(db.options, item, acct, nil)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if req != nil {
+
+			_, err = applyRewrite(db.client, req)
+
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return acct, nil
 }
 
 func (db *DynamoDBAccountsDatabase) GetAccountByEmailAddress(addr string) (*account.Account, error) {
-	return db.getAccountByPointer("email", "email", addr)
+	return db.GetAccountByEmailAddressWithReadOptions(addr, db.readOptions())
+}
+
+func (db *DynamoDBAccountsDatabase) GetAccountByEmailAddressWithReadOptions(addr string, read_opts *ReadOptions) (*account.Account, error) {
+	return db.getAccountByPointer("email", "email", emailIndexValue(db.options, addr), nil, read_opts)
 }
 
 func (db *DynamoDBAccountsDatabase) GetAccountByURL(url string) (*account.Account, error) {
-	return db.getAccountByPointer("url", "url", url)
+	return db.GetAccountByURLWithReadOptions(url, db.readOptions())
 }
 
-func (db *DynamoDBAccountsDatabase) getAccountByPointer(idx string, key string, value string) (*account.Account, error) {
+func (db *DynamoDBAccountsDatabase) GetAccountByURLWithReadOptions(url string, read_opts *ReadOptions) (*account.Account, error) {
+	return db.getAccountByPointer("url", "url", urlIndexValue(db.options, url), nil, read_opts)
+}
 
-	req := &aws_dynamodb.QueryInput{
-		TableName: aws.String(db.options.TableName),
-		KeyConditions: map[string]*aws_dynamodb.Condition{
-			key: {
-				ComparisonOperator: aws.String("EQ"),
-				AttributeValueList: []*aws_dynamodb.AttributeValue{
-					{
-						S: aws.String(value),
-					},
-				},
-			},
-		},
-		ProjectionExpression: aws.String("id"),
-		IndexName:            aws.String(idx),
-	}
+func (db *DynamoDBAccountsDatabase) getAccountByPointer(idx string, key string, value string, fields []string, read_opts *ReadOptions) (*account.Account, error) {
 
-	rsp, err := db.client.Query(req)
+	items, err := queryPointer(db.client, readTables(db.options.TableName, db.options.FallbackTableName), idx, key, value)
 
 	if err != nil {
 		return nil, err
 	}
 
-	count_items := len(rsp.Items)
+	count_items := len(items)
 
 	if count_items < 1 {
 		return nil, new(database.ErrNoAccount)
@@ -143,7 +355,7 @@ func (db *DynamoDBAccountsDatabase) getAccountByPointer(idx string, key string,
 		return nil, errors.New("Multiple results for key!")
 	}
 
-	rsp_id := rsp.Items[0]["id"]
+	rsp_id := items[0]["id"]
 	str_id := *rsp_id.N
 
 	id, err := strconv.ParseInt(str_id, 10, 64)
@@ -152,11 +364,40 @@ func (db *DynamoDBAccountsDatabase) getAccountByPointer(idx string, key string,
 		return nil, err
 	}
 
-	return db.GetAccountByID(id)
+	return db.getAccountByID(id, fields, read_opts)
 }
 
+// AddAccount adds acct to the database. If options.PasswordPolicy is set it returns
+// ErrPasswordPolicyUnchecked, since the policy can only be checked against a plaintext
+// password; use AddAccountWithPassword instead.
 func (db *DynamoDBAccountsDatabase) AddAccount(acct *account.Account) (*account.Account, error) {
 
+	if db.options.PasswordPolicy != nil {
+		return nil, ErrPasswordPolicyUnchecked
+	}
+
+	return db.addAccount(acct)
+}
+
+// AddAccountWithPassword adds acct, whose password was set from pswd, to the database once
+// pswd has been checked against options.PasswordPolicy.
+func (db *DynamoDBAccountsDatabase) AddAccountWithPassword(acct *account.Account, pswd string) (*account.Account, error) {
+
+	err := CheckPassword(db.options.PasswordPolicy, pswd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !passwordMatches(acct.Password, pswd) {
+		return nil, errors.New("Password does not match account")
+	}
+
+	return db.addAccount(acct)
+}
+
+func (db *DynamoDBAccountsDatabase) addAccount(acct *account.Account) (*account.Account, error) {
+
 	existing_acct, err := db.GetAccountByEmailAddress(acct.Address.URI)
 
 	if err != nil && !database.IsNotExist(err) {
@@ -177,6 +418,18 @@ func (db *DynamoDBAccountsDatabase) AddAccount(acct *account.Account) (*account.
 		return nil, errors.New("Account already exists")
 	}
 
+	err = db.checkUsernameAvailable(acct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.checkEmailAvailable(acct.Address.URI, 0)
+
+	if err != nil {
+		return nil, err
+	}
+
 	id, err := database.NewID()
 
 	if err != nil {
@@ -191,6 +444,8 @@ func (db *DynamoDBAccountsDatabase) AddAccount(acct *account.Account) (*account.
 		return nil, err
 	}
 
+	db.audit(AUDIT_ACTION_ADD, acct.ID, nil, acct)
+
 	return acct, nil
 }
 
@@ -198,94 +453,478 @@ func (db *DynamoDBAccountsDatabase) RemoveAccount(acct *account.Account) (*accou
 
 	str_id := strconv.FormatInt(acct.ID, 10)
 
-	req := &aws_dynamodb.DeleteItemInput{
-		TableName: aws.String(db.options.TableName),
-		Key: map[string]*aws_dynamodb.AttributeValue{
-			"id": {
-				N: aws.String(str_id),
+	var old_item map[string]*aws_dynamodb.AttributeValue
+
+	// Remove the account from the fallback table as well, otherwise it (and its email
+	// address and URL pointers) would still resolve there
+
+	for _, table := range readTables(db.options.TableName, db.options.FallbackTableName) {
+
+		req := &aws_dynamodb.DeleteItemInput{
+			TableName: aws.String(table),
+			Key: map[string]*aws_dynamodb.AttributeValue{
+				"id": {
+					N: aws.String(str_id),
+				},
 			},
-		},
+			ReturnValues: aws.String(aws_dynamodb.ReturnValueAllOld),
+		}
+
+		rsp, err := db.client.DeleteItem(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(old_item) == 0 {
+			old_item = rsp.Attributes
+		}
 	}
 
-	_, err := db.client.DeleteItem(req)
+	if db.options.AuditLog != nil && len(old_item) > 0 {
 
-	if err != nil {
-		return nil, err
+		old_acct, err := itemToAccount(db.options, old_item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		db.audit(AUDIT_ACTION_REMOVE, acct.ID, old_acct, nil)
 	}
 
 	return nil, nil
 }
 
+// UpdateAccount writes acct to the database. If acct's email address has changed the new
+// address must not belong to, or be reserved by, another account and will be marked as
+// unverified. Use RequestEmailChange and ConfirmEmailChange to change an address safely.
+// If acct's username has changed it must not be reserved or collide with another account's.
 func (db *DynamoDBAccountsDatabase) UpdateAccount(acct *account.Account) (*account.Account, error) {
+	return db.updateAccount(acct, nil)
+}
+
+// updateAccount writes acct, and any extra attributes, to the database in a single update.
+func (db *DynamoDBAccountsDatabase) updateAccount(acct *account.Account, extra map[string]*aws_dynamodb.AttributeValue) (*account.Account, error) {
+
+	current_addr, current_skeleton, err := db.getCurrentIndexValues(acct)
+
+	if err != nil {
+		return acct, err
+	}
+
+	if current_skeleton != usernameSkeletonValue(acct) {
+
+		err := db.checkUsernameAvailable(acct)
+
+		if err != nil {
+			return acct, err
+		}
+	}
+
+	email_changed := current_addr != "" && current_addr != emailIndexValue(db.options, acct.Address.URI)
+
+	if email_changed {
+
+		err := db.checkEmailAvailable(acct.Address.URI, acct.ID)
+
+		if err != nil {
+			return acct, err
+		}
+	}
+
+	var old_acct *account.Account
+
+	if db.options.AuditLog != nil {
+
+		old_acct, err = db.GetAccountByIDWithReadOptions(acct.ID, &ReadOptions{ConsistentRead: true})
+
+		if err != nil && !database.IsNotExist(err) {
+			return acct, err
+		}
+	}
+
+	attrs := make(map[string]interface{})
+
+	for k := range extra {
+		attrs[k] = AUDIT_REDACTED
+	}
+
+	// A changed address is marked as unverified in the same update, so that it is never
+	// seen as verified
+
+	if email_changed {
+
+		with_verified := map[string]*aws_dynamodb.AttributeValue{
+			"email_verified": {BOOL: aws.Bool(false)},
+		}
+
+		for k, v := range extra {
+			with_verified[k] = v
+		}
+
+		extra = with_verified
+		attrs["email_verified"] = false
+	}
 
 	now := time.Now()
 	acct.LastModified = now.Unix()
 
-	err := putAccount(db.client, db.options, acct)
+	err = putAccountWithAttributes(db.client, db.options, acct, extra)
 
 	if err != nil {
 		return acct, err
 	}
 
+	db.auditAttributes(AUDIT_ACTION_UPDATE, acct.ID, old_acct, acct, attrs)
 	return acct, nil
 }
 
+// getCurrentIndexValues returns the stored "email" and "skeleton" attributes for acct. The
+// former will be a blind index rather than an address if options.EmailBlindIndexKey is set.
+func (db *DynamoDBAccountsDatabase) getCurrentIndexValues(acct *account.Account) (string, string, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:            aws.String(db.options.TableName),
+		Key:                  idKey(acct.ID),
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("#email, #skeleton"),
+		ExpressionAttributeNames: map[string]*string{
+			"#email":    aws.String("email"),
+			"#skeleton": aws.String("skeleton"),
+		},
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return "", "", err
+	}
+
+	email := ""
+	skeleton := ""
+
+	if v, ok := rsp.Item["email"]; ok && v.S != nil {
+		email = *v.S
+	}
+
+	if v, ok := rsp.Item["skeleton"]; ok && v.S != nil {
+		skeleton = *v.S
+	}
+
+	return email, skeleton, nil
+}
+
+// ListAccountsPage returns up to opts.PageSize accounts starting from opts.Cursor and the
+// cursor for the next page, which will be empty when there are no more accounts.
+func (db *DynamoDBAccountsDatabase) ListAccountsPage(ctx context.Context, opts *PageOptions) ([]*account.Account, string, error) {
+
+	err := validateCursorSecret(db.cursor_secret)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	opts = pageOptions(opts)
+
+	start_key, err := decodeCursor(db.cursor_secret, db.options.TableName, opts.Cursor)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	req := &aws_dynamodb.ScanInput{
+		TableName:         aws.String(db.options.TableName),
+		ExclusiveStartKey: start_key,
+	}
+
+	items, last_key, err := scanPage(db.client, req, pageSize(opts), []string{"id"})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	accounts := make([]*account.Account, 0)
+
+	for _, item := range items {
+
+		acct, err := itemToAccount(db.options, item)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		accounts = append(accounts, acct)
+	}
+
+	cursor, err := encodeCursor(db.cursor_secret, db.options.TableName, last_key)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return accounts, cursor, nil
+}
+
+// audit records action on the account id in the audit log, if one is configured. It is
+// called once the change has been written so errors are logged rather than returned.
+func (db *DynamoDBAccountsDatabase) audit(action string, id int64, old *account.Account, new *account.Account) {
+	db.auditAttributes(action, id, old, new, nil)
+}
+
+// auditAttributes is like audit but also records the new values of attrs, attributes that
+// are stored alongside the account rather than being part of it.
+func (db *DynamoDBAccountsDatabase) auditAttributes(action string, id int64, old *account.Account, new *account.Account, attrs map[string]interface{}) {
+
+	if db.options.AuditLog == nil {
+		return
+	}
+
+	if len(db.options.EmailBlindIndexKey) > 0 {
+		old = auditBlindAccount(db.options, old)
+		new = auditBlindAccount(db.options, new)
+	}
+
+	changes, err := auditChanges(old, new)
+
+	if err == nil && len(attrs) > 0 {
+
+		var attr_changes map[string]*AuditChange
+		attr_changes, err = auditChanges(nil, attrs)
+
+		for k, ch := range attr_changes {
+			changes[k] = ch
+		}
+	}
+
+	if err == nil {
+		err = db.options.AuditLog.RecordChanges(db.ctx, action, AUDIT_TARGET_ACCOUNT, id, changes)
+	}
+
+	if err != nil {
+		logAuditError(AUDIT_TARGET_ACCOUNT, id, action, err)
+	}
+}
+
+func (db *DynamoDBAccountsDatabase) readOptions() *ReadOptions {
+
+	read_opts := ReadOptions{
+		ConsistentRead: db.options.ConsistentRead,
+	}
+
+	return &read_opts
+}
+
+// getAccountItem returns the stored item, read consistently, for the account with ID id and
+// the account it contains. Accounts that are still only in FallbackTableName are copied to
+// TableName first.
+func (db *DynamoDBAccountsDatabase) getAccountItem(id int64) (map[string]*aws_dynamodb.AttributeValue, *account.Account, error) {
+
+	err := db.copyAccountForward(id)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:      aws.String(db.options.TableName),
+		Key:            idKey(id),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	acct, err := itemToAccount(db.options, rsp.Item)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rsp.Item, acct, nil
+}
+
+// newAccountRewrite returns the request that changes item, the stored form of acct when it
+// was read, so that it holds acct and any extra attributes. The update is conditional on the
+// account still existing and on none of the attributes being changed having been modified
+// since item was read. It returns nil if nothing would change.
+func newAccountRewrite(opts *DynamoDBAccountsDatabaseOptions, item map[string]*aws_dynamodb.AttributeValue, acct *account.Account, extra map[string]*aws_dynamodb.AttributeValue) (*aws_dynamodb.UpdateItemInput, error) {
+
+	new_item, err := accountToItem(opts, acct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	rewritten := copyItem(item)
+
+	for _, name := range staleAccountAttributes(new_item) {
+		delete(rewritten, name)
+	}
+
+	for k, v := range new_item {
+		rewritten[k] = v
+	}
+
+	for k, v := range extra {
+		rewritten[k] = v
+	}
+
+	req := newMigrationUpdate(opts.TableName, "id", item, rewritten)
+
+	if aws.StringValue(req.UpdateExpression) == "" {
+		return nil, nil
+	}
+
+	return req, nil
+}
+
+// applyRewrite makes req, an update built by newAccountRewrite or newMigrationUpdate, and
+// returns false if it was not applied because the item was modified or removed after it
+// was read.
+func applyRewrite(client *aws_dynamodb.DynamoDB, req *aws_dynamodb.UpdateItemInput) (bool, error) {
+
+	_, err := client.UpdateItem(req)
+
+	if isConditionalCheckFailed(err) {
+		return false, nil
+	}
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// RewriteResult summarizes a bulk rewrite of the accounts table. Skipped counts accounts
+// that were modified or removed after they were read, which are left untouched and will
+// be rewritten if it is run again. Invalid lists the IDs of accounts that could not be
+// rewritten because they are missing a required field, such as an email address.
+type RewriteResult struct {
+	Updated int     `json:"updated"`
+	Skipped int     `json:"skipped"`
+	Invalid []int64 `json:"invalid,omitempty"`
+}
+
+func (result *RewriteResult) add(applied bool) {
+
+	if applied {
+		result.Updated += 1
+	} else {
+		result.Skipped += 1
+	}
+}
+
 func putAccount(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccountsDatabaseOptions, acct *account.Account) error {
+	return putAccountWithAttributes(client, opts, acct, nil)
+}
 
-	dynamodb_acct := accountToDynamoDBAccount(acct)
+// putAccountWithAttributes writes acct along with extra, attributes stored alongside the
+// account such as its password history, in a single update.
+func putAccountWithAttributes(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccountsDatabaseOptions, acct *account.Account, extra map[string]*aws_dynamodb.AttributeValue) error {
 
-	item, err := aws_dynamodbattribute.MarshalMap(dynamodb_acct)
+	item, err := accountToItem(opts, acct)
 
 	if err != nil {
 		return err
 	}
 
-	req := &aws_dynamodb.PutItemInput{
-		Item:      item,
-		TableName: aws.String(opts.TableName),
+	for k, v := range extra {
+		item[k] = v
 	}
 
-	_, err = client.PutItem(req)
+	return updateItem(client, opts.TableName, "id", item, staleAccountAttributes(item))
+}
+
+func accountToItem(opts *DynamoDBAccountsDatabaseOptions, acct *account.Account) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+	dynamodb_acct, err := accountToDynamoDBAccount(opts, acct)
 
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	item, err := marshalAccountItem(opts, dynamodb_acct)
+
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	item[SCHEMA_VERSION_ATTRIBUTE] = schemaVersionAttribute(opts.Schema)
+	return item, nil
 }
 
-func itemToAccount(item map[string]*aws_dynamodb.AttributeValue) (*account.Account, error) {
+func itemToAccount(opts *DynamoDBAccountsDatabaseOptions, item map[string]*aws_dynamodb.AttributeValue) (*account.Account, error) {
+
+	dynamodb_acct, err := itemToDynamoDBAccount(item)
 
-	var dynamodb_acct *DynamoDBAccount
+	if err != nil {
+		return nil, err
+	}
 
-	err := aws_dynamodbattribute.UnmarshalMap(item, &dynamodb_acct)
+	return dynamodbAccountToAccount(opts, dynamodb_acct)
+}
+
+func itemToDynamoDBAccount(item map[string]*aws_dynamodb.AttributeValue) (*DynamoDBAccount, error) {
+
+	dynamodb_acct, err := unmarshalAccountItem(item)
 
 	if err != nil {
 		return nil, err
 	}
 
-	if dynamodb_acct.ID == 0 {
+	if dynamodb_acct == nil || dynamodb_acct.ID == 0 {
 		return nil, new(database.ErrNoAccount)
 	}
 
-	acct := dynamodbAccountToAccount(dynamodb_acct)
-
-	return acct, nil
+	return dynamodb_acct, nil
 }
 
-func accountToDynamoDBAccount(acct *account.Account) *DynamoDBAccount {
+func accountToDynamoDBAccount(opts *DynamoDBAccountsDatabaseOptions, acct *account.Account) (*DynamoDBAccount, error) {
 
 	dynamodb_acct := DynamoDBAccount{
-		ID:      acct.ID,
-		Created: acct.Created,
-		Email:   acct.Address.URI,
-		URL:     acct.Username.Safe,
-		Account: acct,
+		ID:       acct.ID,
+		Created:  acct.Created,
+		Email:    emailIndexValue(opts, acct.Address.URI),
+		URL:      urlIndexValue(opts, acct.Username.Safe),
+		Skeleton: usernameSkeletonValue(acct),
+		Account:  acct,
 	}
 
-	return &dynamodb_acct
+	if opts.KeyProvider != nil {
+
+		scrubbed, enc, err := encryptAccountFields(opts.KeyProvider, acct, encryptedAccountFields(opts))
+
+		if err != nil {
+			return nil, err
+		}
+
+		dynamodb_acct.Account = scrubbed
+		dynamodb_acct.Encrypted = enc
+	}
+
+	return &dynamodb_acct, nil
 }
 
-func dynamodbAccountToAccount(dynamodb_acct *DynamoDBAccount) *account.Account {
-	return dynamodb_acct.Account
+func dynamodbAccountToAccount(opts *DynamoDBAccountsDatabaseOptions, dynamodb_acct *DynamoDBAccount) (*account.Account, error) {
+
+	acct := dynamodb_acct.Account
+
+	if acct == nil {
+		return nil, new(database.ErrNoAccount)
+	}
+
+	if dynamodb_acct.Encrypted != nil {
+
+		err := decryptAccountFields(opts.KeyProvider, acct, dynamodb_acct.Encrypted)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return acct, nil
 }
@@ -0,0 +1,423 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/aaronland/go-aws-session"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"log"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const AUDITLOG_DEFAULT_TABLENAME string = "audit_log"
+
+const AUDIT_TARGET_ACCOUNT string = "account"
+
+const AUDIT_TARGET_TOKEN string = "token"
+
+const AUDIT_ACTION_ADD string = "add"
+
+const AUDIT_ACTION_UPDATE string = "update"
+
+const AUDIT_ACTION_REMOVE string = "remove"
+
+const AUDIT_ACTION_LOGIN_FAILED string = "login_failed"
+
+const AUDIT_ACTION_LOGIN_SUCCEEDED string = "login_succeeded"
+
+const AUDIT_ACTION_UNLOCK string = "unlock"
+
+const AUDIT_ACTION_MFA_VERIFY string = "mfa_verify"
+
+const AUDIT_ACTION_MFA_RECOVERY_CODES string = "mfa_recovery_codes"
+
+const AUDIT_ACTION_MFA_RECOVERY_REDEEM string = "mfa_recovery_redeem"
+
+const AUDIT_ACTION_VERIFY_EMAIL string = "verify_email"
+
+const AUDIT_ACTION_CHANGE_EMAIL string = "change_email"
+
+const AUDIT_REDACTED string = "[REDACTED]"
+
+// Changes to fields whose names contain any of these strings are recorded, but not their values.
+var auditRedactedFields = []string{
+	"password",
+	"digest",
+	"salt",
+	"secret",
+	"mfa",
+	"access_token",
+	"accesstoken",
+	"encrypted",
+}
+
+type DynamoDBAuditLogOptions struct {
+	TableName   string
+	BillingMode string
+	CreateTable bool
+	Retry       *RetryOptions
+	// Actor is recorded for entries whose context has no actor set with WithAuditActor.
+	Actor string
+}
+
+type auditActorKey struct{}
+
+// WithAuditActor returns a copy of ctx which causes audit log entries recorded with it to
+// name actor as the one responsible for the change.
+func WithAuditActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, auditActorKey{}, actor)
+}
+
+// AuditActorFromContext returns the actor set on ctx with WithAuditActor, if any.
+func AuditActorFromContext(ctx context.Context) (string, bool) {
+
+	actor, ok := ctx.Value(auditActorKey{}).(string)
+	return actor, ok
+}
+
+func DefaultDynamoDBAuditLogOptions() *DynamoDBAuditLogOptions {
+
+	opts := DynamoDBAuditLogOptions{
+		TableName:   AUDITLOG_DEFAULT_TABLENAME,
+		BillingMode: "PAY_PER_REQUEST",
+		CreateTable: false,
+		Retry:       DefaultRetryOptions(),
+		Actor:       "",
+	}
+
+	return &opts
+}
+
+// AuditLogEntry records a single mutation. Target ("account:1234") and Timestamp (in
+// nanoseconds) are the table's hash and range keys. Changes maps the dot-separated path
+// of each changed field to its old and new values.
+type AuditLogEntry struct {
+	Target     string                  `json:"target"`
+	Timestamp  int64                   `json:"timestamp"`
+	TargetType string                  `json:"target_type"`
+	TargetID   int64                   `json:"target_id"`
+	Action     string                  `json:"action"`
+	Actor      string                  `json:"actor"`
+	Changes    map[string]*AuditChange `json:"changes"`
+}
+
+type AuditChange struct {
+	Old interface{} `json:"old"`
+	New interface{} `json:"new"`
+}
+
+type DynamoDBAuditLog struct {
+	client  *aws_dynamodb.DynamoDB
+	options *DynamoDBAuditLogOptions
+}
+
+func NewDynamoDBAuditLogWithDSN(dsn string, opts *DynamoDBAuditLogOptions) (*DynamoDBAuditLog, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDynamoDBAuditLogWithSession(sess, opts)
+}
+
+func NewDynamoDBAuditLogWithSession(sess *aws_session.Session, opts *DynamoDBAuditLogOptions) (*DynamoDBAuditLog, error) {
+
+	client := newDynamoDBClient(sess, opts.Retry)
+
+	if opts.CreateTable {
+
+		_, err := CreateAuditLogTable(client, opts)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	l := DynamoDBAuditLog{
+		client:  client,
+		options: opts,
+	}
+
+	return &l, nil
+}
+
+// Record writes an entry for action on the target identified by target_type and target_id.
+// old and new are the target before and after the action (either may be nil) and are
+// compared to produce the entry's changes. The entry's actor is read from ctx, falling back
+// to options.Actor.
+func (l *DynamoDBAuditLog) Record(ctx context.Context, action string, target_type string, target_id int64, old interface{}, new interface{}) error {
+
+	changes, err := auditChanges(old, new)
+
+	if err != nil {
+		return err
+	}
+
+	return l.RecordChanges(ctx, action, target_type, target_id, changes)
+}
+
+// RecordChanges writes an entry for action on the target identified by target_type and
+// target_id with changes, which should already have been redacted.
+func (l *DynamoDBAuditLog) RecordChanges(ctx context.Context, action string, target_type string, target_id int64, changes map[string]*AuditChange) error {
+
+	actor, ok := AuditActorFromContext(ctx)
+
+	if !ok {
+		actor = l.options.Actor
+	}
+
+	entry := AuditLogEntry{
+		Target:     auditTarget(target_type, target_id),
+		TargetType: target_type,
+		TargetID:   target_id,
+		Action:     action,
+		Actor:      actor,
+		Changes:    changes,
+	}
+
+	// Timestamps are the range key so in the (unlikely) event of a collision try the
+	// next nanosecond
+
+	ts := time.Now().UnixNano()
+
+	for attempt := 0; attempt < 5; attempt++ {
+
+		entry.Timestamp = ts + int64(attempt)
+
+		item, err := aws_dynamodbattribute.MarshalMap(entry)
+
+		if err != nil {
+			return err
+		}
+
+		req := &aws_dynamodb.PutItemInput{
+			TableName:           aws.String(l.options.TableName),
+			Item:                item,
+			ConditionExpression: aws.String("attribute_not_exists(#timestamp)"),
+			ExpressionAttributeNames: map[string]*string{
+				"#timestamp": aws.String("timestamp"),
+			},
+		}
+
+		_, err = l.client.PutItem(req)
+
+		if err == nil {
+			return nil
+		}
+
+		if !isConditionalCheckFailed(err) {
+			return err
+		}
+	}
+
+	return fmt.Errorf("Failed to record audit log entry for %s", entry.Target)
+}
+
+// Query returns the entries for the target identified by target_type and target_id recorded
+// between from and to (inclusive), oldest first. A zero value for to means "now".
+func (l *DynamoDBAuditLog) Query(target_type string, target_id int64, from time.Time, to time.Time) ([]*AuditLogEntry, error) {
+
+	if to.IsZero() {
+		to = time.Now()
+	}
+
+	str_from := strconv.FormatInt(from.UnixNano(), 10)
+	str_to := strconv.FormatInt(to.UnixNano(), 10)
+
+	req := &aws_dynamodb.QueryInput{
+		TableName:              aws.String(l.options.TableName),
+		KeyConditionExpression: aws.String("#target = :target AND #timestamp BETWEEN :from AND :to"),
+		ExpressionAttributeNames: map[string]*string{
+			"#target":    aws.String("target"),
+			"#timestamp": aws.String("timestamp"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":target": {S: aws.String(auditTarget(target_type, target_id))},
+			":from":   {N: aws.String(str_from)},
+			":to":     {N: aws.String(str_to)},
+		},
+	}
+
+	entries := make([]*AuditLogEntry, 0)
+
+	for {
+
+		rsp, err := l.client.Query(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range rsp.Items {
+
+			var entry *AuditLogEntry
+
+			err := aws_dynamodbattribute.UnmarshalMap(item, &entry)
+
+			if err != nil {
+				return nil, err
+			}
+
+			entries = append(entries, entry)
+		}
+
+		req.ExclusiveStartKey = rsp.LastEvaluatedKey
+
+		if rsp.LastEvaluatedKey == nil {
+			break
+		}
+	}
+
+	return entries, nil
+}
+
+// logAuditError reports a failure to record an audit log entry for a change that has already
+// been written. The change is not rolled back, and callers should not report it as failed.
+func logAuditError(target_type string, target_id int64, action string, err error) {
+	log.Printf("Failed to record audit log entry (%s) for %s, %v\n", action, auditTarget(target_type, target_id), err)
+}
+
+func auditTarget(target_type string, target_id int64) string {
+	return fmt.Sprintf("%s:%d", target_type, target_id)
+}
+
+func auditChanges(old interface{}, new interface{}) (map[string]*AuditChange, error) {
+
+	old_fields, err := flattenAuditFields(old)
+
+	if err != nil {
+		return nil, err
+	}
+
+	new_fields, err := flattenAuditFields(new)
+
+	if err != nil {
+		return nil, err
+	}
+
+	changes := make(map[string]*AuditChange)
+
+	for k, old_v := range old_fields {
+
+		new_v, ok := new_fields[k]
+
+		if ok && reflect.DeepEqual(old_v, new_v) {
+			continue
+		}
+
+		changes[k] = &AuditChange{
+			Old: old_v,
+			New: new_v,
+		}
+	}
+
+	for k, new_v := range new_fields {
+
+		_, ok := old_fields[k]
+
+		if ok {
+			continue
+		}
+
+		changes[k] = &AuditChange{
+			New: new_v,
+		}
+	}
+
+	for k, ch := range changes {
+
+		if !isAuditRedactedField(k) {
+			continue
+		}
+
+		if ch.Old != nil {
+			ch.Old = AUDIT_REDACTED
+		}
+
+		if ch.New != nil {
+			ch.New = AUDIT_REDACTED
+		}
+	}
+
+	return changes, nil
+}
+
+// flattenAuditFields returns the JSON encoding of v as a flat map of dot-separated paths.
+func flattenAuditFields(v interface{}) (map[string]interface{}, error) {
+
+	fields := make(map[string]interface{})
+
+	if v == nil {
+		return fields, nil
+	}
+
+	rv := reflect.ValueOf(v)
+
+	if rv.Kind() == reflect.Ptr && rv.IsNil() {
+		return fields, nil
+	}
+
+	enc, err := json.Marshal(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+
+	err = json.Unmarshal(enc, &decoded)
+
+	if err != nil {
+		return nil, err
+	}
+
+	var flatten func(prefix string, v interface{})
+
+	flatten = func(prefix string, v interface{}) {
+
+		m, ok := v.(map[string]interface{})
+
+		if !ok {
+			fields[prefix] = v
+			return
+		}
+
+		for k, child := range m {
+
+			path := k
+
+			if prefix != "" {
+				path = prefix + "." + k
+			}
+
+			flatten(path, child)
+		}
+	}
+
+	flatten("", decoded)
+
+	return fields, nil
+}
+
+func isAuditRedactedField(path string) bool {
+
+	path = strings.ToLower(path)
+
+	for _, name := range auditRedactedFields {
+
+		if strings.Contains(path, name) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,58 @@
+package dynamodb
+
+import (
+	"context"
+	"testing"
+)
+
+func TestAuditActorFromContext(t *testing.T) {
+
+	_, ok := AuditActorFromContext(context.Background())
+
+	if ok {
+		t.Fatal("Expected no actor for an empty context")
+	}
+
+	ctx := WithAuditActor(context.Background(), "admin:1234")
+
+	actor, ok := AuditActorFromContext(ctx)
+
+	if !ok {
+		t.Fatal("Expected an actor")
+	}
+
+	if actor != "admin:1234" {
+		t.Fatalf("Unexpected actor '%s'", actor)
+	}
+}
+
+func TestAuditChangesRedactsAttributes(t *testing.T) {
+
+	attrs := map[string]interface{}{
+		"failed_logins":      3,
+		"mfa_last_step":      1234,
+		"mfa_recovery_codes": "abcd",
+		"password_history":   AUDIT_REDACTED,
+	}
+
+	changes, err := auditChanges(nil, attrs)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(changes) != len(attrs) {
+		t.Fatalf("Expected %d changes, got %d", len(attrs), len(changes))
+	}
+
+	if changes["failed_logins"].New != float64(3) {
+		t.Fatalf("Unexpected value for failed_logins: %v", changes["failed_logins"].New)
+	}
+
+	for _, k := range []string{"mfa_last_step", "mfa_recovery_codes", "password_history"} {
+
+		if changes[k].New != AUDIT_REDACTED {
+			t.Fatalf("Expected %s to be redacted, got %v", k, changes[k].New)
+		}
+	}
+}
@@ -0,0 +1,623 @@
+package dynamodb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-auth/database"
+	"github.com/aaronland/go-auth/token"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const BACKEND_IMPORT_ADD string = "add"
+
+const BACKEND_IMPORT_UPDATE string = "update"
+
+const BACKEND_IMPORT_UNCHANGED string = "unchanged"
+
+const BACKEND_IMPORT_SKIP string = "skip"
+
+type ListAccountsFunc func(*account.Account) error
+
+// AccountsLister is implemented by accounts databases that can enumerate all of their
+// accounts. The go-auth database.AccountsDatabase interface does not include listing, so
+// other backends need to be wrapped with NewAccountsDatabaseLister, and a list of their
+// account IDs, to be passed to ImportAccountsFrom. The import-backend tool only reads
+// from DynamoDB or from records dumped as JSON (see JSONSource); other backends are
+// imported by calling ImportAccountsFrom and ImportTokensFrom directly.
+type AccountsLister interface {
+	ListAccounts(context.Context, ListAccountsFunc) error
+}
+
+// AccessTokensLister is implemented by every go-auth database.AccessTokensDatabase, so any
+// of them can be passed to ImportTokensFrom as is.
+type AccessTokensLister interface {
+	ListAccessTokens(context.Context, database.ListAccessTokensFunc) error
+}
+
+// BackendImportOptions controls ImportAccountsFrom and ImportTokensFrom. Conflict is one of
+// the IMPORT_CONFLICT_ constants and decides what happens when an item with the same ID
+// already exists but differs from the source. Report, if set, is called with each item's
+// outcome (including for dry runs) and can be used to produce a diff.
+type BackendImportOptions struct {
+	Conflict string
+	DryRun   bool
+	Report   func(*BackendImportChange) error
+}
+
+// BackendImportChange describes what an import did, or would do, with a single item.
+// Changes uses the same (redacted) format as the audit log.
+type BackendImportChange struct {
+	Target  string                  `json:"target"`
+	ID      int64                   `json:"id"`
+	Action  string                  `json:"action"`
+	Reason  string                  `json:"reason,omitempty"`
+	Changes map[string]*AuditChange `json:"changes,omitempty"`
+}
+
+type BackendImportResult struct {
+	Added     int64 `json:"added"`
+	Updated   int64 `json:"updated"`
+	Unchanged int64 `json:"unchanged"`
+	Skipped   int64 `json:"skipped"`
+}
+
+// ListAccounts calls cb with every account in the database.
+func (db *DynamoDBAccountsDatabase) ListAccounts(ctx context.Context, cb ListAccountsFunc) error {
+
+	return db.eachAccount(ctx, func(item map[string]*aws_dynamodb.AttributeValue, dynamodb_acct *DynamoDBAccount, acct *account.Account) error {
+		return cb(acct)
+	})
+}
+
+// ImportAccountsFrom copies every account listed by source in to the database, preserving
+// IDs, created timestamps, passwords and MFA secrets. Accounts whose email address, URL or
+// username belongs to a different account are always skipped (or fail the import when
+// opts.Conflict is IMPORT_CONFLICT_FAIL).
+func (db *DynamoDBAccountsDatabase) ImportAccountsFrom(ctx context.Context, source AccountsLister, opts *BackendImportOptions) (*BackendImportResult, error) {
+
+	err := validateBackendImportOptions(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BackendImportResult{}
+
+	err = source.ListAccounts(ctx, func(acct *account.Account) error {
+
+		if acct.ID == 0 {
+			return errors.New("Source account is missing an ID")
+		}
+
+		change, err := db.planAccountImport(acct, opts.Conflict)
+
+		if err != nil {
+			return err
+		}
+
+		return applyBackendImportChange(change, opts, result, func() error {
+
+			var old_acct *account.Account
+
+			if change.Action == BACKEND_IMPORT_UPDATE {
+
+				old_acct, err = db.GetAccountByIDWithReadOptions(acct.ID, &ReadOptions{ConsistentRead: true})
+
+				if err != nil {
+					return err
+				}
+			}
+
+			err := putAccount(db.client, db.options, acct)
+
+			if err != nil {
+				return err
+			}
+
+			action := AUDIT_ACTION_ADD
+
+			if change.Action == BACKEND_IMPORT_UPDATE {
+				action = AUDIT_ACTION_UPDATE
+			}
+
+			db.audit(action, acct.ID, old_acct, acct)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+func (db *DynamoDBAccountsDatabase) planAccountImport(acct *account.Account, conflict string) (*BackendImportChange, error) {
+
+	change := &BackendImportChange{
+		Target: SCHEMA_TARGET_ACCOUNTS,
+		ID:     acct.ID,
+	}
+
+	existing, err := db.GetAccountByIDWithReadOptions(acct.ID, &ReadOptions{ConsistentRead: true})
+
+	if err != nil && !database.IsNotExist(err) {
+		return nil, err
+	}
+
+	if err != nil {
+		existing = nil
+	}
+
+	changes, err := auditChanges(existing, acct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	change.Changes = changes
+
+	if existing != nil && len(changes) == 0 {
+		change.Action = BACKEND_IMPORT_UNCHANGED
+		return change, nil
+	}
+
+	reason, err := db.accountImportCollision(acct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if reason != "" {
+		return conflictingImportChange(change, reason, IMPORT_CONFLICT_SKIP, conflict)
+	}
+
+	if existing == nil {
+		change.Action = BACKEND_IMPORT_ADD
+		return change, nil
+	}
+
+	return conflictingImportChange(change, "Account already exists with different values", IMPORT_CONFLICT_OVERWRITE, conflict)
+}
+
+// accountImportCollision returns a reason if acct's email address, URL or username is
+// missing or already used by a different account.
+func (db *DynamoDBAccountsDatabase) accountImportCollision(acct *account.Account) (string, error) {
+
+	if acct.Address == nil {
+		return "Account is missing an email address", nil
+	}
+
+	if acct.Username == nil {
+		return "Account is missing a username", nil
+	}
+
+	err := db.checkEmailAvailable(acct.Address.URI, acct.ID)
+
+	if err == ErrEmailAddressUnavailable {
+		return "Email address belongs to another account", nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	existing, err := db.GetAccountByURL(acct.Username.Safe)
+
+	if err != nil && !database.IsNotExist(err) {
+		return "", err
+	}
+
+	if err == nil && existing.ID != acct.ID {
+		return "URL belongs to another account", nil
+	}
+
+	err = db.checkUsernameAvailable(acct)
+
+	if err == ErrUsernameUnavailable || err == ErrUsernameReserved {
+		return err.Error(), nil
+	}
+
+	if err != nil {
+		return "", err
+	}
+
+	return "", nil
+}
+
+// ImportTokensFrom copies every access token listed by source in to the database,
+// preserving IDs, access tokens and timestamps. Tokens whose access token belongs to a
+// different token are always skipped (or fail the import when opts.Conflict is
+// IMPORT_CONFLICT_FAIL).
+func (db *DynamoDBAccessTokensDatabase) ImportTokensFrom(ctx context.Context, source AccessTokensLister, opts *BackendImportOptions) (*BackendImportResult, error) {
+
+	err := validateBackendImportOptions(opts)
+
+	if err != nil {
+		return nil, err
+	}
+
+	result := &BackendImportResult{}
+
+	err = source.ListAccessTokens(ctx, func(tok *token.Token) error {
+
+		if tok.ID == 0 {
+			return errors.New("Source token is missing an ID")
+		}
+
+		change, existing, err := db.planTokenImport(tok, opts.Conflict)
+
+		if err != nil {
+			return err
+		}
+
+		return applyBackendImportChange(change, opts, result, func() error {
+
+			err := putToken(db.client, db.options, tok)
+
+			if err != nil {
+				return err
+			}
+
+			action := AUDIT_ACTION_ADD
+
+			if change.Action == BACKEND_IMPORT_UPDATE {
+				action = AUDIT_ACTION_UPDATE
+			}
+
+			db.audit(action, tok.ID, existing, tok)
+			return nil
+		})
+	})
+
+	return result, err
+}
+
+func (db *DynamoDBAccessTokensDatabase) planTokenImport(tok *token.Token, conflict string) (*BackendImportChange, *token.Token, error) {
+
+	change := &BackendImportChange{
+		Target: SCHEMA_TARGET_TOKENS,
+		ID:     tok.ID,
+	}
+
+	existing, err := db.GetTokenByIDWithReadOptions(tok.ID, &ReadOptions{ConsistentRead: true})
+
+	if err != nil && !database.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	if err != nil || existing == nil || existing.ID == 0 {
+		existing = nil
+	}
+
+	changes, err := auditChanges(existing, tok)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	change.Changes = changes
+
+	if existing != nil && len(changes) == 0 {
+		change.Action = BACKEND_IMPORT_UNCHANGED
+		return change, existing, nil
+	}
+
+	other, err := db.GetTokenByAccessToken(tok.AccessToken)
+
+	if err != nil && !database.IsNotExist(err) {
+		return nil, nil, err
+	}
+
+	if err == nil && other != nil && other.ID != tok.ID {
+		change, err := conflictingImportChange(change, "Access token belongs to another token", IMPORT_CONFLICT_SKIP, conflict)
+		return change, existing, err
+	}
+
+	if existing == nil {
+		change.Action = BACKEND_IMPORT_ADD
+		return change, existing, nil
+	}
+
+	change, err = conflictingImportChange(change, "Token already exists with different values", IMPORT_CONFLICT_OVERWRITE, conflict)
+	return change, existing, err
+}
+
+// conflictingImportChange resolves a conflict using policy, which is downgraded to
+// max_policy if it would otherwise overwrite something that can not be overwritten.
+func conflictingImportChange(change *BackendImportChange, reason string, max_policy string, policy string) (*BackendImportChange, error) {
+
+	change.Reason = reason
+
+	if policy == IMPORT_CONFLICT_FAIL {
+		return nil, fmt.Errorf("Failed to import %s %d, %s", change.Target, change.ID, reason)
+	}
+
+	if policy == IMPORT_CONFLICT_OVERWRITE && max_policy == IMPORT_CONFLICT_OVERWRITE {
+		change.Action = BACKEND_IMPORT_UPDATE
+		return change, nil
+	}
+
+	change.Action = BACKEND_IMPORT_SKIP
+	return change, nil
+}
+
+func applyBackendImportChange(change *BackendImportChange, opts *BackendImportOptions, result *BackendImportResult, write func() error) error {
+
+	if opts.Report != nil {
+
+		err := opts.Report(change)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	switch change.Action {
+	case BACKEND_IMPORT_UNCHANGED:
+		result.Unchanged += 1
+		return nil
+	case BACKEND_IMPORT_SKIP:
+		result.Skipped += 1
+		return nil
+	case BACKEND_IMPORT_ADD:
+		result.Added += 1
+	case BACKEND_IMPORT_UPDATE:
+		result.Updated += 1
+	}
+
+	if opts.DryRun {
+		return nil
+	}
+
+	return write()
+}
+
+func validateBackendImportOptions(opts *BackendImportOptions) error {
+
+	switch opts.Conflict {
+	case IMPORT_CONFLICT_SKIP, IMPORT_CONFLICT_OVERWRITE, IMPORT_CONFLICT_FAIL:
+		return nil
+	default:
+		return fmt.Errorf("Invalid conflict policy '%s'", opts.Conflict)
+	}
+}
+
+// AccountIDsFunc calls cb with the ID of every account in a database.
+type AccountIDsFunc func(ctx context.Context, cb func(int64) error) error
+
+// AccountsDatabaseLister wraps another go-auth database.AccountsDatabase implementation as
+// an AccountsLister. Since that interface has no way to enumerate accounts each ID listed
+// by IDs is fetched with GetAccountByID, skipping IDs that no longer exist. If IDs is nil
+// the database must implement AccountsLister itself.
+type AccountsDatabaseLister struct {
+	Database database.AccountsDatabase
+	IDs      AccountIDsFunc
+}
+
+func NewAccountsDatabaseLister(db database.AccountsDatabase, ids AccountIDsFunc) *AccountsDatabaseLister {
+
+	l := AccountsDatabaseLister{
+		Database: db,
+		IDs:      ids,
+	}
+
+	return &l
+}
+
+func (l *AccountsDatabaseLister) ListAccounts(ctx context.Context, cb ListAccountsFunc) error {
+
+	if l.IDs == nil {
+
+		lister, ok := l.Database.(AccountsLister)
+
+		if !ok {
+			return errors.New("Database can not list its accounts, a list of account IDs is required")
+		}
+
+		return lister.ListAccounts(ctx, cb)
+	}
+
+	return l.IDs(ctx, func(id int64) error {
+
+		acct, err := l.Database.GetAccountByID(id)
+
+		if database.IsNotExist(err) {
+			return nil
+		}
+
+		if err != nil {
+			return fmt.Errorf("Failed to read account %d, %s", id, err)
+		}
+
+		return cb(acct)
+	})
+}
+
+// AccountIDsFromFile returns an AccountIDsFunc that reads account IDs from path, one per
+// line. Blank lines and lines starting with "#" are ignored.
+func AccountIDsFromFile(path string) AccountIDsFunc {
+
+	return func(ctx context.Context, cb func(int64) error) error {
+
+		fh, err := os.Open(path)
+
+		if err != nil {
+			return err
+		}
+
+		defer fh.Close()
+
+		scanner := bufio.NewScanner(fh)
+
+		for scanner.Scan() {
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			default:
+				// pass
+			}
+
+			ln := strings.TrimSpace(scanner.Text())
+
+			if ln == "" || strings.HasPrefix(ln, "#") {
+				continue
+			}
+
+			id, err := strconv.ParseInt(ln, 10, 64)
+
+			if err != nil {
+				return fmt.Errorf("Invalid account ID '%s', %s", ln, err)
+			}
+
+			err = cb(id)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return scanner.Err()
+	}
+}
+
+// JSONSource lists go-auth accounts or access tokens encoded as JSON, for importing from
+// backends that can dump their records but can not be read directly. Path is either a
+// file with one record per line or a directory of ".json" files with one record each.
+type JSONSource struct {
+	Path string
+}
+
+func NewJSONSource(path string) *JSONSource {
+
+	s := JSONSource{
+		Path: path,
+	}
+
+	return &s
+}
+
+func (s *JSONSource) ListAccounts(ctx context.Context, cb ListAccountsFunc) error {
+
+	return s.each(ctx, func(body []byte) error {
+
+		var acct *account.Account
+
+		err := json.Unmarshal(body, &acct)
+
+		if err != nil {
+			return err
+		}
+
+		return cb(acct)
+	})
+}
+
+func (s *JSONSource) ListAccessTokens(ctx context.Context, cb database.ListAccessTokensFunc) error {
+
+	return s.each(ctx, func(body []byte) error {
+
+		var tok *token.Token
+
+		err := json.Unmarshal(body, &tok)
+
+		if err != nil {
+			return err
+		}
+
+		return cb(tok)
+	})
+}
+
+func (s *JSONSource) each(ctx context.Context, cb func([]byte) error) error {
+
+	info, err := os.Stat(s.Path)
+
+	if err != nil {
+		return err
+	}
+
+	if !info.IsDir() {
+		return s.eachLine(ctx, cb)
+	}
+
+	return filepath.Walk(s.Path, func(path string, info os.FileInfo, err error) error {
+
+		if err != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		if info.IsDir() || !strings.HasSuffix(path, ".json") {
+			return nil
+		}
+
+		body, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to import %s, %s", path, err)
+		}
+
+		return nil
+	})
+}
+
+func (s *JSONSource) eachLine(ctx context.Context, cb func([]byte) error) error {
+
+	fh, err := os.Open(s.Path)
+
+	if err != nil {
+		return err
+	}
+
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	ln := 0
+
+	for scanner.Scan() {
+
+		ln += 1
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		body := scanner.Bytes()
+
+		if len(body) == 0 {
+			continue
+		}
+
+		err := cb(body)
+
+		if err != nil {
+			return fmt.Errorf("Failed to import line %d, %s", ln, err)
+		}
+	}
+
+	return scanner.Err()
+}
@@ -0,0 +1,107 @@
+package dynamodb
+
+import (
+	"context"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-auth/database"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testAccountsDatabase is a go-auth database.AccountsDatabase that can not list its accounts.
+type testAccountsDatabase struct {
+	database.AccountsDatabase
+	accounts map[int64]*account.Account
+}
+
+func (db *testAccountsDatabase) GetAccountByID(id int64) (*account.Account, error) {
+	return db.accounts[id], nil
+}
+
+func TestAccountsDatabaseLister(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "ids")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "ids")
+
+	err = ioutil.WriteFile(path, []byte("# accounts\n1\n\n2\n"), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db := &testAccountsDatabase{
+		accounts: map[int64]*account.Account{
+			1: {ID: 1},
+			2: {ID: 2},
+			3: {ID: 3},
+		},
+	}
+
+	ids := make([]int64, 0)
+
+	l := NewAccountsDatabaseLister(db, AccountIDsFromFile(path))
+
+	err = l.ListAccounts(context.Background(), func(acct *account.Account) error {
+		ids = append(ids, acct.ID)
+		return nil
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(ids) != 2 || ids[0] != 1 || ids[1] != 2 {
+		t.Fatalf("Unexpected accounts %v", ids)
+	}
+
+	l = NewAccountsDatabaseLister(db, nil)
+
+	err = l.ListAccounts(context.Background(), func(acct *account.Account) error {
+		return nil
+	})
+
+	if err == nil {
+		t.Fatal("Expected listing a database without IDs to fail")
+	}
+}
+
+func TestAccountImportCollisionMissingFields(t *testing.T) {
+
+	db := &DynamoDBAccountsDatabase{
+		options: DefaultDynamoDBAccountsDatabaseOptions(),
+	}
+
+	reason, err := db.accountImportCollision(&account.Account{ID: 1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reason == "" {
+		t.Fatal("Expected an account without an email address to collide")
+	}
+
+	acct := &account.Account{
+		ID:      1,
+		Address: &account.Address{URI: "bob@example.com"},
+	}
+
+	reason, err = db.accountImportCollision(acct)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reason == "" {
+		t.Fatal("Expected an account without a username to collide")
+	}
+}
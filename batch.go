@@ -0,0 +1,242 @@
+package dynamodb
+
+import (
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-auth/token"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"time"
+)
+
+// DynamoDB will not accept more than this many keys in a single BatchGetItem request.
+const BATCH_GET_MAX_KEYS int = 100
+
+// DynamoDB will not accept more than this many requests in a single BatchWriteItem request.
+const BATCH_WRITE_MAX_ITEMS int = 25
+
+// GetAccountsByIDs returns the accounts matching ids keyed by account ID. IDs which
+// do not correspond to an account are returned separately as the second value.
+func (db *DynamoDBAccountsDatabase) GetAccountsByIDs(ids []int64) (map[int64]*account.Account, []int64, error) {
+
+	items, err := batchGetItems(db.client, db.options.TableName, ids, db.readOptions(), db.options.Retry)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	accounts := make(map[int64]*account.Account)
+
+	for _, item := range items {
+
+		acct, err := itemToAccount(db.options, item)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		accounts[acct.ID] = acct
+	}
+
+	missing := make([]int64, 0)
+
+	for _, id := range uniqueIDs(ids) {
+
+		_, ok := accounts[id]
+
+		if !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	return accounts, missing, nil
+}
+
+// GetTokensByIDs returns the tokens matching ids keyed by token ID. IDs which
+// do not correspond to a token are returned separately as the second value.
+func (db *DynamoDBAccessTokensDatabase) GetTokensByIDs(ids []int64) (map[int64]*token.Token, []int64, error) {
+
+	items, err := batchGetItems(db.client, db.options.TableName, ids, db.readOptions(), db.options.Retry)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tokens := make(map[int64]*token.Token)
+
+	for _, item := range items {
+
+		tok, err := itemToToken(item)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		tokens[tok.ID] = tok
+	}
+
+	missing := make([]int64, 0)
+
+	for _, id := range uniqueIDs(ids) {
+
+		_, ok := tokens[id]
+
+		if !ok {
+			missing = append(missing, id)
+		}
+	}
+
+	return tokens, missing, nil
+}
+
+func batchGetItems(client *aws_dynamodb.DynamoDB, table string, ids []int64, read_opts *ReadOptions, retry *RetryOptions) ([]map[string]*aws_dynamodb.AttributeValue, error) {
+
+	keys := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+	for _, id := range uniqueIDs(ids) {
+		keys = append(keys, idKey(id))
+	}
+
+	return batchGetKeys(client, table, keys, read_opts, retry)
+}
+
+func batchGetKeys(client *aws_dynamodb.DynamoDB, table string, keys []map[string]*aws_dynamodb.AttributeValue, read_opts *ReadOptions, retry *RetryOptions) ([]map[string]*aws_dynamodb.AttributeValue, error) {
+
+	items := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+	for start := 0; start < len(keys); start += BATCH_GET_MAX_KEYS {
+
+		end := start + BATCH_GET_MAX_KEYS
+
+		if end > len(keys) {
+			end = len(keys)
+		}
+
+		req := &aws_dynamodb.BatchGetItemInput{
+			RequestItems: map[string]*aws_dynamodb.KeysAndAttributes{
+				table: {
+					Keys:           keys[start:end],
+					ConsistentRead: aws.Bool(read_opts.ConsistentRead),
+				},
+			},
+		}
+
+		batch_items, err := batchGetItemsWithRetries(client, table, req, retry)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, batch_items...)
+	}
+
+	return items, nil
+}
+
+func batchGetItemsWithRetries(client *aws_dynamodb.DynamoDB, table string, req *aws_dynamodb.BatchGetItemInput, retry *RetryOptions) ([]map[string]*aws_dynamodb.AttributeValue, error) {
+
+	retry = retryOptionsOrDefault(retry)
+
+	items := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+	for attempt := 0; attempt < retry.Attempts(); attempt++ {
+
+		if attempt > 0 {
+			time.Sleep(retry.Delay(attempt - 1))
+		}
+
+		rsp, err := client.BatchGetItem(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, rsp.Responses[table]...)
+
+		unprocessed, ok := rsp.UnprocessedKeys[table]
+
+		if !ok || len(unprocessed.Keys) == 0 {
+			return items, nil
+		}
+
+		req = &aws_dynamodb.BatchGetItemInput{
+			RequestItems: rsp.UnprocessedKeys,
+		}
+	}
+
+	return nil, errors.New("Failed to process all keys in batch")
+}
+
+func batchWriteItems(client *aws_dynamodb.DynamoDB, table string, requests []*aws_dynamodb.WriteRequest, retry *RetryOptions) error {
+
+	for start := 0; start < len(requests); start += BATCH_WRITE_MAX_ITEMS {
+
+		end := start + BATCH_WRITE_MAX_ITEMS
+
+		if end > len(requests) {
+			end = len(requests)
+		}
+
+		req := &aws_dynamodb.BatchWriteItemInput{
+			RequestItems: map[string][]*aws_dynamodb.WriteRequest{
+				table: requests[start:end],
+			},
+		}
+
+		err := batchWriteItemsWithRetries(client, table, req, retry)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func batchWriteItemsWithRetries(client *aws_dynamodb.DynamoDB, table string, req *aws_dynamodb.BatchWriteItemInput, retry *RetryOptions) error {
+
+	retry = retryOptionsOrDefault(retry)
+
+	for attempt := 0; attempt < retry.Attempts(); attempt++ {
+
+		if attempt > 0 {
+			time.Sleep(retry.Delay(attempt - 1))
+		}
+
+		rsp, err := client.BatchWriteItem(req)
+
+		if err != nil {
+			return err
+		}
+
+		unprocessed, ok := rsp.UnprocessedItems[table]
+
+		if !ok || len(unprocessed) == 0 {
+			return nil
+		}
+
+		req = &aws_dynamodb.BatchWriteItemInput{
+			RequestItems: rsp.UnprocessedItems,
+		}
+	}
+
+	return errors.New("Failed to process all items in batch")
+}
+
+func uniqueIDs(ids []int64) []int64 {
+
+	seen := make(map[int64]bool)
+	unique := make([]int64, 0)
+
+	for _, id := range ids {
+
+		if seen[id] {
+			continue
+		}
+
+		seen[id] = true
+		unique = append(unique, id)
+	}
+
+	return unique
+}
@@ -0,0 +1,163 @@
+package dynamodb
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-auth/account"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"io/ioutil"
+	"strings"
+)
+
+// EMAIL_BLIND_INDEX_MINIMUM_KEY_SIZE is the smallest key, in bytes, accepted for the email blind index.
+const EMAIL_BLIND_INDEX_MINIMUM_KEY_SIZE int = 32
+
+// ReadEmailBlindIndexKey reads a base64-encoded email blind index key from path.
+func ReadEmailBlindIndexKey(path string) ([]byte, error) {
+
+	body, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(body)))
+
+	if err != nil {
+		return nil, fmt.Errorf("Failed to decode email blind index key, %s", err)
+	}
+
+	if len(key) < EMAIL_BLIND_INDEX_MINIMUM_KEY_SIZE {
+		return nil, errors.New("Email blind index key is too short")
+	}
+
+	return key, nil
+}
+
+// emailIndexValue returns the value stored in, and queried against, the "email" index for
+// addr. If options.EmailBlindIndexKey is set this is a (hex-encoded) HMAC-SHA256 of the
+// normalized address rather than the address itself.
+func emailIndexValue(opts *DynamoDBAccountsDatabaseOptions, addr string) string {
+
+	addr = normalizeEmailAddress(opts, addr)
+
+	if len(opts.EmailBlindIndexKey) == 0 {
+		return addr
+	}
+
+	return blindIndex(opts.EmailBlindIndexKey, addr)
+}
+
+func blindIndex(key []byte, value string) string {
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(value))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// encryptedAccountFields returns the names of the account fields to encrypt. The email
+// address is only encrypted when a blind index is being used, since otherwise it is
+// stored in cleartext in the index anyway.
+func encryptedAccountFields(opts *DynamoDBAccountsDatabaseOptions) []string {
+
+	fields := []string{
+		"password",
+		"mfa",
+	}
+
+	if len(opts.EmailBlindIndexKey) > 0 {
+		fields = append(fields, "address")
+	}
+
+	return fields
+}
+
+// auditBlindAccount returns a copy of acct whose email address has been replaced by its
+// blind index, so that the audit log does not hold addresses in cleartext.
+func auditBlindAccount(opts *DynamoDBAccountsDatabaseOptions, acct *account.Account) *account.Account {
+
+	if acct == nil || acct.Address == nil {
+		return acct
+	}
+
+	addr := *acct.Address
+	addr.URI = emailIndexValue(opts, addr.URI)
+
+	blind := *acct
+	blind.Address = &addr
+
+	return &blind
+}
+
+func validateBlindIndexOptions(opts *DynamoDBAccountsDatabaseOptions) error {
+
+	if len(opts.EmailBlindIndexKey) == 0 {
+		return nil
+	}
+
+	if len(opts.EmailBlindIndexKey) < EMAIL_BLIND_INDEX_MINIMUM_KEY_SIZE {
+		return errors.New("Email blind index key is too short")
+	}
+
+	if opts.KeyProvider == nil {
+		return errors.New("A key provider is required when using an email blind index")
+	}
+
+	return nil
+}
+
+// MigrateEmailBlindIndex rewrites every account whose "email" attribute is not the blind
+// index of its address, or whose address is not encrypted. Accounts without an address
+// are reported as invalid and accounts modified since they were read are skipped. It is
+// safe to run more than once.
+func (db *DynamoDBAccountsDatabase) MigrateEmailBlindIndex(ctx context.Context) (*RewriteResult, error) {
+
+	if len(db.options.EmailBlindIndexKey) == 0 {
+		return nil, errors.New("No email blind index key configured")
+	}
+
+	result := &RewriteResult{}
+
+	err := db.eachAccount(ctx, func(item map[string]*aws_dynamodb.AttributeValue, dynamodb_acct *DynamoDBAccount, acct *account.Account) error {
+
+		if acct.Address == nil || acct.Address.URI == "" {
+			result.Invalid = append(result.Invalid, acct.ID)
+			return nil
+		}
+
+		index_value := emailIndexValue(db.options, acct.Address.URI)
+
+		is_encrypted := dynamodb_acct.Encrypted != nil && dynamodb_acct.Encrypted.Fields["address"] != nil
+
+		if dynamodb_acct.Email == index_value && is_encrypted {
+			return nil
+		}
+
+		req, err := newAccountRewrite(db.options, item, acct, nil)
+
+		if err != nil {
+			return err
+		}
+
+		if req == nil {
+			return nil
+		}
+
+		applied, err := applyRewrite(db.client, req)
+
+		if err != nil {
+			return err
+		}
+
+		result.add(applied)
+		return nil
+	})
+
+	return result, err
+}
@@ -0,0 +1,336 @@
+package dynamodb
+
+import (
+	"context"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"sort"
+	"strconv"
+)
+
+// CHECK_DUPLICATE reports items which share a value for an indexed attribute, which
+// causes lookups by that attribute to fail with "Multiple results for key!".
+const CHECK_DUPLICATE string = "duplicate"
+
+// CHECK_ORPHANED reports access tokens whose account does not exist.
+const CHECK_ORPHANED string = "orphaned"
+
+// CHECK_MISSING_ATTRIBUTE reports items without a required attribute.
+const CHECK_MISSING_ATTRIBUTE string = "missing_attribute"
+
+// CHECK_MISMATCH reports account items whose top-level (indexed) attributes disagree with
+// the account they contain.
+const CHECK_MISMATCH string = "mismatch"
+
+// CHECK_UNREADABLE reports items which can not be decoded (or decrypted).
+const CHECK_UNREADABLE string = "unreadable"
+
+type ConsistencyProblem struct {
+	Target    string  `json:"target"`
+	Check     string  `json:"check"`
+	IDs       []int64 `json:"ids,omitempty"`
+	Attribute string  `json:"attribute,omitempty"`
+	Value     string  `json:"value,omitempty"`
+	Expected  string  `json:"expected,omitempty"`
+	Message   string  `json:"message,omitempty"`
+}
+
+type ConsistencyReport struct {
+	AccountsScanned int64                 `json:"accounts_scanned"`
+	TokensScanned   int64                 `json:"tokens_scanned"`
+	Problems        []*ConsistencyProblem `json:"problems"`
+}
+
+// CheckConsistency scans the accounts and access tokens tables and reports duplicate
+// email addresses, URLs, username skeletons and access tokens, tokens whose account no
+// longer exists, items missing required attributes and account items whose top-level
+// email, url or skeleton attributes disagree with the embedded account. Expected index
+// values are derived using accounts_db's options so its key provider, blind index key
+// and email canonicalizer should match the ones the tables were written with. Only the
+// accounts in its TableName are checked, although tokens belonging to accounts that are
+// only in its FallbackTableName are not reported as orphaned.
+func CheckConsistency(ctx context.Context, accounts_db *DynamoDBAccountsDatabase, tokens_db *DynamoDBAccessTokensDatabase) (*ConsistencyReport, error) {
+
+	report := &ConsistencyReport{
+		Problems: make([]*ConsistencyProblem, 0),
+	}
+
+	account_ids, err := checkAccounts(ctx, accounts_db, report)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = checkTokens(ctx, tokens_db, account_ids, report)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+func checkAccounts(ctx context.Context, db *DynamoDBAccountsDatabase, report *ConsistencyReport) (map[int64]bool, error) {
+
+	account_ids := make(map[int64]bool)
+
+	indexes := map[string]map[string][]int64{
+		"email":    make(map[string][]int64),
+		"url":      make(map[string][]int64),
+		"skeleton": make(map[string][]int64),
+	}
+
+	err := scanItems(ctx, db.client, db.options.TableName, func(item map[string]*aws_dynamodb.AttributeValue) error {
+
+		report.AccountsScanned += 1
+
+		id, ok := itemID(item)
+
+		if !ok {
+			report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_MISSING_ATTRIBUTE, nil, "id", "")
+			return nil
+		}
+
+		account_ids[id] = true
+
+		ids := []int64{id}
+
+		for _, attr := range []string{"email", "url", "created"} {
+
+			if !hasAttribute(item, attr) {
+				report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_MISSING_ATTRIBUTE, ids, attr, "")
+			}
+		}
+
+		for attr, values := range indexes {
+
+			v := stringAttribute(item, attr)
+
+			if v != "" {
+				values[v] = append(values[v], id)
+			}
+		}
+
+		dynamodb_acct, err := unmarshalAccountItem(item)
+
+		if err != nil {
+			report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_UNREADABLE, ids, "", err.Error())
+			return nil
+		}
+
+		acct, err := dynamodbAccountToAccount(db.options, dynamodb_acct)
+
+		if err != nil {
+			report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_UNREADABLE, ids, "", err.Error())
+			return nil
+		}
+
+		if acct.ID != id {
+			p := report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_MISMATCH, ids, "id", "")
+			p.Value = strconv.FormatInt(id, 10)
+			p.Expected = strconv.FormatInt(acct.ID, 10)
+		}
+
+		if acct.Address == nil || acct.Address.URI == "" {
+			report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_MISSING_ATTRIBUTE, ids, "address", "")
+		} else {
+			checkIndexValue(report, ids, item, "email", emailIndexValue(db.options, acct.Address.URI))
+		}
+
+		if acct.Username == nil || acct.Username.Safe == "" {
+			report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_MISSING_ATTRIBUTE, ids, "username", "")
+		} else {
+			checkIndexValue(report, ids, item, "url", urlIndexValue(db.options, acct.Username.Safe))
+		}
+
+		skeleton := usernameSkeletonValue(acct)
+
+		if skeleton != "" {
+			checkIndexValue(report, ids, item, "skeleton", skeleton)
+		}
+
+		if acct.Password == nil || acct.Password.Digest == "" {
+			report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_MISSING_ATTRIBUTE, ids, "password", "")
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	for _, attr := range []string{"email", "url", "skeleton"} {
+		report.addDuplicates(SCHEMA_TARGET_ACCOUNTS, attr, indexes[attr])
+	}
+
+	// Accounts that are still only in the fallback table are not checked, since they are
+	// never written there, but their tokens are not orphaned
+
+	for _, table := range readTables(db.options.TableName, db.options.FallbackTableName)[1:] {
+
+		err := scanItems(ctx, db.client, table, func(item map[string]*aws_dynamodb.AttributeValue) error {
+
+			id, ok := itemID(item)
+
+			if ok {
+				account_ids[id] = true
+			}
+
+			return nil
+		})
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return account_ids, nil
+}
+
+func checkTokens(ctx context.Context, db *DynamoDBAccessTokensDatabase, account_ids map[int64]bool, report *ConsistencyReport) error {
+
+	by_access_token := make(map[string][]int64)
+
+	err := scanItems(ctx, db.client, db.options.TableName, func(item map[string]*aws_dynamodb.AttributeValue) error {
+
+		report.TokensScanned += 1
+
+		id, ok := itemID(item)
+
+		if !ok {
+			report.addProblem(SCHEMA_TARGET_TOKENS, CHECK_MISSING_ATTRIBUTE, nil, "id", "")
+			return nil
+		}
+
+		ids := []int64{id}
+
+		for _, attr := range []string{"access_token", "account_id", "created"} {
+
+			if !hasAttribute(item, attr) {
+				report.addProblem(SCHEMA_TARGET_TOKENS, CHECK_MISSING_ATTRIBUTE, ids, attr, "")
+			}
+		}
+
+		tok, err := itemToToken(item)
+
+		if err != nil {
+			report.addProblem(SCHEMA_TARGET_TOKENS, CHECK_UNREADABLE, ids, "", err.Error())
+			return nil
+		}
+
+		if tok.AccessToken != "" {
+			by_access_token[tok.AccessToken] = append(by_access_token[tok.AccessToken], id)
+		}
+
+		if tok.AccountID != 0 && !account_ids[tok.AccountID] {
+			p := report.addProblem(SCHEMA_TARGET_TOKENS, CHECK_ORPHANED, ids, "account_id", "")
+			p.Value = strconv.FormatInt(tok.AccountID, 10)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return err
+	}
+
+	report.addDuplicates(SCHEMA_TARGET_TOKENS, "access_token", by_access_token)
+	return nil
+}
+
+func checkIndexValue(report *ConsistencyReport, ids []int64, item map[string]*aws_dynamodb.AttributeValue, attr string, expected string) {
+
+	v := stringAttribute(item, attr)
+
+	if v == expected {
+		return
+	}
+
+	p := report.addProblem(SCHEMA_TARGET_ACCOUNTS, CHECK_MISMATCH, ids, attr, "")
+	p.Value = v
+	p.Expected = expected
+}
+
+func (report *ConsistencyReport) addProblem(target string, check string, ids []int64, attr string, message string) *ConsistencyProblem {
+
+	p := &ConsistencyProblem{
+		Target:    target,
+		Check:     check,
+		IDs:       ids,
+		Attribute: attr,
+		Message:   message,
+	}
+
+	report.Problems = append(report.Problems, p)
+	return p
+}
+
+func (report *ConsistencyReport) addDuplicates(target string, attr string, values map[string][]int64) {
+
+	keys := make([]string, 0)
+
+	for v, ids := range values {
+
+		if len(ids) > 1 {
+			keys = append(keys, v)
+		}
+	}
+
+	sort.Strings(keys)
+
+	for _, v := range keys {
+
+		ids := values[v]
+		sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+		p := report.addProblem(target, CHECK_DUPLICATE, ids, attr, "")
+		p.Value = v
+	}
+}
+
+func itemID(item map[string]*aws_dynamodb.AttributeValue) (int64, bool) {
+
+	v, ok := item["id"]
+
+	if !ok || v.N == nil {
+		return 0, false
+	}
+
+	id, err := strconv.ParseInt(*v.N, 10, 64)
+
+	if err != nil || id == 0 {
+		return 0, false
+	}
+
+	return id, true
+}
+
+func hasAttribute(item map[string]*aws_dynamodb.AttributeValue, attr string) bool {
+
+	v, ok := item[attr]
+
+	if !ok || v == nil {
+		return false
+	}
+
+	if v.S != nil && *v.S == "" {
+		return false
+	}
+
+	if v.NULL != nil && *v.NULL {
+		return false
+	}
+
+	return true
+}
+
+func stringAttribute(item map[string]*aws_dynamodb.AttributeValue, attr string) string {
+
+	v, ok := item[attr]
+
+	if !ok || v == nil || v.S == nil {
+		return ""
+	}
+
+	return *v.S
+}
@@ -0,0 +1,100 @@
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"testing"
+)
+
+func TestAddDuplicates(t *testing.T) {
+
+	report := &ConsistencyReport{
+		Problems: make([]*ConsistencyProblem, 0),
+	}
+
+	values := map[string][]int64{
+		"bob@example.com":   {3, 1},
+		"alice@example.com": {2},
+		"eve@example.com":   {5, 4, 6},
+	}
+
+	report.addDuplicates(SCHEMA_TARGET_ACCOUNTS, "email", values)
+
+	if len(report.Problems) != 2 {
+		t.Fatalf("Expected 2 problems, got %d", len(report.Problems))
+	}
+
+	// Problems are reported in value order, with their IDs sorted
+
+	tests := []struct {
+		value string
+		ids   []int64
+	}{
+		{"bob@example.com", []int64{1, 3}},
+		{"eve@example.com", []int64{4, 5, 6}},
+	}
+
+	for i, test := range tests {
+
+		p := report.Problems[i]
+
+		if p.Check != CHECK_DUPLICATE || p.Target != SCHEMA_TARGET_ACCOUNTS || p.Attribute != "email" || p.Value != test.value {
+			t.Fatalf("Unexpected problem %v", p)
+		}
+
+		if len(p.IDs) != len(test.ids) {
+			t.Fatalf("Expected IDs %v for '%s', got %v", test.ids, test.value, p.IDs)
+		}
+
+		for j, id := range test.ids {
+
+			if p.IDs[j] != id {
+				t.Fatalf("Expected IDs %v for '%s', got %v", test.ids, test.value, p.IDs)
+			}
+		}
+	}
+}
+
+func TestCheckIndexValue(t *testing.T) {
+
+	item := map[string]*aws_dynamodb.AttributeValue{
+		"id":    {N: aws.String("1234")},
+		"email": {S: aws.String("bob@example.com")},
+		"url":   {N: aws.String("1")},
+	}
+
+	tests := []struct {
+		attr     string
+		expected string
+		mismatch bool
+	}{
+		{"email", "bob@example.com", false},
+		{"email", "robert@example.com", true},
+		{"url", "bob", true},
+		{"skeleton", "bob", true},
+		{"skeleton", "", false},
+	}
+
+	for _, test := range tests {
+
+		report := &ConsistencyReport{
+			Problems: make([]*ConsistencyProblem, 0),
+		}
+
+		checkIndexValue(report, []int64{1234}, item, test.attr, test.expected)
+
+		if (len(report.Problems) > 0) != test.mismatch {
+			t.Fatalf("Expected '%s' mismatch with '%s' to be %t", test.attr, test.expected, test.mismatch)
+		}
+
+		if !test.mismatch {
+			continue
+		}
+
+		p := report.Problems[0]
+
+		if p.Check != CHECK_MISMATCH || p.Attribute != test.attr || p.Expected != test.expected || p.Value != stringAttribute(item, test.attr) {
+			t.Fatalf("Unexpected problem %v", p)
+		}
+	}
+}
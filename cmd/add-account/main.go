@@ -9,6 +9,7 @@ import (
 	"github.com/aaronland/go-password/cli"
 	"log"
 	"os"
+	"strings"
 )
 
 func main() {
@@ -17,20 +18,48 @@ func main() {
 	username := flag.String("username", "", "...")
 	password := flag.String("password", "", "...")
 
+	mfa_output := flag.String("mfa-output", dynamodb.MFA_OUTPUT_SECRET, "How to output the new account's MFA secret. Valid options are: secret, uri, png, terminal.")
+	mfa_issuer := flag.String("mfa-issuer", "go-auth", "The issuer name to include in MFA provisioning URIs.")
+	mfa_png := flag.String("mfa-png", "", "The path to write a PNG QR code to, when -mfa-output is 'png'.")
+
 	accounts_dsn := flag.String("accounts-dsn", "", "...")
-	accounts_table := flag.String("accounts-table", dynamodb.ACCOUNTS_DEFAULT_TABLENAME, "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+
+	min_length := flag.Int("min-password-length", dynamodb.PASSWORD_DEFAULT_MINIMUM_LENGTH, "The minimum number of characters in a password.")
+	min_entropy := flag.Float64("min-password-entropy", dynamodb.PASSWORD_DEFAULT_MINIMUM_ENTROPY, "The minimum estimated entropy, in bits, of a password.")
+	breached_passwords := flag.String("breached-passwords", "", "The path to a local file, or directory of range files, of SHA-1 hashes of breached passwords.")
+
+	reserved_usernames := flag.String("reserved-usernames", "", "The path to a file of reserved usernames, one per line.")
 
 	flag.Parse()
 
-	accounts_opts := dynamodb.DefaultDynamoDBAccountsDatabaseOptions()
-	accounts_opts.TableName = *accounts_table
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts_opts.PasswordPolicy = dynamodb.NewPasswordPolicy(*min_length, *min_entropy, *breached_passwords)
+
+	if *reserved_usernames != "" {
 
-	accounts_db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+		names, err := dynamodb.ReadReservedUsernames(*reserved_usernames)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		accounts_opts.ReservedUsernames = names
+	}
+
+	db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
 	reader := bufio.NewReader(os.Stdin)
 
 	if *email == "" {
@@ -72,13 +101,16 @@ func main() {
 
 	// scrub, validate and sanity check email, password, username here...
 
+	*email = strings.TrimSpace(*email)
+	*username = strings.TrimSpace(*username)
+
 	acct, err := account.NewAccount(*email, *password, *username)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	acct, err = accounts_db.AddAccount(acct)
+	acct, err = accounts_db.AddAccountWithPassword(acct, *password)
 
 	if err != nil {
 		log.Fatal(err)
@@ -90,5 +122,11 @@ func main() {
 		log.Fatal(err)
 	}
 
-	log.Println(acct.ID, secret)
+	log.Println(acct.ID)
+
+	err = dynamodb.WriteMFAProvisioning(os.Stdout, *mfa_output, *mfa_issuer, acct.Address.URI, secret, *mfa_png)
+
+	if err != nil {
+		log.Fatal(err)
+	}
 }
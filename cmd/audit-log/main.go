@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+	"os"
+	"time"
+)
+
+func main() {
+
+	target_type := flag.String("target-type", dynamodb.AUDIT_TARGET_ACCOUNT, "The type of target to query. Valid options are: account, token.")
+	target_id := flag.Int64("id", 0, "The ID of the account or token to query.")
+	since := flag.Duration("since", 24*time.Hour, "Return entries recorded within this duration of now.")
+
+	audit_dsn := flag.String("audit-log-dsn", "", "...")
+	audit_table := flag.String("audit-log-table", dynamodb.AUDITLOG_DEFAULT_TABLENAME, "...")
+
+	flag.Parse()
+
+	audit_opts := dynamodb.DefaultDynamoDBAuditLogOptions()
+	audit_opts.TableName = *audit_table
+
+	audit_log, err := dynamodb.NewDynamoDBAuditLogWithDSN(*audit_dsn, audit_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	now := time.Now()
+
+	entries, err := audit_log.Query(*target_type, *target_id, now.Add(-*since), now)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	for _, entry := range entries {
+
+		err := enc.Encode(entry)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
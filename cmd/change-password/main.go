@@ -12,39 +12,49 @@ func main() {
 	email := flag.String("email", "", "...")
 
 	accounts_dsn := flag.String("accounts-dsn", "", "...")
-	accounts_table := flag.String("accounts-table", dynamodb.ACCOUNTS_DEFAULT_TABLENAME, "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+
+	history_size := flag.Int("password-history", dynamodb.PASSWORD_HISTORY_DEFAULT_SIZE, "The number of previous passwords that can not be reused.")
+
+	min_length := flag.Int("min-password-length", dynamodb.PASSWORD_DEFAULT_MINIMUM_LENGTH, "The minimum number of characters in a password.")
+	min_entropy := flag.Float64("min-password-entropy", dynamodb.PASSWORD_DEFAULT_MINIMUM_ENTROPY, "The minimum estimated entropy, in bits, of a password.")
+	breached_passwords := flag.String("breached-passwords", "", "The path to a local file, or directory of range files, of SHA-1 hashes of breached passwords.")
 
 	flag.Parse()
 
-	accounts_opts := dynamodb.DefaultDynamoDBAccountsDatabaseOptions()
-	accounts_opts.TableName = *accounts_table
+	pswd_policy := dynamodb.NewPasswordPolicy(*min_length, *min_entropy, *breached_passwords)
 
-	accounts_db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	acct, err := accounts_db.GetAccountByEmailAddress(*email)
+	accounts_opts.PasswordHistorySize = *history_size
+	accounts_opts.PasswordPolicy = pswd_policy
+
+	db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	pswd_opts := cli.DefaultGetPasswordOptions()
-	pswd, err := cli.GetPassword(pswd_opts)
+	accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+	acct, err := accounts_db.GetAccountByEmailAddress(*email)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	acct, err = acct.UpdatePassword(pswd)
+	pswd_opts := cli.DefaultGetPasswordOptions()
+	pswd, err := cli.GetPassword(pswd_opts)
 
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	acct, err = accounts_db.UpdateAccount(acct)
+	acct, err = accounts_db.ChangePassword(acct, pswd)
 
 	if err != nil {
 		log.Fatal(err)
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+	"os"
+)
+
+func main() {
+
+	accounts_dsn := flag.String("accounts-dsn", "", "...")
+	tokens_dsn := flag.String("tokens-dsn", "", "...")
+	aws_dsn := flag.String("aws-dsn", "", "...")
+
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+	tokens_table := flag.String("tokens-table", dynamodb.ACCESSTOKENS_DEFAULT_TABLENAME, "...")
+
+	flag.Parse()
+
+	if *aws_dsn != "" {
+
+		if *accounts_dsn == "" {
+			*accounts_dsn = *aws_dsn
+		}
+
+		if *tokens_dsn == "" {
+			*tokens_dsn = *aws_dsn
+		}
+	}
+
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts_db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	tokens_opts := dynamodb.DefaultDynamoDBAccessTokensDatabaseOptions()
+	tokens_opts.TableName = *tokens_table
+
+	tokens_db, err := dynamodb.NewDynamoDBAccessTokensDatabaseWithDSN(*tokens_dsn, tokens_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	report, err := dynamodb.CheckConsistency(ctx, accounts_db.(*dynamodb.DynamoDBAccountsDatabase), tokens_db.(*dynamodb.DynamoDBAccessTokensDatabase))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	for _, p := range report.Problems {
+
+		err := enc.Encode(p)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	log.Printf("Scanned %d accounts and %d tokens, found %d problems\n", report.AccountsScanned, report.TokensScanned, len(report.Problems))
+
+	if len(report.Problems) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+	"os"
+)
+
+func main() {
+
+	source_dsn := flag.String("source-dsn", "", "The DSN (region, credentials and optionally endpoint) of the table to copy from.")
+	source_table := flag.String("source-table", "", "The name of the table to copy from.")
+	dest_dsn := flag.String("destination-dsn", "", "The DSN of the table to copy to. If empty -source-dsn is used.")
+	dest_table := flag.String("destination-table", "", "The name of the table to copy to.")
+
+	segments := flag.Int("segments", dynamodb.COPY_DEFAULT_SEGMENTS, "The number of parallel scan segments to read the source table with.")
+	transform := flag.String("transform", "", "An optional transformation to apply to each item. Valid options are: migrate.")
+	target := flag.String("target", dynamodb.SCHEMA_TARGET_ACCOUNTS, "The type of items being copied, for use with -transform migrate. Valid options are: accounts, tokens.")
+	version := flag.Int("version", 0, "The schema version to migrate items to, for use with -transform migrate. If 0 accounts are migrated to the -account-schema format, so that they match what is written, and tokens to the latest registered version.")
+	account_schema := flag.Int("account-schema", dynamodb.ACCOUNT_SCHEMA_NESTED, "The item format accounts are written in, for use with -transform migrate. Valid options are: 1 (nested) or 2 (flat).")
+	verify := flag.Bool("verify", true, "Count the items in both tables once the copy is complete. Verification fails if the destination holds items that are not in the source, which with -overwrite includes items that were already there.")
+	overwrite := flag.Bool("overwrite", false, "Overwrite items that already exist in the destination table. By default they are left untouched, so that items written to the destination since it was put in to service are not reverted.")
+
+	flag.Parse()
+
+	if *source_table == "" || *dest_table == "" {
+		log.Fatal("Missing -source-table or -destination-table")
+	}
+
+	if *dest_dsn == "" {
+		*dest_dsn = *source_dsn
+	}
+
+	if *source_dsn == *dest_dsn && *source_table == *dest_table {
+		log.Fatal("Source and destination tables are the same")
+	}
+
+	retry := dynamodb.DefaultRetryOptions()
+
+	source, err := dynamodb.NewDynamoDBClientWithDSN(*source_dsn, retry)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	dest, err := dynamodb.NewDynamoDBClientWithDSN(*dest_dsn, retry)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	copy_opts := &dynamodb.CopyOptions{
+		Segments:  *segments,
+		Retry:     retry,
+		Verify:    *verify,
+		Overwrite: *overwrite,
+		Progress: func(result *dynamodb.CopyResult) {
+			log.Printf("Scanned %d items, wrote %d, skipped %d, dropped %d\n", result.Scanned, result.Written, result.Skipped, result.Dropped)
+		},
+	}
+
+	switch *transform {
+	case "":
+		// pass
+	case "migrate":
+
+		if *version == 0 && *target == dynamodb.SCHEMA_TARGET_ACCOUNTS {
+
+			if *account_schema != dynamodb.ACCOUNT_SCHEMA_NESTED && *account_schema != dynamodb.ACCOUNT_SCHEMA_FLAT {
+				log.Fatalf("Invalid -account-schema %d", *account_schema)
+			}
+
+			*version = *account_schema
+		}
+
+		copy_opts.Transform = dynamodb.MigrationTransform(*target, *version)
+	default:
+		log.Fatalf("Invalid transform '%s'", *transform)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := dynamodb.CopyTable(ctx, source, *source_table, dest, *dest_table, copy_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	err = enc.Encode(result)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *verify && !result.Verified {
+		log.Fatalf("Verification failed, %d items in source and %d in destination (%d written, %d skipped, %d dropped)", result.SourceCount, result.DestinationCount, result.Written, result.Skipped, result.Dropped)
+	}
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+
+	target := flag.String("target", dynamodb.SCHEMA_TARGET_ACCOUNTS, "The table to export. Valid options are: accounts, tokens.")
+	output := flag.String("output", "", "The path to write JSONL to. If empty records are written to STDOUT.")
+	redact := flag.Bool("redact", false, "Replace passwords, MFA secrets, encrypted fields and access tokens with a placeholder. Redacted exports can not be imported.")
+
+	dsn := flag.String("dsn", "", "...")
+	accounts_table := flag.String("accounts-table", dynamodb.ACCOUNTS_DEFAULT_TABLENAME, "...")
+	tokens_table := flag.String("access-tokens-table", dynamodb.ACCESSTOKENS_DEFAULT_TABLENAME, "...")
+
+	flag.Parse()
+
+	var wr io.Writer
+
+	wr = os.Stdout
+
+	if *output != "" {
+
+		// Exports contain password hashes and (possibly encrypted) secrets so make sure
+		// that they are only readable by their owner
+
+		fh, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		err = fh.Chmod(0600)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer fh.Close()
+
+		wr = fh
+	}
+
+	export_opts := &dynamodb.ExportOptions{
+		Redact: *redact,
+		Progress: func(count int64) {
+			log.Printf("Exported %d items\n", count)
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	switch *target {
+	case dynamodb.SCHEMA_TARGET_ACCOUNTS:
+
+		accounts_opts := dynamodb.DefaultDynamoDBAccountsDatabaseOptions()
+		accounts_opts.TableName = *accounts_table
+
+		db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*dsn, accounts_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+		_, err = accounts_db.Export(ctx, wr, export_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case dynamodb.SCHEMA_TARGET_TOKENS:
+
+		tokens_opts := dynamodb.DefaultDynamoDBAccessTokensDatabaseOptions()
+		tokens_opts.TableName = *tokens_table
+
+		db, err := dynamodb.NewDynamoDBAccessTokensDatabaseWithDSN(*dsn, tokens_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tokens_db := db.(*dynamodb.DynamoDBAccessTokensDatabase)
+
+		_, err = tokens_db.Export(ctx, wr, export_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	default:
+		log.Fatalf("Invalid target '%s'", *target)
+	}
+}
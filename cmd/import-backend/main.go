@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+	"os"
+)
+
+func main() {
+
+	target := flag.String("target", dynamodb.SCHEMA_TARGET_ACCOUNTS, "The records to import. Valid options are: accounts, tokens.")
+	conflict := flag.String("conflict", dynamodb.IMPORT_CONFLICT_FAIL, "What to do when a record already exists with different values. Valid options are: skip, overwrite, fail.")
+	dry_run := flag.Bool("dry-run", false, "Report what would change, as JSONL, without writing anything.")
+
+	source := flag.String("source", "json", "The type of backend to import from. Valid options are: json, dynamodb. Other go-auth backends can be dumped as JSON, or imported using the ImportAccountsFrom and ImportTokensFrom methods.")
+	source_path := flag.String("source-path", "", "The path to a JSONL file, or a directory of .json files, of go-auth records. Required when -source is 'json'.")
+	source_dsn := flag.String("source-dsn", "", "The DSN of the DynamoDB backend to import from. Required when -source is 'dynamodb'.")
+	source_accounts_table := flag.String("source-accounts-table", dynamodb.ACCOUNTS_DEFAULT_TABLENAME, "...")
+	source_tokens_table := flag.String("source-access-tokens-table", dynamodb.ACCESSTOKENS_DEFAULT_TABLENAME, "...")
+	source_key_provider_uri := flag.String("source-key-provider", "", "A URI for the key provider used to decrypt sensitive account fields in the source backend.")
+
+	dsn := flag.String("dsn", "", "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+	tokens_table := flag.String("access-tokens-table", dynamodb.ACCESSTOKENS_DEFAULT_TABLENAME, "...")
+
+	flag.Parse()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	enc := json.NewEncoder(os.Stdout)
+
+	import_opts := &dynamodb.BackendImportOptions{
+		Conflict: *conflict,
+		DryRun:   *dry_run,
+	}
+
+	if *dry_run {
+
+		import_opts.Report = func(change *dynamodb.BackendImportChange) error {
+
+			if change.Action == dynamodb.BACKEND_IMPORT_UNCHANGED {
+				return nil
+			}
+
+			return enc.Encode(change)
+		}
+	}
+
+	var json_source *dynamodb.JSONSource
+
+	switch *source {
+	case "json":
+
+		if *source_path == "" {
+			log.Fatal("Missing -source-path")
+		}
+
+		json_source = dynamodb.NewJSONSource(*source_path)
+
+	case "dynamodb":
+
+		if *source_dsn == "" {
+			log.Fatal("Missing -source-dsn")
+		}
+
+	default:
+		log.Fatalf("Invalid source '%s'", *source)
+	}
+
+	var result *dynamodb.BackendImportResult
+
+	switch *target {
+	case dynamodb.SCHEMA_TARGET_ACCOUNTS:
+
+		var lister dynamodb.AccountsLister
+
+		if json_source != nil {
+			lister = json_source
+		} else {
+
+			source_opts := dynamodb.DefaultDynamoDBAccountsDatabaseOptions()
+			source_opts.TableName = *source_accounts_table
+
+			if *source_key_provider_uri != "" {
+
+				key_provider, err := dynamodb.NewKeyProviderFromURI(*source_key_provider_uri)
+
+				if err != nil {
+					log.Fatal(err)
+				}
+
+				source_opts.KeyProvider = key_provider
+			}
+
+			source_db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*source_dsn, source_opts)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			lister = source_db.(*dynamodb.DynamoDBAccountsDatabase)
+		}
+
+		accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*dsn, accounts_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+		result, err = accounts_db.ImportAccountsFrom(ctx, lister, import_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case dynamodb.SCHEMA_TARGET_TOKENS:
+
+		var lister dynamodb.AccessTokensLister
+
+		if json_source != nil {
+			lister = json_source
+		} else {
+
+			source_opts := dynamodb.DefaultDynamoDBAccessTokensDatabaseOptions()
+			source_opts.TableName = *source_tokens_table
+
+			source_db, err := dynamodb.NewDynamoDBAccessTokensDatabaseWithDSN(*source_dsn, source_opts)
+
+			if err != nil {
+				log.Fatal(err)
+			}
+
+			lister = source_db
+		}
+
+		tokens_opts := dynamodb.DefaultDynamoDBAccessTokensDatabaseOptions()
+		tokens_opts.TableName = *tokens_table
+
+		db, err := dynamodb.NewDynamoDBAccessTokensDatabaseWithDSN(*dsn, tokens_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tokens_db := db.(*dynamodb.DynamoDBAccessTokensDatabase)
+
+		result, err = tokens_db.ImportTokensFrom(ctx, lister, import_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	default:
+		log.Fatalf("Invalid target '%s'", *target)
+	}
+
+	if *dry_run {
+		log.Printf("Dry run: would add %d, update %d, skip %d (%d unchanged)\n", result.Added, result.Updated, result.Skipped, result.Unchanged)
+		return
+	}
+
+	err := enc.Encode(result)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"io"
+	"log"
+	"os"
+)
+
+func main() {
+
+	target := flag.String("target", dynamodb.SCHEMA_TARGET_ACCOUNTS, "The table to import in to. Valid options are: accounts, tokens.")
+	input := flag.String("input", "", "The path to read JSONL from. If empty records are read from STDIN.")
+	conflict := flag.String("conflict", dynamodb.IMPORT_CONFLICT_FAIL, "What to do when an item already exists. Valid options are: skip, overwrite, fail. When reading from -input every record is checked before any are written; when reading from STDIN batches written before a conflict is found are kept.")
+
+	dsn := flag.String("dsn", "", "...")
+	accounts_table := flag.String("accounts-table", dynamodb.ACCOUNTS_DEFAULT_TABLENAME, "...")
+	tokens_table := flag.String("access-tokens-table", dynamodb.ACCESSTOKENS_DEFAULT_TABLENAME, "...")
+
+	flag.Parse()
+
+	var rd io.Reader
+
+	rd = os.Stdin
+
+	if *input != "" {
+
+		fh, err := os.Open(*input)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		defer fh.Close()
+
+		rd = fh
+	}
+
+	import_opts := &dynamodb.ImportOptions{
+		Conflict: *conflict,
+		Progress: func(result *dynamodb.ImportResult) {
+			log.Printf("Read %d items, wrote %d (%d overwritten), skipped %d\n", result.Read, result.Written, result.Overwritten, result.Skipped)
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var result *dynamodb.ImportResult
+
+	switch *target {
+	case dynamodb.SCHEMA_TARGET_ACCOUNTS:
+
+		accounts_opts := dynamodb.DefaultDynamoDBAccountsDatabaseOptions()
+		accounts_opts.TableName = *accounts_table
+
+		db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*dsn, accounts_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+		result, err = accounts_db.Import(ctx, rd, import_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case dynamodb.SCHEMA_TARGET_TOKENS:
+
+		tokens_opts := dynamodb.DefaultDynamoDBAccessTokensDatabaseOptions()
+		tokens_opts.TableName = *tokens_table
+
+		db, err := dynamodb.NewDynamoDBAccessTokensDatabaseWithDSN(*dsn, tokens_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tokens_db := db.(*dynamodb.DynamoDBAccessTokensDatabase)
+
+		result, err = tokens_db.Import(ctx, rd, import_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	default:
+		log.Fatalf("Invalid target '%s'", *target)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	err := enc.Encode(result)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+}
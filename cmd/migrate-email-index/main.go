@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+)
+
+func main() {
+
+	accounts_dsn := flag.String("accounts-dsn", "", "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+
+	flag.Parse()
+
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if accounts_opts.KeyProvider == nil {
+		log.Fatal("Missing -key-provider")
+	}
+
+	if len(accounts_opts.EmailBlindIndexKey) == 0 {
+		log.Fatal("Missing -email-index-key")
+	}
+
+	db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := accounts_db.MigrateEmailBlindIndex(ctx)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, id := range result.Invalid {
+		log.Printf("Account %d has no email address and was not migrated\n", id)
+	}
+
+	log.Printf("Migrated %d accounts, skipped %d that changed while being read\n", result.Updated, result.Skipped)
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+
+	target := flag.String("target", dynamodb.SCHEMA_TARGET_ACCOUNTS, "The table to migrate. Valid options are: accounts, tokens.")
+	version := flag.Int("version", 0, "The schema version to migrate items to. If 0 accounts are migrated to the -account-schema format, so that they match what is written, and tokens to the latest registered version.")
+	dry_run := flag.Bool("dry-run", false, "Report what would be migrated without writing anything.")
+	page_size := flag.Int64("page-size", 100, "The number of items to read per scan request.")
+	checkpoint_path := flag.String("checkpoint", "", "The path to a file recording progress. If it exists the migration resumes from it, and it is removed when the migration completes.")
+
+	dsn := flag.String("dsn", "", "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+	tokens_table := flag.String("access-tokens-table", dynamodb.ACCESSTOKENS_DEFAULT_TABLENAME, "...")
+
+	flag.Parse()
+
+	resume := ""
+
+	if *checkpoint_path != "" {
+
+		body, err := ioutil.ReadFile(*checkpoint_path)
+
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+
+		resume = strings.TrimSpace(string(body))
+
+		if resume != "" {
+			log.Printf("Resuming from %s\n", *checkpoint_path)
+		}
+	}
+
+	migrate_opts := &dynamodb.MigrationOptions{
+		Target:   *version,
+		DryRun:   *dry_run,
+		PageSize: *page_size,
+		Resume:   resume,
+		Callback: func(result *dynamodb.MigrationResult) error {
+
+			log.Printf("Scanned %d items, migrated %d, stamped %d, skipped %d\n", result.Scanned, result.Migrated, result.Stamped, result.Skipped)
+
+			if *checkpoint_path == "" || *dry_run {
+				return nil
+			}
+
+			return ioutil.WriteFile(*checkpoint_path, []byte(result.Checkpoint), 0600)
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var result *dynamodb.MigrationResult
+
+	switch *target {
+	case dynamodb.SCHEMA_TARGET_ACCOUNTS:
+
+		accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*dsn, accounts_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+		result, err = accounts_db.Migrate(ctx, migrate_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	case dynamodb.SCHEMA_TARGET_TOKENS:
+
+		tokens_opts := dynamodb.DefaultDynamoDBAccessTokensDatabaseOptions()
+		tokens_opts.TableName = *tokens_table
+
+		db, err := dynamodb.NewDynamoDBAccessTokensDatabaseWithDSN(*dsn, tokens_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		tokens_db := db.(*dynamodb.DynamoDBAccessTokensDatabase)
+		result, err = tokens_db.Migrate(ctx, migrate_opts)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+	default:
+		log.Fatalf("Invalid target '%s'", *target)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	err := enc.Encode(result)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *checkpoint_path != "" && !*dry_run {
+
+		err := os.Remove(*checkpoint_path)
+
+		if err != nil && !os.IsNotExist(err) {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+	"os"
+)
+
+func main() {
+
+	accounts_dsn := flag.String("accounts-dsn", "", "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+
+	apply := flag.Bool("apply", false, "Rewrite the email and url indexes of accounts that are not normalized. This will fail if there are any collisions.")
+
+	flag.Parse()
+
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	collisions, invalid, err := accounts_db.FindIndexCollisions(ctx)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+
+	for _, c := range collisions {
+
+		err := enc.Encode(c)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	for _, id := range invalid {
+		log.Printf("Account %d has no email address or username and can not be normalized\n", id)
+	}
+
+	log.Printf("Found %d collisions\n", len(collisions))
+
+	if !*apply {
+		return
+	}
+
+	if len(collisions) > 0 {
+		log.Fatal("Refusing to normalize indexes until collisions have been resolved")
+	}
+
+	result, err := accounts_db.NormalizeAccountIndexes(ctx)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Normalized %d accounts, skipped %d that changed while being read\n", result.Updated, result.Skipped)
+}
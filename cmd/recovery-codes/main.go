@@ -0,0 +1,62 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+)
+
+func main() {
+
+	email := flag.String("email", "", "...")
+	count := flag.Int("count", dynamodb.MFA_RECOVERY_CODES_DEFAULT_COUNT, "...")
+	remaining := flag.Bool("remaining", false, "Only report the number of unused recovery codes, rather than regenerating them.")
+
+	accounts_dsn := flag.String("accounts-dsn", "", "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+
+	flag.Parse()
+
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+	acct, err := accounts_db.GetAccountByEmailAddress(*email)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *remaining {
+
+		n, err := accounts_db.CountMFARecoveryCodes(acct)
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		fmt.Println(n)
+		return
+	}
+
+	codes, err := accounts_db.GenerateMFARecoveryCodes(acct, *count)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	for _, code := range codes {
+		fmt.Println(code)
+	}
+}
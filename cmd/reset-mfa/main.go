@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+	"os"
+	"strings"
+)
+
+func main() {
+
+	email := flag.String("email", "", "...")
+	code := flag.String("code", "", "A code from the newly enrolled authenticator. If empty you will be prompted for one.")
+
+	mfa_output := flag.String("mfa-output", dynamodb.MFA_OUTPUT_TERMINAL, "How to output the new MFA secret. Valid options are: secret, uri, png, terminal.")
+	mfa_issuer := flag.String("mfa-issuer", "go-auth", "The issuer name to include in MFA provisioning URIs.")
+	mfa_png := flag.String("mfa-png", "", "The path to write a PNG QR code to, when -mfa-output is 'png'.")
+
+	accounts_dsn := flag.String("accounts-dsn", "", "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+
+	flag.Parse()
+
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+	acct, err := accounts_db.GetAccountByEmailAddress(*email)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	key, err := dynamodb.NewMFAKey(*mfa_issuer, acct)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = dynamodb.WriteMFAProvisioning(os.Stdout, *mfa_output, *mfa_issuer, acct.Address.URI, key.Secret(), *mfa_png)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if *code == "" {
+
+		reader := bufio.NewReader(os.Stdin)
+
+		fmt.Print("Code: ")
+
+		str_code, err := reader.ReadString('\n')
+
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		*code = str_code
+	}
+
+	_, err = accounts_db.ResetMFA(acct, key.Secret(), strings.TrimSpace(*code))
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Println("OK")
+}
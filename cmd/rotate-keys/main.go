@@ -0,0 +1,68 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+)
+
+func main() {
+
+	accounts_dsn := flag.String("accounts-dsn", "", "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+	devices_table := flag.String("mfa-devices-table", "", "The name of the MFA devices table whose secrets should also be re-encrypted. If empty devices are not re-encrypted.")
+
+	flag.Parse()
+
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if accounts_opts.KeyProvider == nil {
+		log.Fatal("Missing -key-provider")
+	}
+
+	db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	result, err := accounts_db.RotateAccountEncryption(ctx)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Re-encrypted %d accounts, skipped %d that changed while being read\n", result.Updated, result.Skipped)
+
+	if *devices_table == "" {
+		return
+	}
+
+	devices_opts := dynamodb.DefaultDynamoDBMFADevicesDatabaseOptions()
+	devices_opts.TableName = *devices_table
+	devices_opts.KeyProvider = accounts_opts.KeyProvider
+
+	devices_db, err := dynamodb.NewDynamoDBMFADevicesDatabaseWithDSN(*accounts_dsn, devices_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	count, err := devices_db.RotateDeviceEncryption(ctx)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Re-encrypted %d MFA devices\n", count)
+}
@@ -10,6 +10,10 @@ func main() {
 
 	accounts_table := flag.String("accounts-table", dynamodb.ACCOUNTS_DEFAULT_TABLENAME, "...")
 	tokens_table := flag.String("access-tokens-table", dynamodb.ACCESSTOKENS_DEFAULT_TABLENAME, "...")
+	devices_table := flag.String("mfa-devices-table", dynamodb.MFADEVICES_DEFAULT_TABLENAME, "...")
+	credentials_table := flag.String("credentials-table", dynamodb.CREDENTIALS_DEFAULT_TABLENAME, "...")
+	reset_table := flag.String("reset-tokens-table", dynamodb.RESETTOKENS_DEFAULT_TABLENAME, "...")
+	audit_table := flag.String("audit-log-table", dynamodb.AUDITLOG_DEFAULT_TABLENAME, "...")
 
 	dsn := flag.String("dsn", "", "...")
 
@@ -17,6 +21,10 @@ func main() {
 
 	accounts_opts := dynamodb.DefaultDynamoDBAccountsDatabaseOptions()
 	tokens_opts := dynamodb.DefaultDynamoDBAccessTokensDatabaseOptions()
+	devices_opts := dynamodb.DefaultDynamoDBMFADevicesDatabaseOptions()
+	credentials_opts := dynamodb.DefaultDynamoDBCredentialsDatabaseOptions()
+	reset_opts := dynamodb.DefaultDynamoDBResetTokensDatabaseOptions()
+	audit_opts := dynamodb.DefaultDynamoDBAuditLogOptions()
 
 	accounts_opts.TableName = *accounts_table
 	accounts_opts.CreateTable = true
@@ -24,6 +32,18 @@ func main() {
 	tokens_opts.TableName = *tokens_table
 	tokens_opts.CreateTable = true
 
+	devices_opts.TableName = *devices_table
+	devices_opts.CreateTable = true
+
+	credentials_opts.TableName = *credentials_table
+	credentials_opts.CreateTable = true
+
+	reset_opts.TableName = *reset_table
+	reset_opts.CreateTable = true
+
+	audit_opts.TableName = *audit_table
+	audit_opts.CreateTable = true
+
 	var err error
 
 	_, err = dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*dsn, accounts_opts)
@@ -38,4 +58,28 @@ func main() {
 		log.Printf("Failed to set up %s table, %s\n", tokens_opts.TableName, err)
 	}
 
+	_, err = dynamodb.NewDynamoDBMFADevicesDatabaseWithDSN(*dsn, devices_opts)
+
+	if err != nil {
+		log.Printf("Failed to set up %s table, %s\n", devices_opts.TableName, err)
+	}
+
+	_, err = dynamodb.NewDynamoDBCredentialsDatabaseWithDSN(*dsn, credentials_opts)
+
+	if err != nil {
+		log.Printf("Failed to set up %s table, %s\n", credentials_opts.TableName, err)
+	}
+
+	_, err = dynamodb.NewDynamoDBResetTokensDatabaseWithDSN(*dsn, reset_opts)
+
+	if err != nil {
+		log.Printf("Failed to set up %s table, %s\n", reset_opts.TableName, err)
+	}
+
+	_, err = dynamodb.NewDynamoDBAuditLogWithDSN(*dsn, audit_opts)
+
+	if err != nil {
+		log.Printf("Failed to set up %s table, %s\n", audit_opts.TableName, err)
+	}
+
 }
@@ -16,7 +16,7 @@ func main() {
 	tokens_dsn := flag.String("tokens-dsn", "", "...")
 	aws_dsn := flag.String("aws-dsn", "", "...")
 
-	accounts_table := flag.String("accounts-table", dynamodb.ACCOUNTS_DEFAULT_TABLENAME, "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
 	tokens_table := flag.String("tokens-table", dynamodb.ACCESSTOKENS_DEFAULT_TABLENAME, "...")
 
 	flag.Parse()
@@ -32,8 +32,11 @@ func main() {
 		}
 	}
 
-	accounts_opts := dynamodb.DefaultDynamoDBAccountsDatabaseOptions()
-	accounts_opts.TableName = *accounts_table
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	accounts_db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
 
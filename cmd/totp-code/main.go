@@ -12,12 +12,15 @@ func main() {
 	addr := flag.String("email", "", "...")
 	accounts_dsn := flag.String("accounts-dsn", "", "...")
 
-	accounts_table := flag.String("accounts-table", dynamodb.ACCOUNTS_DEFAULT_TABLENAME, "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
 
 	flag.Parse()
 
-	accounts_opts := dynamodb.DefaultDynamoDBAccountsDatabaseOptions()
-	accounts_opts.TableName = *accounts_table
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	accounts_db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
 
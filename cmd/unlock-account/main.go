@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"github.com/aaronland/go-auth-database-dynamodb"
+	"log"
+)
+
+func main() {
+
+	email := flag.String("email", "", "...")
+
+	accounts_dsn := flag.String("accounts-dsn", "", "...")
+	dynamodb.AppendAccountsFlags(flag.CommandLine)
+
+	flag.Parse()
+
+	accounts_opts, err := dynamodb.AccountsOptionsFromFlags(flag.CommandLine)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	db, err := dynamodb.NewDynamoDBAccountsDatabaseWithDSN(*accounts_dsn, accounts_opts)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	accounts_db := db.(*dynamodb.DynamoDBAccountsDatabase)
+
+	acct, err := accounts_db.GetAccountByEmailAddress(*email)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	attempts, err := accounts_db.GetLoginAttempts(acct)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = accounts_db.UnlockAccount(acct)
+
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.Printf("Unlocked account %d (%d failed login attempts)\n", acct.ID, attempts.Failed)
+}
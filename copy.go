@@ -0,0 +1,338 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	"github.com/aaronland/go-aws-session"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"sync"
+)
+
+// COPY_DEFAULT_SEGMENTS is the default number of parallel scan segments used by CopyTable.
+const COPY_DEFAULT_SEGMENTS int = 4
+
+// CopyTransformFunc is called with each item read by CopyTable and returns the item to
+// write, or nil if the item should not be copied.
+type CopyTransformFunc func(map[string]*aws_dynamodb.AttributeValue) (map[string]*aws_dynamodb.AttributeValue, error)
+
+// CopyOptions controls a call to CopyTable. Unless Overwrite is set items are written with a
+// conditional PutItem so that items which already exist in the destination, for example
+// because they have been written there since it was put in to service, are left untouched.
+type CopyOptions struct {
+	Segments  int
+	Transform CopyTransformFunc
+	Retry     *RetryOptions
+	Verify    bool
+	Overwrite bool
+	Progress  func(*CopyResult)
+}
+
+// CopyResult summarizes a call to CopyTable. Skipped counts items that already existed in
+// the destination. SourceCount and DestinationCount are only set when CopyOptions.Verify is
+// true, in which case Verified reports whether the destination holds exactly the items that
+// were copied or skipped. Verification assumes that neither table was otherwise written to
+// during the copy. Items that were already in the destination are only counted as skipped
+// when CopyOptions.Overwrite is false; with Overwrite, any that are not also in the source
+// cause Verified to be false.
+type CopyResult struct {
+	Scanned          int64 `json:"scanned"`
+	Written          int64 `json:"written"`
+	Skipped          int64 `json:"skipped"`
+	Dropped          int64 `json:"dropped"`
+	SourceCount      int64 `json:"source_count,omitempty"`
+	DestinationCount int64 `json:"destination_count,omitempty"`
+	Verified         bool  `json:"verified"`
+}
+
+// NewDynamoDBClientWithDSN returns a DynamoDB client for dsn, for use with CopyTable.
+func NewDynamoDBClientWithDSN(dsn string, retry *RetryOptions) (*aws_dynamodb.DynamoDB, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newDynamoDBClient(sess, retry), nil
+}
+
+// MigrationTransform returns a CopyTransformFunc that applies the registered migration
+// steps for target to each item, bringing it up to version (or the latest version if 0).
+// Accounts should be migrated to the Schema of the database that will read them, as
+// Migrate does, rather than to the latest version.
+func MigrationTransform(target string, version int) CopyTransformFunc {
+
+	if version == 0 {
+		version = LatestSchemaVersion(target)
+	}
+
+	steps := MigrationSteps(target)
+
+	return func(item map[string]*aws_dynamodb.AttributeValue) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+		migrated, _, err := applyMigrationSteps(target, item, steps, version)
+		return migrated, err
+	}
+}
+
+// CopyTable copies every item in source_table, read using source, to dest_table, written
+// using dest. The source table is read using a parallel scan with opts.Segments segments.
+// Items that already exist in the destination are only overwritten if opts.Overwrite is set.
+func CopyTable(ctx context.Context, source *aws_dynamodb.DynamoDB, source_table string, dest *aws_dynamodb.DynamoDB, dest_table string, opts *CopyOptions) (*CopyResult, error) {
+
+	segments := opts.Segments
+
+	if segments < 1 {
+		segments = COPY_DEFAULT_SEGMENTS
+	}
+
+	key_name := ""
+
+	if !opts.Overwrite {
+
+		name, err := tableHashKey(dest, dest_table)
+
+		if err != nil {
+			return nil, err
+		}
+
+		key_name = name
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	result := &CopyResult{}
+	mu := new(sync.Mutex)
+
+	err_ch := make(chan error, segments)
+	wg := new(sync.WaitGroup)
+
+	for i := 0; i < segments; i++ {
+
+		wg.Add(1)
+
+		go func(segment int) {
+
+			defer wg.Done()
+
+			err := copySegment(ctx, source, source_table, dest, dest_table, key_name, segment, segments, opts, result, mu)
+
+			if err != nil {
+				err_ch <- err
+				cancel()
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(err_ch)
+
+	for err := range err_ch {
+
+		if err != context.Canceled {
+			return result, err
+		}
+	}
+
+	err := ctx.Err()
+
+	if err != nil {
+		return result, err
+	}
+
+	if opts.Verify {
+
+		source_count, err := CountItems(ctx, source, source_table)
+
+		if err != nil {
+			return result, err
+		}
+
+		dest_count, err := CountItems(ctx, dest, dest_table)
+
+		if err != nil {
+			return result, err
+		}
+
+		result.SourceCount = source_count
+		result.DestinationCount = dest_count
+		result.Verified = dest_count == source_count-result.Dropped && dest_count == result.Written+result.Skipped
+	}
+
+	return result, nil
+}
+
+// copySegment copies the items in one segment of source_table. If key_name is empty they
+// are written in batches, overwriting any existing items, otherwise they are written one
+// at a time on the condition that no item with the same key_name exists.
+func copySegment(ctx context.Context, source *aws_dynamodb.DynamoDB, source_table string, dest *aws_dynamodb.DynamoDB, dest_table string, key_name string, segment int, segments int, opts *CopyOptions, result *CopyResult, mu *sync.Mutex) error {
+
+	req := &aws_dynamodb.ScanInput{
+		TableName:     aws.String(source_table),
+		Segment:       aws.Int64(int64(segment)),
+		TotalSegments: aws.Int64(int64(segments)),
+	}
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		rsp, err := source.ScanWithContext(ctx, req)
+
+		if err != nil {
+			return err
+		}
+
+		requests := make([]*aws_dynamodb.WriteRequest, 0)
+		dropped := int64(0)
+		written := int64(0)
+		skipped := int64(0)
+
+		for _, item := range rsp.Items {
+
+			if opts.Transform != nil {
+
+				item, err = opts.Transform(item)
+
+				if err != nil {
+					return err
+				}
+
+				if item == nil {
+					dropped += 1
+					continue
+				}
+			}
+
+			if key_name != "" {
+
+				put_req := &aws_dynamodb.PutItemInput{
+					TableName:           aws.String(dest_table),
+					Item:                item,
+					ConditionExpression: aws.String("attribute_not_exists(#key)"),
+					ExpressionAttributeNames: map[string]*string{
+						"#key": aws.String(key_name),
+					},
+				}
+
+				_, err := dest.PutItemWithContext(ctx, put_req)
+
+				if isConditionalCheckFailed(err) {
+					skipped += 1
+					continue
+				}
+
+				if err != nil {
+					return err
+				}
+
+				written += 1
+				continue
+			}
+
+			write_req := &aws_dynamodb.WriteRequest{
+				PutRequest: &aws_dynamodb.PutRequest{
+					Item: item,
+				},
+			}
+
+			requests = append(requests, write_req)
+		}
+
+		if len(requests) > 0 {
+
+			err = batchWriteItems(dest, dest_table, requests, opts.Retry)
+
+			if err != nil {
+				return err
+			}
+
+			written += int64(len(requests))
+		}
+
+		mu.Lock()
+
+		result.Scanned += int64(len(rsp.Items))
+		result.Written += written
+		result.Skipped += skipped
+		result.Dropped += dropped
+
+		if opts.Progress != nil {
+			snapshot := *result
+			opts.Progress(&snapshot)
+		}
+
+		mu.Unlock()
+
+		if rsp.LastEvaluatedKey == nil {
+			break
+		}
+
+		req.ExclusiveStartKey = rsp.LastEvaluatedKey
+	}
+
+	return nil
+}
+
+// tableHashKey returns the name of table's hash key.
+func tableHashKey(client *aws_dynamodb.DynamoDB, table string) (string, error) {
+
+	req := &aws_dynamodb.DescribeTableInput{
+		TableName: aws.String(table),
+	}
+
+	rsp, err := client.DescribeTable(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	for _, k := range rsp.Table.KeySchema {
+
+		if aws.StringValue(k.KeyType) == "HASH" {
+			return aws.StringValue(k.AttributeName), nil
+		}
+	}
+
+	return "", fmt.Errorf("Table %s has no hash key", table)
+}
+
+// CountItems returns the number of items in table by scanning it. Unlike the table's
+// ItemCount, which DynamoDB only updates every few hours, this is exact (at the time of
+// the scan) but consumes read capacity for every item.
+func CountItems(ctx context.Context, client *aws_dynamodb.DynamoDB, table string) (int64, error) {
+
+	req := &aws_dynamodb.ScanInput{
+		TableName:      aws.String(table),
+		Select:         aws.String(aws_dynamodb.SelectCount),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	count := int64(0)
+
+	for {
+
+		rsp, err := client.ScanWithContext(ctx, req)
+
+		if err != nil {
+			return count, err
+		}
+
+		count += aws.Int64Value(rsp.Count)
+
+		if rsp.LastEvaluatedKey == nil {
+			break
+		}
+
+		req.ExclusiveStartKey = rsp.LastEvaluatedKey
+	}
+
+	return count, nil
+}
@@ -0,0 +1,321 @@
+package dynamodb
+
+import (
+	"encoding/base64"
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-aws-session"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"strconv"
+	"time"
+)
+
+const CREDENTIALS_DEFAULT_TABLENAME string = "credentials"
+
+var ErrNoCredential = errors.New("Credential does not exist")
+
+var ErrCredentialExists = errors.New("Credential already exists")
+
+// ErrSignCountNotIncreased is returned by UpdateSignCount when an authenticator reports a
+// signature counter that is not greater than the stored one, which may indicate that the
+// authenticator has been cloned.
+var ErrSignCountNotIncreased = errors.New("Signature counter did not increase")
+
+type DynamoDBCredentialsDatabaseOptions struct {
+	TableName      string
+	BillingMode    string
+	CreateTable    bool
+	Retry          *RetryOptions
+	ConsistentRead bool
+}
+
+func DefaultDynamoDBCredentialsDatabaseOptions() *DynamoDBCredentialsDatabaseOptions {
+
+	opts := DynamoDBCredentialsDatabaseOptions{
+		TableName:      CREDENTIALS_DEFAULT_TABLENAME,
+		BillingMode:    "PAY_PER_REQUEST",
+		CreateTable:    false,
+		Retry:          DefaultRetryOptions(),
+		ConsistentRead: false,
+	}
+
+	return &opts
+}
+
+// WebAuthnCredential is a WebAuthn (passkey) public key credential. ID is the base64url
+// encoded credential ID, see CredentialID.
+type WebAuthnCredential struct {
+	ID           string   `json:"id"`
+	AccountID    int64    `json:"account_id"`
+	Name         string   `json:"name"`
+	PublicKey    []byte   `json:"public_key"`
+	SignCount    int64    `json:"sign_count"`
+	Transports   []string `json:"transports"`
+	Created      int64    `json:"created"`
+	LastModified int64    `json:"lastmodified"`
+	LastUsed     int64    `json:"last_used"`
+}
+
+type DynamoDBCredentialsDatabase struct {
+	client  *aws_dynamodb.DynamoDB
+	options *DynamoDBCredentialsDatabaseOptions
+}
+
+// CredentialID returns the string form of a raw WebAuthn credential ID.
+func CredentialID(raw []byte) string {
+	return base64.RawURLEncoding.EncodeToString(raw)
+}
+
+func NewDynamoDBCredentialsDatabaseWithDSN(dsn string, opts *DynamoDBCredentialsDatabaseOptions) (*DynamoDBCredentialsDatabase, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDynamoDBCredentialsDatabaseWithSession(sess, opts)
+}
+
+func NewDynamoDBCredentialsDatabaseWithSession(sess *aws_session.Session, opts *DynamoDBCredentialsDatabaseOptions) (*DynamoDBCredentialsDatabase, error) {
+
+	client := newDynamoDBClient(sess, opts.Retry)
+
+	if opts.CreateTable {
+
+		_, err := CreateCredentialsTable(client, opts)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db := DynamoDBCredentialsDatabase{
+		client:  client,
+		options: opts,
+	}
+
+	return &db, nil
+}
+
+func (db *DynamoDBCredentialsDatabase) RegisterCredential(cred *WebAuthnCredential) (*WebAuthnCredential, error) {
+
+	if cred.ID == "" {
+		return nil, errors.New("Invalid credential ID")
+	}
+
+	if cred.AccountID == 0 {
+		return nil, errors.New("Invalid account ID")
+	}
+
+	now := time.Now()
+
+	cred.Created = now.Unix()
+	cred.LastModified = now.Unix()
+
+	item, err := aws_dynamodbattribute.MarshalMap(cred)
+
+	if err != nil {
+		return nil, err
+	}
+
+	req := &aws_dynamodb.PutItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id": aws.String("id"),
+		},
+	}
+
+	_, err = db.client.PutItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+			return nil, ErrCredentialExists
+		}
+
+		return nil, err
+	}
+
+	return cred, nil
+}
+
+func (db *DynamoDBCredentialsDatabase) GetCredentialByID(id string) (*WebAuthnCredential, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:      aws.String(db.options.TableName),
+		Key:            credentialKey(id),
+		ConsistentRead: aws.Bool(db.options.ConsistentRead),
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rsp.Item) == 0 {
+		return nil, ErrNoCredential
+	}
+
+	return itemToCredential(rsp.Item)
+}
+
+func (db *DynamoDBCredentialsDatabase) ListCredentialsForAccount(acct *account.Account) ([]*WebAuthnCredential, error) {
+
+	str_id := strconv.FormatInt(acct.ID, 10)
+
+	req := &aws_dynamodb.QueryInput{
+		TableName:              aws.String(db.options.TableName),
+		IndexName:              aws.String("account_id"),
+		KeyConditionExpression: aws.String("#account_id = :account_id"),
+		ExpressionAttributeNames: map[string]*string{
+			"#account_id": aws.String("account_id"),
+			"#id":         aws.String("id"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":account_id": {N: aws.String(str_id)},
+		},
+		ProjectionExpression: aws.String("#id"),
+	}
+
+	keys := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+	for {
+
+		rsp, err := db.client.Query(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range rsp.Items {
+			keys = append(keys, credentialKey(*item["id"].S))
+		}
+
+		req.ExclusiveStartKey = rsp.LastEvaluatedKey
+
+		if rsp.LastEvaluatedKey == nil {
+			break
+		}
+	}
+
+	read_opts := &ReadOptions{
+		ConsistentRead: db.options.ConsistentRead,
+	}
+
+	items, err := batchGetKeys(db.client, db.options.TableName, keys, read_opts, db.options.Retry)
+
+	if err != nil {
+		return nil, err
+	}
+
+	creds := make([]*WebAuthnCredential, 0)
+
+	for _, item := range items {
+
+		cred, err := itemToCredential(item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		creds = append(creds, cred)
+	}
+
+	return creds, nil
+}
+
+// UpdateSignCount records a new signature counter for the credential id. The update
+// only succeeds if count is greater than the stored counter, or if both are zero (for
+// authenticators that do not implement a counter), otherwise ErrSignCountNotIncreased
+// is returned.
+func (db *DynamoDBCredentialsDatabase) UpdateSignCount(id string, count int64) error {
+
+	now := time.Now()
+
+	str_now := strconv.FormatInt(now.Unix(), 10)
+	str_count := strconv.FormatInt(count, 10)
+
+	condition := "attribute_exists(#id) AND #sign_count < :count"
+
+	if count == 0 {
+		condition = "attribute_exists(#id) AND #sign_count = :count"
+	}
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Key:                 credentialKey(id),
+		UpdateExpression:    aws.String("SET #sign_count = :count, #last_used = :now"),
+		ConditionExpression: aws.String(condition),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":         aws.String("id"),
+			"#sign_count": aws.String("sign_count"),
+			"#last_used":  aws.String("last_used"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":count": {N: aws.String(str_count)},
+			":now":   {N: aws.String(str_now)},
+		},
+	}
+
+	_, err := db.client.UpdateItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+
+			_, get_err := db.GetCredentialByID(id)
+
+			if get_err != nil {
+				return get_err
+			}
+
+			return ErrSignCountNotIncreased
+		}
+
+		return err
+	}
+
+	return nil
+}
+
+func (db *DynamoDBCredentialsDatabase) RemoveCredential(id string) error {
+
+	req := &aws_dynamodb.DeleteItemInput{
+		TableName: aws.String(db.options.TableName),
+		Key:       credentialKey(id),
+	}
+
+	_, err := db.client.DeleteItem(req)
+	return err
+}
+
+func credentialKey(id string) map[string]*aws_dynamodb.AttributeValue {
+
+	key := map[string]*aws_dynamodb.AttributeValue{
+		"id": {
+			S: aws.String(id),
+		},
+	}
+
+	return key
+}
+
+func itemToCredential(item map[string]*aws_dynamodb.AttributeValue) (*WebAuthnCredential, error) {
+
+	var cred *WebAuthnCredential
+
+	err := aws_dynamodbattribute.UnmarshalMap(item, &cred)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return cred, nil
+}
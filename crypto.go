@@ -0,0 +1,589 @@
+package dynamodb
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-aws-session"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_kms "github.com/aws/aws-sdk-go/service/kms"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var ErrNoKeyProvider = errors.New("Item is encrypted but no key provider has been configured")
+
+// KeyProvider is the interface for services that generate and unwrap the per-item data
+// keys used to encrypt sensitive account fields (envelope encryption).
+type KeyProvider interface {
+	// CurrentKeyID returns the ID of the key that new data keys are wrapped with.
+	CurrentKeyID() string
+	// GenerateDataKey returns a new plaintext data key and the same key wrapped with key_id.
+	GenerateDataKey(key_id string) ([]byte, []byte, error)
+	// DecryptDataKey unwraps a data key that was wrapped with key_id.
+	DecryptDataKey(key_id string, wrapped []byte) ([]byte, error)
+}
+
+// EncryptedFields is stored, as the "encrypted" attribute, alongside any item with
+// encrypted fields. Each field is AES-256-GCM encrypted with DataKey (itself wrapped
+// with KeyID) and stored as its nonce followed by its ciphertext.
+type EncryptedFields struct {
+	KeyID   string            `json:"key_id"`
+	DataKey []byte            `json:"data_key"`
+	Fields  map[string][]byte `json:"fields"`
+}
+
+// NewKeyProviderFromURI returns a KeyProvider for uri, which is either "file:///path/to/keys"
+// for a LocalKeyProvider or "kms://{KEY_ID}?dsn={AWS_DSN}" for a KMSKeyProvider.
+func NewKeyProviderFromURI(uri string) (KeyProvider, error) {
+
+	u, err := url.Parse(uri)
+
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "file":
+		return NewLocalKeyProviderFromFile(u.Path)
+	case "kms":
+
+		sess, err := session.NewSessionWithDSN(u.Query().Get("dsn"))
+
+		if err != nil {
+			return nil, err
+		}
+
+		key_id := u.Host + u.Path
+		return NewKMSKeyProvider(aws_kms.New(sess), key_id)
+
+	default:
+		return nil, fmt.Errorf("Unsupported key provider '%s'", u.Scheme)
+	}
+}
+
+// LocalKeyProvider wraps data keys with AES-256-GCM using master keys read from a local
+// file. It is intended for testing and development.
+type LocalKeyProvider struct {
+	KeyProvider
+	keys    map[string][]byte
+	current string
+}
+
+// NewLocalKeyProviderFromFile reads master keys from path, one per line, formatted as
+// "{KEY_ID} {BASE64_ENCODED_32_BYTE_KEY}". The last key in the file is the current key,
+// so keys are rotated by appending a new line.
+func NewLocalKeyProviderFromFile(path string) (*LocalKeyProvider, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	p := LocalKeyProvider{
+		keys: make(map[string][]byte),
+	}
+
+	scanner := bufio.NewScanner(fh)
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		parts := strings.Fields(line)
+
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid key definition '%s'", line)
+		}
+
+		key, err := base64.StdEncoding.DecodeString(parts[1])
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(key) != 32 {
+			return nil, fmt.Errorf("Key '%s' is not 32 bytes", parts[0])
+		}
+
+		p.keys[parts[0]] = key
+		p.current = parts[0]
+	}
+
+	err = scanner.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	if p.current == "" {
+		return nil, errors.New("No keys defined")
+	}
+
+	return &p, nil
+}
+
+func (p *LocalKeyProvider) CurrentKeyID() string {
+	return p.current
+}
+
+func (p *LocalKeyProvider) GenerateDataKey(key_id string) ([]byte, []byte, error) {
+
+	master, ok := p.keys[key_id]
+
+	if !ok {
+		return nil, nil, fmt.Errorf("Unknown key '%s'", key_id)
+	}
+
+	data_key := make([]byte, 32)
+
+	_, err := rand.Read(data_key)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	wrapped, err := sealAESGCM(master, data_key, []byte(key_id))
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return data_key, wrapped, nil
+}
+
+func (p *LocalKeyProvider) DecryptDataKey(key_id string, wrapped []byte) ([]byte, error) {
+
+	master, ok := p.keys[key_id]
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown key '%s'", key_id)
+	}
+
+	return openAESGCM(master, wrapped, []byte(key_id))
+}
+
+// KMSClient is the subset of the AWS KMS API used by KMSKeyProvider.
+type KMSClient interface {
+	GenerateDataKey(*aws_kms.GenerateDataKeyInput) (*aws_kms.GenerateDataKeyOutput, error)
+	Decrypt(*aws_kms.DecryptInput) (*aws_kms.DecryptOutput, error)
+}
+
+// KMSKeyProvider generates and unwraps data keys using an AWS KMS key. Rotating keys means
+// creating a new KMS provider with a new key ID.
+type KMSKeyProvider struct {
+	KeyProvider
+	client KMSClient
+	key_id string
+}
+
+func NewKMSKeyProvider(client KMSClient, key_id string) (*KMSKeyProvider, error) {
+
+	if key_id == "" {
+		return nil, errors.New("Missing KMS key ID")
+	}
+
+	p := KMSKeyProvider{
+		client: client,
+		key_id: key_id,
+	}
+
+	return &p, nil
+}
+
+func (p *KMSKeyProvider) CurrentKeyID() string {
+	return p.key_id
+}
+
+func (p *KMSKeyProvider) GenerateDataKey(key_id string) ([]byte, []byte, error) {
+
+	req := &aws_kms.GenerateDataKeyInput{
+		KeyId:   aws.String(key_id),
+		KeySpec: aws.String(aws_kms.DataKeySpecAes256),
+	}
+
+	rsp, err := p.client.GenerateDataKey(req)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return rsp.Plaintext, rsp.CiphertextBlob, nil
+}
+
+func (p *KMSKeyProvider) DecryptDataKey(key_id string, wrapped []byte) ([]byte, error) {
+
+	// Symmetric KMS ciphertexts identify the key they were encrypted with so key_id is
+	// not needed (and DecryptInput.KeyId is not supported by older versions of the SDK)
+
+	req := &aws_kms.DecryptInput{
+		CiphertextBlob: wrapped,
+	}
+
+	rsp, err := p.client.Decrypt(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rsp.Plaintext, nil
+}
+
+// encryptAccountFields returns a copy of acct with fields (any of "password", "mfa" or
+// "address") removed and the encrypted form of those fields.
+func encryptAccountFields(provider KeyProvider, acct *account.Account, fields []string) (*account.Account, *EncryptedFields, error) {
+
+	plain := map[string]interface{}{
+		"password": acct.Password,
+		"mfa":      acct.MFA,
+		"address":  acct.Address,
+	}
+
+	values := make(map[string]interface{})
+
+	scrubbed := *acct
+
+	for _, name := range fields {
+
+		v, ok := plain[name]
+
+		if !ok {
+			return nil, nil, fmt.Errorf("Unsupported encrypted field '%s'", name)
+		}
+
+		values[name] = v
+
+		switch name {
+		case "password":
+			scrubbed.Password = nil
+		case "mfa":
+			scrubbed.MFA = nil
+		case "address":
+			scrubbed.Address = nil
+		}
+	}
+
+	enc, err := encryptValues(provider, strconv.FormatInt(acct.ID, 10), values)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return &scrubbed, enc, nil
+}
+
+// encryptValues JSON encodes and encrypts each of values with a new data key. Each value's
+// ciphertext is bound to aad_prefix and its name so that it can not be swapped with one
+// belonging to another item or field.
+func encryptValues(provider KeyProvider, aad_prefix string, values map[string]interface{}) (*EncryptedFields, error) {
+
+	key_id := provider.CurrentKeyID()
+
+	data_key, wrapped, err := provider.GenerateDataKey(key_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	enc := EncryptedFields{
+		KeyID:   key_id,
+		DataKey: wrapped,
+		Fields:  make(map[string][]byte),
+	}
+
+	for name, v := range values {
+
+		body, err := json.Marshal(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		sealed, err := sealAESGCM(data_key, body, encryptedFieldAAD(aad_prefix, name))
+
+		if err != nil {
+			return nil, err
+		}
+
+		enc.Fields[name] = sealed
+	}
+
+	return &enc, nil
+}
+
+// decryptValues decrypts the values encrypted by encryptValues, JSON decoding each one in
+// to the matching entry in targets.
+func decryptValues(provider KeyProvider, enc *EncryptedFields, aad_prefix string, targets map[string]interface{}) error {
+
+	if provider == nil {
+		return ErrNoKeyProvider
+	}
+
+	data_key, err := provider.DecryptDataKey(enc.KeyID, enc.DataKey)
+
+	if err != nil {
+		return err
+	}
+
+	for name, target := range targets {
+
+		sealed, ok := enc.Fields[name]
+
+		if !ok {
+			continue
+		}
+
+		body, err := openAESGCM(data_key, sealed, encryptedFieldAAD(aad_prefix, name))
+
+		if err != nil {
+			return err
+		}
+
+		err = json.Unmarshal(body, target)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// decryptAccountFields restores the fields encrypted by encryptAccountFields to acct. Fields
+// which are already set (because the account was later written without encryption) are
+// left as-is.
+func decryptAccountFields(provider KeyProvider, acct *account.Account, enc *EncryptedFields) error {
+
+	pending := make([]string, 0)
+
+	for name := range enc.Fields {
+
+		switch name {
+		case "password":
+			if acct.Password != nil {
+				continue
+			}
+		case "mfa":
+			if acct.MFA != nil {
+				continue
+			}
+		case "address":
+			if acct.Address != nil {
+				continue
+			}
+		default:
+			continue
+		}
+
+		pending = append(pending, name)
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	all_targets := map[string]interface{}{
+		"password": &acct.Password,
+		"mfa":      &acct.MFA,
+		"address":  &acct.Address,
+	}
+
+	targets := make(map[string]interface{})
+
+	for _, name := range pending {
+		targets[name] = all_targets[name]
+	}
+
+	return decryptValues(provider, enc, strconv.FormatInt(acct.ID, 10), targets)
+}
+
+// needsEncryption reports whether an account item should be (re-)encrypted because it is
+// stored in plaintext or its data key was not wrapped with the provider's current key.
+func needsEncryption(provider KeyProvider, enc *EncryptedFields) bool {
+
+	if provider == nil {
+		return false
+	}
+
+	if enc == nil {
+		return true
+	}
+
+	return enc.KeyID != provider.CurrentKeyID()
+}
+
+// RotateAccountEncryption re-encrypts every account, and password history, that is stored
+// in plaintext or whose data key was wrapped with anything other than the key provider's
+// current key. Accounts are only rewritten if they have not been modified since they were
+// read; those that have are counted as skipped.
+func (db *DynamoDBAccountsDatabase) RotateAccountEncryption(ctx context.Context) (*RewriteResult, error) {
+
+	if db.options.KeyProvider == nil {
+		return nil, errors.New("No key provider configured")
+	}
+
+	result := &RewriteResult{}
+
+	err := scanItems(ctx, db.client, db.options.TableName, func(item map[string]*aws_dynamodb.AttributeValue) error {
+
+		dynamodb_acct, err := itemToDynamoDBAccount(item)
+
+		if err != nil {
+			return err
+		}
+
+		extra := make(map[string]*aws_dynamodb.AttributeValue)
+
+		history, err := db.rotatedPasswordHistory(dynamodb_acct.ID, item["password_history"])
+
+		if err != nil {
+			return fmt.Errorf("Failed to re-encrypt password history for account %d, %s", dynamodb_acct.ID, err)
+		}
+
+		if history != nil {
+			extra["password_history"] = history
+		}
+
+		var update_req *aws_dynamodb.UpdateItemInput
+
+		if needsEncryption(db.options.KeyProvider, dynamodb_acct.Encrypted) {
+
+			acct, err := dynamodbAccountToAccount(db.options, dynamodb_acct)
+
+			if err != nil {
+				return fmt.Errorf("Failed to decrypt account %d, %s", dynamodb_acct.ID, err)
+			}
+
+			update_req, err = newAccountRewrite(db.options, item, acct, extra)
+
+			if err != nil {
+				return err
+			}
+
+		} else if len(extra) > 0 {
+
+			rewritten := copyItem(item)
+
+			for k, v := range extra {
+				rewritten[k] = v
+			}
+
+			update_req = newMigrationUpdate(db.options.TableName, "id", item, rewritten)
+		}
+
+		if update_req == nil {
+			return nil
+		}
+
+		applied, err := applyRewrite(db.client, update_req)
+
+		if err != nil {
+			return err
+		}
+
+		result.add(applied)
+		return nil
+	})
+
+	return result, err
+}
+
+// rotatedPasswordHistory returns an account's password history re-encrypted with the key
+// provider's current key, or nil if it is already encrypted with it (or there is none).
+func (db *DynamoDBAccountsDatabase) rotatedPasswordHistory(id int64, v *aws_dynamodb.AttributeValue) (*aws_dynamodb.AttributeValue, error) {
+
+	if v == nil {
+		return nil, nil
+	}
+
+	if v.M != nil {
+
+		enc, err := passwordHistoryEnvelope(v)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !needsEncryption(db.options.KeyProvider, enc) {
+			return nil, nil
+		}
+	}
+
+	history, err := passwordHistoryFromAttribute(db.options, id, v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return passwordHistoryAttribute(db.options, id, history)
+}
+
+func encryptedFieldAAD(prefix string, name string) []byte {
+	return []byte(prefix + "#" + name)
+}
+
+func sealAESGCM(key []byte, plaintext []byte, aad []byte) ([]byte, error) {
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+
+	_, err = rand.Read(nonce)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func openAESGCM(key []byte, sealed []byte, aad []byte) ([]byte, error) {
+
+	block, err := aes.NewCipher(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("Invalid ciphertext")
+	}
+
+	nonce := sealed[0:gcm.NonceSize()]
+	ciphertext := sealed[gcm.NonceSize():]
+
+	return gcm.Open(nil, nonce, ciphertext, aad)
+}
@@ -0,0 +1,334 @@
+package dynamodb
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"github.com/aaronland/go-auth/account"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+type testKey struct {
+	id  string
+	key []byte
+}
+
+func newTestKey(t *testing.T, id string) *testKey {
+
+	key := make([]byte, 32)
+
+	_, err := rand.Read(key)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testKey{id: id, key: key}
+}
+
+// newTestKeyProvider returns a LocalKeyProvider for keys, the last of which is current.
+func newTestKeyProvider(t *testing.T, keys ...*testKey) *LocalKeyProvider {
+
+	dir, err := ioutil.TempDir("", "keys")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	var buf bytes.Buffer
+
+	for _, k := range keys {
+		buf.WriteString(k.id + " " + base64.StdEncoding.EncodeToString(k.key) + "\n")
+	}
+
+	path := filepath.Join(dir, "keys")
+
+	err = ioutil.WriteFile(path, buf.Bytes(), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewLocalKeyProviderFromFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func newTestAccount() *account.Account {
+
+	return &account.Account{
+		ID:       1234,
+		Address:  &account.Address{URI: "bob@example.com", Confirmed: true},
+		Password: &account.Password{Digest: "digest", Salt: "salt", LastModified: 1},
+		Username: &account.Username{Raw: "Bob", Safe: "bob"},
+		MFA:      &account.MFA{Secret: "JBSWY3DPEHPK3PXP", LastModified: 2},
+	}
+}
+
+func TestSealOpenAESGCM(t *testing.T) {
+
+	key := newTestKey(t, "a").key
+	other := newTestKey(t, "b").key
+
+	plain := []byte("hello world")
+
+	sealed, err := sealAESGCM(key, plain, []byte("aad"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opened, err := openAESGCM(key, sealed, []byte("aad"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(opened, plain) {
+		t.Fatalf("Expected '%s' but got '%s'", plain, opened)
+	}
+
+	_, err = openAESGCM(other, sealed, []byte("aad"))
+
+	if err == nil {
+		t.Fatal("Expected opening with the wrong key to fail")
+	}
+
+	_, err = openAESGCM(key, sealed, []byte("other"))
+
+	if err == nil {
+		t.Fatal("Expected opening with the wrong additional data to fail")
+	}
+
+	_, err = openAESGCM(key, sealed[0:4], []byte("aad"))
+
+	if err == nil {
+		t.Fatal("Expected opening a truncated ciphertext to fail")
+	}
+}
+
+func TestLocalKeyProviderWrapUnwrap(t *testing.T) {
+
+	p := newTestKeyProvider(t, newTestKey(t, "k1"))
+
+	data_key, wrapped, err := p.GenerateDataKey(p.CurrentKeyID())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unwrapped, err := p.DecryptDataKey("k1", wrapped)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(data_key, unwrapped) {
+		t.Fatal("Unwrapped data key does not match")
+	}
+
+	_, err = p.DecryptDataKey("k2", wrapped)
+
+	if err == nil {
+		t.Fatal("Expected unwrapping with an unknown key to fail")
+	}
+
+	wrong := newTestKeyProvider(t, newTestKey(t, "k1"))
+
+	_, err = wrong.DecryptDataKey("k1", wrapped)
+
+	if err == nil {
+		t.Fatal("Expected unwrapping with the wrong key to fail")
+	}
+}
+
+func TestEncryptAccountFieldsRoundTrip(t *testing.T) {
+
+	p := newTestKeyProvider(t, newTestKey(t, "k1"))
+	acct := newTestAccount()
+
+	scrubbed, enc, err := encryptAccountFields(p, acct, []string{"password", "mfa", "address"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if scrubbed.Password != nil || scrubbed.MFA != nil || scrubbed.Address != nil {
+		t.Fatal("Expected encrypted fields to be removed from the account")
+	}
+
+	if acct.Password == nil || acct.MFA == nil || acct.Address == nil {
+		t.Fatal("Expected the original account to be left unchanged")
+	}
+
+	if enc.KeyID != "k1" {
+		t.Fatalf("Expected key ID 'k1' but got '%s'", enc.KeyID)
+	}
+
+	err = decryptAccountFields(p, scrubbed, enc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *scrubbed.Password != *acct.Password || *scrubbed.MFA != *acct.MFA || *scrubbed.Address != *acct.Address {
+		t.Fatal("Decrypted fields do not match")
+	}
+}
+
+func TestDecryptAccountFieldsWrongKey(t *testing.T) {
+
+	p := newTestKeyProvider(t, newTestKey(t, "k1"))
+	wrong := newTestKeyProvider(t, newTestKey(t, "k1"))
+
+	scrubbed, enc, err := encryptAccountFields(p, newTestAccount(), []string{"password", "mfa"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = decryptAccountFields(wrong, scrubbed, enc)
+
+	if err == nil {
+		t.Fatal("Expected decrypting with the wrong key to fail")
+	}
+
+	err = decryptAccountFields(nil, scrubbed, enc)
+
+	if err != ErrNoKeyProvider {
+		t.Fatalf("Expected ErrNoKeyProvider but got %v", err)
+	}
+
+	// Fields are bound to the account they belong to
+
+	scrubbed.ID = 5678
+
+	err = decryptAccountFields(p, scrubbed, enc)
+
+	if err == nil {
+		t.Fatal("Expected decrypting another account's fields to fail")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+
+	k1 := newTestKey(t, "k1")
+	k2 := newTestKey(t, "k2")
+
+	old_p := newTestKeyProvider(t, k1)
+	new_p := newTestKeyProvider(t, k1, k2)
+	next_p := newTestKeyProvider(t, k2)
+
+	acct := newTestAccount()
+
+	scrubbed, enc, err := encryptAccountFields(old_p, acct, []string{"password", "mfa"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if needsEncryption(old_p, enc) {
+		t.Fatal("Did not expect an account encrypted with the current key to need encryption")
+	}
+
+	if !needsEncryption(new_p, enc) {
+		t.Fatal("Expected an account encrypted with an old key to need encryption")
+	}
+
+	if !needsEncryption(new_p, nil) {
+		t.Fatal("Expected a plaintext account to need encryption")
+	}
+
+	// Accounts encrypted with the old key can still be read after rotation...
+
+	err = decryptAccountFields(new_p, scrubbed, enc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, rotated_enc, err := encryptAccountFields(new_p, scrubbed, []string{"password", "mfa"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if rotated_enc.KeyID != "k2" {
+		t.Fatalf("Expected key ID 'k2' but got '%s'", rotated_enc.KeyID)
+	}
+
+	// ...and once they have been re-encrypted the old key can be retired
+
+	err = decryptAccountFields(next_p, rotated, rotated_enc)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *rotated.Password != *acct.Password || *rotated.MFA != *acct.MFA {
+		t.Fatal("Re-encrypted fields do not match")
+	}
+
+	_, enc, err = encryptAccountFields(old_p, acct, []string{"password"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scrubbed.Password = nil
+
+	err = decryptAccountFields(next_p, scrubbed, enc)
+
+	if err == nil {
+		t.Fatal("Expected decrypting with a retired key to fail")
+	}
+}
+
+func TestDeviceSecretRoundTrip(t *testing.T) {
+
+	p := newTestKeyProvider(t, newTestKey(t, "k1"))
+
+	device := &MFADevice{
+		AccountID: 1234,
+		Name:      "phone",
+		Secret:    "JBSWY3DPEHPK3PXP",
+	}
+
+	err := encryptDeviceSecret(p, device)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if device.Secret != "" || device.Encrypted == nil {
+		t.Fatal("Expected the device secret to be encrypted")
+	}
+
+	swapped := *device
+	swapped.Name = "laptop"
+
+	err = decryptDeviceSecret(p, &swapped)
+
+	if err == nil {
+		t.Fatal("Expected decrypting a secret moved to another device to fail")
+	}
+
+	err = decryptDeviceSecret(p, device)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if device.Secret != "JBSWY3DPEHPK3PXP" {
+		t.Fatalf("Unexpected secret '%s'", device.Secret)
+	}
+}
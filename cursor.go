@@ -0,0 +1,307 @@
+package dynamodb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"strings"
+)
+
+const PAGE_DEFAULT_SIZE int64 = 20
+
+const PAGE_MAX_SIZE int64 = 1000
+
+// CURSOR_SECRET_MINIMUM_SIZE is the minimum length, in bytes, of the secret used to sign cursors.
+const CURSOR_SECRET_MINIMUM_SIZE int = 32
+
+var ErrInvalidCursor = errors.New("Invalid cursor")
+
+var ErrMissingCursorSecret = errors.New("CursorSecret must be at least 32 bytes")
+
+type PageOptions struct {
+	PageSize int64
+	Cursor   string
+}
+
+func DefaultPageOptions() *PageOptions {
+
+	opts := PageOptions{
+		PageSize: PAGE_DEFAULT_SIZE,
+		Cursor:   "",
+	}
+
+	return &opts
+}
+
+type cursorPayload struct {
+	Table string                       `json:"t"`
+	Key   map[string]map[string]string `json:"k"`
+}
+
+// NewCursorSecret returns a random key for signing cursors. Cursors are only valid for as
+// long as the secret they were signed with is in use so services that run more than one
+// process, or are restarted, should generate a secret once and configure it everywhere.
+// Generating a new secret for each process is only appropriate for short-lived tools.
+func NewCursorSecret() ([]byte, error) {
+
+	secret := make([]byte, 32)
+
+	_, err := rand.Read(secret)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return secret, nil
+}
+
+func validateCursorSecret(secret []byte) error {
+
+	if len(secret) < CURSOR_SECRET_MINIMUM_SIZE {
+		return ErrMissingCursorSecret
+	}
+
+	return nil
+}
+
+func encodeCursor(secret []byte, table string, key map[string]*aws_dynamodb.AttributeValue) (string, error) {
+
+	if key == nil {
+		return "", nil
+	}
+
+	payload := cursorPayload{
+		Table: table,
+		Key:   make(map[string]map[string]string),
+	}
+
+	for k, v := range key {
+
+		switch {
+		case v.N != nil:
+			payload.Key[k] = map[string]string{"N": *v.N}
+		case v.S != nil:
+			payload.Key[k] = map[string]string{"S": *v.S}
+		case v.B != nil:
+			payload.Key[k] = map[string]string{"B": base64.StdEncoding.EncodeToString(v.B)}
+		default:
+			return "", errors.New("Unsupported key attribute type")
+		}
+	}
+
+	enc_payload, err := json.Marshal(payload)
+
+	if err != nil {
+		return "", err
+	}
+
+	mac := signCursor(secret, enc_payload)
+
+	cursor := base64.RawURLEncoding.EncodeToString(enc_payload) + "." + base64.RawURLEncoding.EncodeToString(mac)
+	return cursor, nil
+}
+
+func decodeCursor(secret []byte, table string, cursor string) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+	if cursor == "" {
+		return nil, nil
+	}
+
+	parts := strings.Split(cursor, ".")
+
+	if len(parts) != 2 {
+		return nil, ErrInvalidCursor
+	}
+
+	enc_payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	mac, err := base64.RawURLEncoding.DecodeString(parts[1])
+
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if !hmac.Equal(mac, signCursor(secret, enc_payload)) {
+		return nil, ErrInvalidCursor
+	}
+
+	var payload cursorPayload
+
+	err = json.Unmarshal(enc_payload, &payload)
+
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	if payload.Table != table {
+		return nil, ErrInvalidCursor
+	}
+
+	key := make(map[string]*aws_dynamodb.AttributeValue)
+
+	for k, v := range payload.Key {
+
+		if n, ok := v["N"]; ok {
+			key[k] = &aws_dynamodb.AttributeValue{N: aws.String(n)}
+			continue
+		}
+
+		if s, ok := v["S"]; ok {
+			key[k] = &aws_dynamodb.AttributeValue{S: aws.String(s)}
+			continue
+		}
+
+		if b, ok := v["B"]; ok {
+
+			dec_b, err := base64.StdEncoding.DecodeString(b)
+
+			if err != nil {
+				return nil, ErrInvalidCursor
+			}
+
+			key[k] = &aws_dynamodb.AttributeValue{B: dec_b}
+			continue
+		}
+
+		return nil, ErrInvalidCursor
+	}
+
+	return key, nil
+}
+
+func signCursor(secret []byte, payload []byte) []byte {
+
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(payload)
+
+	return mac.Sum(nil)
+}
+
+// pageOptions returns opts or, if it is nil, DefaultPageOptions.
+func pageOptions(opts *PageOptions) *PageOptions {
+
+	if opts == nil {
+		return DefaultPageOptions()
+	}
+
+	return opts
+}
+
+func pageSize(opts *PageOptions) int64 {
+
+	size := pageOptions(opts).PageSize
+
+	if size < 1 {
+		size = PAGE_DEFAULT_SIZE
+	}
+
+	if size > PAGE_MAX_SIZE {
+		size = PAGE_MAX_SIZE
+	}
+
+	return size
+}
+
+// pageFetchFunc reads the next batch of items after start_key, returning them and the key
+// to continue from (nil if there are no more items).
+type pageFetchFunc func(start_key map[string]*aws_dynamodb.AttributeValue) ([]map[string]*aws_dynamodb.AttributeValue, map[string]*aws_dynamodb.AttributeValue, error)
+
+// scanPage scans req until size items have been collected or the table is exhausted,
+// returning the items and the key to resume from (nil if there are no more items). The
+// key attributes of the items being scanned are key_names.
+func scanPage(client *aws_dynamodb.DynamoDB, req *aws_dynamodb.ScanInput, size int64, key_names []string) ([]map[string]*aws_dynamodb.AttributeValue, map[string]*aws_dynamodb.AttributeValue, error) {
+
+	req.Limit = aws.Int64(size)
+
+	fetch := func(start_key map[string]*aws_dynamodb.AttributeValue) ([]map[string]*aws_dynamodb.AttributeValue, map[string]*aws_dynamodb.AttributeValue, error) {
+
+		req.ExclusiveStartKey = start_key
+
+		rsp, err := client.Scan(req)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return rsp.Items, rsp.LastEvaluatedKey, nil
+	}
+
+	return collectPage(req.ExclusiveStartKey, size, key_names, fetch)
+}
+
+// queryPage is the equivalent of scanPage for queries.
+func queryPage(client *aws_dynamodb.DynamoDB, req *aws_dynamodb.QueryInput, size int64, key_names []string) ([]map[string]*aws_dynamodb.AttributeValue, map[string]*aws_dynamodb.AttributeValue, error) {
+
+	req.Limit = aws.Int64(size)
+
+	fetch := func(start_key map[string]*aws_dynamodb.AttributeValue) ([]map[string]*aws_dynamodb.AttributeValue, map[string]*aws_dynamodb.AttributeValue, error) {
+
+		req.ExclusiveStartKey = start_key
+
+		rsp, err := client.Query(req)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return rsp.Items, rsp.LastEvaluatedKey, nil
+	}
+
+	return collectPage(req.ExclusiveStartKey, size, key_names, fetch)
+}
+
+// collectPage calls fetch until size items have been collected or there are no more. Each
+// request evaluates a fixed number of items (rather than only as many as are still needed,
+// which makes filtered reads very slow) so when a request returns more items than are
+// needed the page is truncated and resumes from the key of the last item returned.
+func collectPage(start_key map[string]*aws_dynamodb.AttributeValue, size int64, key_names []string, fetch pageFetchFunc) ([]map[string]*aws_dynamodb.AttributeValue, map[string]*aws_dynamodb.AttributeValue, error) {
+
+	items := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+	for {
+
+		rsp_items, last_key, err := fetch(start_key)
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		items = append(items, rsp_items...)
+
+		if int64(len(items)) > size {
+			items = items[0:size]
+			return items, itemKey(items[size-1], key_names), nil
+		}
+
+		if last_key == nil {
+			return items, nil, nil
+		}
+
+		if int64(len(items)) == size {
+			return items, last_key, nil
+		}
+
+		start_key = last_key
+	}
+}
+
+// itemKey returns the key_names attributes of item.
+func itemKey(item map[string]*aws_dynamodb.AttributeValue, key_names []string) map[string]*aws_dynamodb.AttributeValue {
+
+	key := make(map[string]*aws_dynamodb.AttributeValue)
+
+	for _, name := range key_names {
+		key[name] = item[name]
+	}
+
+	return key
+}
@@ -0,0 +1,203 @@
+package dynamodb
+
+import (
+	"context"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"testing"
+)
+
+func testItems(start int, count int) []map[string]*aws_dynamodb.AttributeValue {
+
+	items := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+	for i := start; i < start+count; i++ {
+		items = append(items, idKey(int64(i)))
+	}
+
+	return items
+}
+
+func TestCursorRoundTrip(t *testing.T) {
+
+	secret, err := NewCursorSecret()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cursor, err := encodeCursor(secret, "accounts", idKey(1234))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := decodeCursor(secret, "accounts", cursor)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if *key["id"].N != "1234" {
+		t.Fatalf("Unexpected key %v", key)
+	}
+
+	_, err = decodeCursor(secret, "tokens", cursor)
+
+	if err != ErrInvalidCursor {
+		t.Fatalf("Expected a cursor for another table to be invalid, got %v", err)
+	}
+
+	other, err := NewCursorSecret()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = decodeCursor(other, "accounts", cursor)
+
+	if err != ErrInvalidCursor {
+		t.Fatalf("Expected a cursor signed with another secret to be invalid, got %v", err)
+	}
+
+	empty, err := encodeCursor(secret, "accounts", nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if empty != "" {
+		t.Fatal("Expected an empty cursor when there are no more items")
+	}
+}
+
+func TestValidateCursorSecret(t *testing.T) {
+
+	if validateCursorSecret(nil) != ErrMissingCursorSecret {
+		t.Fatal("Expected a missing cursor secret to be rejected")
+	}
+
+	if validateCursorSecret([]byte("short")) != ErrMissingCursorSecret {
+		t.Fatal("Expected a short cursor secret to be rejected")
+	}
+
+	secret, err := NewCursorSecret()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if validateCursorSecret(secret) != nil {
+		t.Fatal("Expected a generated cursor secret to be valid")
+	}
+}
+
+func TestListPageRequiresCursorSecret(t *testing.T) {
+
+	accounts_db := &DynamoDBAccountsDatabase{
+		options: DefaultDynamoDBAccountsDatabaseOptions(),
+	}
+
+	_, _, err := accounts_db.ListAccountsPage(context.Background(), nil)
+
+	if err != ErrMissingCursorSecret {
+		t.Fatalf("Expected ErrMissingCursorSecret, got %v", err)
+	}
+
+	tokens_db := &DynamoDBAccessTokensDatabase{
+		options: DefaultDynamoDBAccessTokensDatabaseOptions(),
+	}
+
+	_, _, err = tokens_db.ListAccessTokensPage(context.Background(), nil)
+
+	if err != ErrMissingCursorSecret {
+		t.Fatalf("Expected ErrMissingCursorSecret, got %v", err)
+	}
+}
+
+func TestPageOptions(t *testing.T) {
+
+	if pageSize(nil) != PAGE_DEFAULT_SIZE {
+		t.Fatal("Expected nil page options to use the default page size")
+	}
+
+	if pageSize(&PageOptions{PageSize: PAGE_MAX_SIZE + 1}) != PAGE_MAX_SIZE {
+		t.Fatal("Expected page size to be capped")
+	}
+}
+
+func TestCollectPage(t *testing.T) {
+
+	// A sparse filter: each request evaluates 10 items but only matches 3 of them, except
+	// for the last which matches 5
+
+	batches := [][]map[string]*aws_dynamodb.AttributeValue{
+		testItems(0, 3),
+		testItems(10, 3),
+		testItems(20, 5),
+	}
+
+	requests := 0
+
+	fetch := func(start_key map[string]*aws_dynamodb.AttributeValue) ([]map[string]*aws_dynamodb.AttributeValue, map[string]*aws_dynamodb.AttributeValue, error) {
+
+		i := requests
+		requests += 1
+
+		var last_key map[string]*aws_dynamodb.AttributeValue
+
+		if i < len(batches)-1 {
+			last_key = idKey(int64(i*10 + 9))
+		}
+
+		return batches[i], last_key, nil
+	}
+
+	items, last_key, err := collectPage(nil, 7, []string{"id"}, fetch)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 7 {
+		t.Fatalf("Expected 7 items but got %d", len(items))
+	}
+
+	if requests != 3 {
+		t.Fatalf("Expected 3 requests but made %d", requests)
+	}
+
+	// The last batch was truncated so the page resumes after the last item returned
+
+	if last_key == nil || *last_key["id"].N != "20" {
+		t.Fatalf("Unexpected resume key %v", last_key)
+	}
+
+	requests = 0
+
+	items, last_key, err = collectPage(nil, 100, []string{"id"}, fetch)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(items) != 11 || last_key != nil {
+		t.Fatalf("Expected all 11 items and no resume key, got %d items and %v", len(items), last_key)
+	}
+}
+
+func TestItemKey(t *testing.T) {
+
+	item := map[string]*aws_dynamodb.AttributeValue{
+		"id":           {N: aws.String(strconv.Itoa(1))},
+		"account_id":   {N: aws.String(strconv.Itoa(2))},
+		"access_token": {S: aws.String("secret")},
+	}
+
+	key := itemKey(item, []string{"account_id", "id"})
+
+	if len(key) != 2 || *key["id"].N != "1" || *key["account_id"].N != "2" {
+		t.Fatalf("Unexpected key %v", key)
+	}
+}
@@ -0,0 +1,577 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-aws-session"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"strconv"
+	"time"
+)
+
+const MFADEVICES_DEFAULT_TABLENAME string = "mfa_devices"
+
+const MFA_DEVICE_STATUS_PENDING string = "pending"
+
+const MFA_DEVICE_STATUS_ACTIVE string = "active"
+
+var ErrNoMFADevice = errors.New("MFA device does not exist")
+
+type DynamoDBMFADevicesDatabaseOptions struct {
+	TableName   string
+	BillingMode string
+	CreateTable bool
+	Retry       *RetryOptions
+	Issuer      string
+	MFASkew     uint
+	// KeyProvider, if set, is used to encrypt device secrets. Devices enrolled without
+	// encryption can still be read, and are encrypted by RotateDeviceEncryption.
+	KeyProvider KeyProvider
+}
+
+func DefaultDynamoDBMFADevicesDatabaseOptions() *DynamoDBMFADevicesDatabaseOptions {
+
+	opts := DynamoDBMFADevicesDatabaseOptions{
+		TableName:   MFADEVICES_DEFAULT_TABLENAME,
+		BillingMode: "PAY_PER_REQUEST",
+		CreateTable: false,
+		Retry:       DefaultRetryOptions(),
+		Issuer:      "go-auth",
+		MFASkew:     1,
+	}
+
+	return &opts
+}
+
+type MFADevice struct {
+	AccountID    int64            `json:"account_id"`
+	Name         string           `json:"name"`
+	Secret       string           `json:"secret,omitempty"`
+	Status       string           `json:"status"`
+	Created      int64            `json:"created"`
+	LastModified int64            `json:"lastmodified"`
+	LastUsed     int64            `json:"last_used"`
+	LastStep     int64            `json:"last_step"`
+	Encrypted    *EncryptedFields `json:"encrypted,omitempty"`
+}
+
+func (d *MFADevice) IsActive() bool {
+	return d.Status == MFA_DEVICE_STATUS_ACTIVE
+}
+
+type DynamoDBMFADevicesDatabase struct {
+	client  *aws_dynamodb.DynamoDB
+	options *DynamoDBMFADevicesDatabaseOptions
+}
+
+func NewDynamoDBMFADevicesDatabaseWithDSN(dsn string, opts *DynamoDBMFADevicesDatabaseOptions) (*DynamoDBMFADevicesDatabase, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDynamoDBMFADevicesDatabaseWithSession(sess, opts)
+}
+
+func NewDynamoDBMFADevicesDatabaseWithSession(sess *aws_session.Session, opts *DynamoDBMFADevicesDatabaseOptions) (*DynamoDBMFADevicesDatabase, error) {
+
+	client := newDynamoDBClient(sess, opts.Retry)
+
+	if opts.CreateTable {
+
+		_, err := CreateMFADevicesTable(client, opts)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db := DynamoDBMFADevicesDatabase{
+		client:  client,
+		options: opts,
+	}
+
+	return &db, nil
+}
+
+// EnrollDevice creates a new TOTP device called name for acct. The device is pending,
+// and will not be accepted by VerifyCode, until it has been confirmed with ConfirmDevice.
+// The returned key should be used to provision the user's authenticator.
+func (db *DynamoDBMFADevicesDatabase) EnrollDevice(acct *account.Account, name string) (*MFADevice, *otp.Key, error) {
+
+	if name == "" {
+		return nil, nil, errors.New("Invalid device name")
+	}
+
+	key_opts := totp.GenerateOpts{
+		Issuer:      db.options.Issuer,
+		AccountName: acct.Address.URI,
+	}
+
+	key, err := totp.Generate(key_opts)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	now := time.Now()
+
+	device := MFADevice{
+		AccountID:    acct.ID,
+		Name:         name,
+		Secret:       key.Secret(),
+		Status:       MFA_DEVICE_STATUS_PENDING,
+		Created:      now.Unix(),
+		LastModified: now.Unix(),
+	}
+
+	item, err := db.deviceToItem(&device)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	req := &aws_dynamodb.PutItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#account_id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#account_id": aws.String("account_id"),
+		},
+	}
+
+	_, err = db.client.PutItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+			return nil, nil, errors.New("Device already exists")
+		}
+
+		return nil, nil, err
+	}
+
+	return &device, key, nil
+}
+
+// ConfirmDevice activates a pending device if code is a valid TOTP code for it.
+func (db *DynamoDBMFADevicesDatabase) ConfirmDevice(acct *account.Account, name string, code string) (bool, error) {
+
+	device, err := db.GetDevice(acct, name)
+
+	if err != nil {
+		return false, err
+	}
+
+	if device.Status != MFA_DEVICE_STATUS_PENDING {
+		return false, errors.New("Device is not pending")
+	}
+
+	step, ok, err := matchTOTPStep(device.Secret, code, time.Now(), db.options.MFASkew)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	now := time.Now()
+
+	str_now := strconv.FormatInt(now.Unix(), 10)
+	str_step := strconv.FormatInt(step, 10)
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Key:                 deviceKey(acct.ID, name),
+		UpdateExpression:    aws.String("SET #status = :active, #step = :step, #last_used = :now, #lastmodified = :now"),
+		ConditionExpression: aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]*string{
+			"#status":       aws.String("status"),
+			"#step":         aws.String("last_step"),
+			"#last_used":    aws.String("last_used"),
+			"#lastmodified": aws.String("lastmodified"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":active":  {S: aws.String(MFA_DEVICE_STATUS_ACTIVE)},
+			":pending": {S: aws.String(MFA_DEVICE_STATUS_PENDING)},
+			":step":    {N: aws.String(str_step)},
+			":now":     {N: aws.String(str_now)},
+		},
+	}
+
+	_, err = db.client.UpdateItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+			return false, errors.New("Device is not pending")
+		}
+
+		return false, err
+	}
+
+	return true, nil
+}
+
+// VerifyCode returns the active device for which code is a valid, previously unused,
+// TOTP code or nil if there is no such device. As with DynamoDBAccountsDatabase.VerifyMFACode
+// a valid code that has already been used, and is not accepted by any other device,
+// returns ErrMFACodeReused.
+func (db *DynamoDBMFADevicesDatabase) VerifyCode(acct *account.Account, code string) (*MFADevice, error) {
+
+	devices, err := db.ListDevices(acct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	reused := false
+
+	for _, device := range devices {
+
+		if !device.IsActive() {
+			continue
+		}
+
+		step, ok, err := matchTOTPStep(device.Secret, code, now, db.options.MFASkew)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !ok {
+			continue
+		}
+
+		str_now := strconv.FormatInt(now.Unix(), 10)
+		str_step := strconv.FormatInt(step, 10)
+
+		req := &aws_dynamodb.UpdateItemInput{
+			TableName:           aws.String(db.options.TableName),
+			Key:                 deviceKey(acct.ID, device.Name),
+			UpdateExpression:    aws.String("SET #step = :step, #last_used = :now"),
+			ConditionExpression: aws.String("#status = :active AND (attribute_not_exists(#step) OR #step < :step)"),
+			ExpressionAttributeNames: map[string]*string{
+				"#status":    aws.String("status"),
+				"#step":      aws.String("last_step"),
+				"#last_used": aws.String("last_used"),
+			},
+			ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+				":active": {S: aws.String(MFA_DEVICE_STATUS_ACTIVE)},
+				":step":   {N: aws.String(str_step)},
+				":now":    {N: aws.String(str_now)},
+			},
+		}
+
+		_, err = db.client.UpdateItem(req)
+
+		if err != nil {
+
+			// The code may still be valid, and unused, for another device
+
+			if isConditionalCheckFailed(err) {
+				reused = true
+				continue
+			}
+
+			return nil, err
+		}
+
+		device.LastStep = step
+		device.LastUsed = now.Unix()
+
+		return device, nil
+	}
+
+	if reused {
+		return nil, ErrMFACodeReused
+	}
+
+	return nil, nil
+}
+
+func (db *DynamoDBMFADevicesDatabase) GetDevice(acct *account.Account, name string) (*MFADevice, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:      aws.String(db.options.TableName),
+		Key:            deviceKey(acct.ID, name),
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rsp.Item) == 0 {
+		return nil, ErrNoMFADevice
+	}
+
+	return db.itemToDevice(rsp.Item)
+}
+
+func (db *DynamoDBMFADevicesDatabase) ListDevices(acct *account.Account) ([]*MFADevice, error) {
+
+	str_id := strconv.FormatInt(acct.ID, 10)
+
+	req := &aws_dynamodb.QueryInput{
+		TableName:              aws.String(db.options.TableName),
+		KeyConditionExpression: aws.String("#account_id = :account_id"),
+		ExpressionAttributeNames: map[string]*string{
+			"#account_id": aws.String("account_id"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":account_id": {N: aws.String(str_id)},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	devices := make([]*MFADevice, 0)
+
+	for {
+
+		rsp, err := db.client.Query(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		for _, item := range rsp.Items {
+
+			device, err := db.itemToDevice(item)
+
+			if err != nil {
+				return nil, err
+			}
+
+			devices = append(devices, device)
+		}
+
+		req.ExclusiveStartKey = rsp.LastEvaluatedKey
+
+		if rsp.LastEvaluatedKey == nil {
+			break
+		}
+	}
+
+	return devices, nil
+}
+
+func (db *DynamoDBMFADevicesDatabase) RemoveDevice(acct *account.Account, name string) error {
+
+	req := &aws_dynamodb.DeleteItemInput{
+		TableName: aws.String(db.options.TableName),
+		Key:       deviceKey(acct.ID, name),
+	}
+
+	_, err := db.client.DeleteItem(req)
+	return err
+}
+
+func deviceKey(account_id int64, name string) map[string]*aws_dynamodb.AttributeValue {
+
+	str_id := strconv.FormatInt(account_id, 10)
+
+	key := map[string]*aws_dynamodb.AttributeValue{
+		"account_id": {
+			N: aws.String(str_id),
+		},
+		"name": {
+			S: aws.String(name),
+		},
+	}
+
+	return key
+}
+
+// RotateDeviceEncryption encrypts every device secret that is stored in plaintext or whose
+// data key was not wrapped with the key provider's current key, returning the number of
+// devices updated.
+func (db *DynamoDBMFADevicesDatabase) RotateDeviceEncryption(ctx context.Context) (int, error) {
+
+	if db.options.KeyProvider == nil {
+		return 0, errors.New("No key provider configured")
+	}
+
+	count := 0
+
+	err := scanItems(ctx, db.client, db.options.TableName, func(item map[string]*aws_dynamodb.AttributeValue) error {
+
+		device, err := itemToMFADevice(item)
+
+		if err != nil {
+			return err
+		}
+
+		if !needsEncryption(db.options.KeyProvider, device.Encrypted) {
+			return nil
+		}
+
+		err = decryptDeviceSecret(db.options.KeyProvider, device)
+
+		if err != nil {
+			return fmt.Errorf("Failed to decrypt device %d/%s, %s", device.AccountID, device.Name, err)
+		}
+
+		err = encryptDeviceSecret(db.options.KeyProvider, device)
+
+		if err != nil {
+			return err
+		}
+
+		enc, err := aws_dynamodbattribute.Marshal(device.Encrypted)
+
+		if err != nil {
+			return err
+		}
+
+		// Only the secret is rewritten so that concurrent confirmations and verifications
+		// are not reverted, and only if the device still has the secret that was read
+		// so that a device which was removed and enrolled again is not given its old one
+
+		update_req := newDeviceEncryptionUpdate(db.options.TableName, item, device, enc)
+
+		_, err = db.client.UpdateItem(update_req)
+
+		if err != nil {
+
+			if isConditionalCheckFailed(err) {
+				return nil
+			}
+
+			return err
+		}
+
+		count += 1
+		return nil
+	})
+
+	return count, err
+}
+
+// newDeviceEncryptionUpdate returns the request that replaces device's secret, as read in
+// item, with enc on the condition that the device still exists and that its "created",
+// "secret" and "encrypted" attributes have not changed since item was read.
+func newDeviceEncryptionUpdate(table string, item map[string]*aws_dynamodb.AttributeValue, device *MFADevice, enc *aws_dynamodb.AttributeValue) *aws_dynamodb.UpdateItemInput {
+
+	attr_names := map[string]*string{
+		"#account_id": aws.String("account_id"),
+		"#encrypted":  aws.String("encrypted"),
+		"#secret":     aws.String("secret"),
+	}
+
+	attr_values := map[string]*aws_dynamodb.AttributeValue{
+		":encrypted": enc,
+	}
+
+	pre_image := preImageCondition(item, []string{"created", "secret", "encrypted"}, attr_names, attr_values)
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:                 aws.String(table),
+		Key:                       deviceKey(device.AccountID, device.Name),
+		UpdateExpression:          aws.String("SET #encrypted = :encrypted REMOVE #secret"),
+		ConditionExpression:       aws.String("attribute_exists(#account_id) AND " + pre_image),
+		ExpressionAttributeNames:  attr_names,
+		ExpressionAttributeValues: attr_values,
+	}
+
+	return req
+}
+
+func (db *DynamoDBMFADevicesDatabase) deviceToItem(device *MFADevice) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+	if db.options.KeyProvider == nil {
+		return aws_dynamodbattribute.MarshalMap(device)
+	}
+
+	stored := *device
+
+	err := encryptDeviceSecret(db.options.KeyProvider, &stored)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return aws_dynamodbattribute.MarshalMap(stored)
+}
+
+func (db *DynamoDBMFADevicesDatabase) itemToDevice(item map[string]*aws_dynamodb.AttributeValue) (*MFADevice, error) {
+
+	device, err := itemToMFADevice(item)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = decryptDeviceSecret(db.options.KeyProvider, device)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
+
+// encryptDeviceSecret moves device's secret in to an encrypted envelope.
+func encryptDeviceSecret(provider KeyProvider, device *MFADevice) error {
+
+	values := map[string]interface{}{
+		"secret": device.Secret,
+	}
+
+	enc, err := encryptValues(provider, deviceAADPrefix(device), values)
+
+	if err != nil {
+		return err
+	}
+
+	device.Secret = ""
+	device.Encrypted = enc
+
+	return nil
+}
+
+// decryptDeviceSecret restores the secret encrypted by encryptDeviceSecret, if there is one.
+func decryptDeviceSecret(provider KeyProvider, device *MFADevice) error {
+
+	if device.Encrypted == nil || device.Secret != "" {
+		return nil
+	}
+
+	targets := map[string]interface{}{
+		"secret": &device.Secret,
+	}
+
+	return decryptValues(provider, device.Encrypted, deviceAADPrefix(device), targets)
+}
+
+func deviceAADPrefix(device *MFADevice) string {
+	return "mfa_device#" + strconv.FormatInt(device.AccountID, 10) + "#" + device.Name
+}
+
+func itemToMFADevice(item map[string]*aws_dynamodb.AttributeValue) (*MFADevice, error) {
+
+	var device *MFADevice
+
+	err := aws_dynamodbattribute.UnmarshalMap(item, &device)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return device, nil
+}
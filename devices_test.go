@@ -0,0 +1,42 @@
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"testing"
+)
+
+func TestNewDeviceEncryptionUpdate(t *testing.T) {
+
+	item := map[string]*aws_dynamodb.AttributeValue{
+		"account_id": {N: aws.String("1234")},
+		"name":       {S: aws.String("phone")},
+		"created":    {N: aws.String("1000")},
+		"secret":     {S: aws.String("JBSWY3DPEHPK3PXP")},
+	}
+
+	device := &MFADevice{
+		AccountID: 1234,
+		Name:      "phone",
+	}
+
+	enc := &aws_dynamodb.AttributeValue{
+		M: map[string]*aws_dynamodb.AttributeValue{},
+	}
+
+	req := newDeviceEncryptionUpdate("devices", item, device, enc)
+
+	expected := "attribute_exists(#account_id) AND #p0 = :p0 AND #p1 = :p1 AND attribute_not_exists(#p2)"
+
+	if aws.StringValue(req.ConditionExpression) != expected {
+		t.Fatalf("Unexpected condition '%s'", aws.StringValue(req.ConditionExpression))
+	}
+
+	if req.ExpressionAttributeValues[":p0"] != item["created"] || req.ExpressionAttributeValues[":p1"] != item["secret"] {
+		t.Fatal("Expected the update to be conditional on the device's created time and secret")
+	}
+
+	if aws.StringValue(req.ExpressionAttributeNames["#p2"]) != "encrypted" {
+		t.Fatalf("Unexpected name for #p2, %v", aws.StringValue(req.ExpressionAttributeNames["#p2"]))
+	}
+}
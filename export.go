@@ -0,0 +1,643 @@
+package dynamodb
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"io"
+)
+
+const IMPORT_CONFLICT_SKIP string = "skip"
+
+const IMPORT_CONFLICT_OVERWRITE string = "overwrite"
+
+const IMPORT_CONFLICT_FAIL string = "fail"
+
+var ErrImportConflict = errors.New("Item already exists")
+
+var ErrImportRedacted = errors.New("Redacted records can not be imported")
+
+// EXPORT_REDACTED is the value that redacted attributes are replaced with.
+const EXPORT_REDACTED string = "[REDACTED]"
+
+// Attributes (and nested account fields) whose values are replaced when exporting with
+// ExportOptions.Redact set.
+var exportRedactedAttributes = map[string][]string{
+	SCHEMA_TARGET_ACCOUNTS: {
+		"password",
+		"password_digest",
+		"password_salt",
+		"password_history",
+		"mfa",
+		"mfa_secret",
+		"mfa_recovery_codes",
+		"mfa_recovery_salt",
+		"encrypted",
+	},
+	SCHEMA_TARGET_TOKENS: {
+		"access_token",
+	},
+}
+
+// ExportRecord is a single line of a JSONL export. Item uses the same typed attribute
+// encoding ({"S": "..."}, {"N": "..."} and so on) as DynamoDB's own JSON exports.
+type ExportRecord struct {
+	Target   string                           `json:"target"`
+	Redacted bool                             `json:"redacted,omitempty"`
+	Item     map[string]*ExportAttributeValue `json:"item"`
+}
+
+// ExportAttributeValue mirrors aws_dynamodb.AttributeValue. L and M are pointers so that
+// empty lists and maps are distinguished from absent ones when encoded.
+type ExportAttributeValue struct {
+	B    []byte                            `json:"B,omitempty"`
+	BOOL *bool                             `json:"BOOL,omitempty"`
+	BS   [][]byte                          `json:"BS,omitempty"`
+	L    *[]*ExportAttributeValue          `json:"L,omitempty"`
+	M    *map[string]*ExportAttributeValue `json:"M,omitempty"`
+	N    *string                           `json:"N,omitempty"`
+	NS   []*string                         `json:"NS,omitempty"`
+	NULL *bool                             `json:"NULL,omitempty"`
+	S    *string                           `json:"S,omitempty"`
+	SS   []*string                         `json:"SS,omitempty"`
+}
+
+type ExportOptions struct {
+	Redact   bool
+	Progress func(int64)
+}
+
+// importIndex is an attribute, with a global secondary index of the same name, whose values
+// imported items must not share with a different item. Tables are the tables it is queried in.
+type importIndex struct {
+	name   string
+	tables []string
+}
+
+// importCollisions checks imported items against the index values of the items already in
+// the database and of the items imported before them.
+type importCollisions struct {
+	client  *aws_dynamodb.DynamoDB
+	indexes []*importIndex
+	claimed map[string]string
+}
+
+// ImportOptions controls a call to Import. If Conflict is IMPORT_CONFLICT_FAIL and the
+// reader passed to Import can be rewound (for example a file) every record is checked for
+// conflicts before any are written. Otherwise, for example when reading from a pipe,
+// records are checked one batch at a time and the batches written before a conflict is
+// found are not rolled back.
+type ImportOptions struct {
+	Conflict string
+	Progress func(*ImportResult)
+}
+
+type ImportResult struct {
+	Read        int64 `json:"read"`
+	Written     int64 `json:"written"`
+	Skipped     int64 `json:"skipped"`
+	Overwritten int64 `json:"overwritten"`
+}
+
+// Export writes every item in the accounts table to wr as JSONL, returning the number of
+// items written. Items are exported as stored, so encrypted fields remain encrypted.
+func (db *DynamoDBAccountsDatabase) Export(ctx context.Context, wr io.Writer, opts *ExportOptions) (int64, error) {
+	return exportTable(ctx, db.client, db.options.TableName, SCHEMA_TARGET_ACCOUNTS, wr, opts)
+}
+
+// Export writes every item in the access tokens table to wr as JSONL, returning the number
+// of items written.
+func (db *DynamoDBAccessTokensDatabase) Export(ctx context.Context, wr io.Writer, opts *ExportOptions) (int64, error) {
+	return exportTable(ctx, db.client, db.options.TableName, SCHEMA_TARGET_TOKENS, wr, opts)
+}
+
+// Import loads accounts previously written by Export from rd. Items are restored exactly
+// as exported. Accounts whose "email", "url" or "skeleton" value belongs to a different
+// account, either in the database or earlier in rd, are always skipped (or fail the import
+// when opts.Conflict is IMPORT_CONFLICT_FAIL). Pending email address reservations are not
+// checked.
+func (db *DynamoDBAccountsDatabase) Import(ctx context.Context, rd io.Reader, opts *ImportOptions) (*ImportResult, error) {
+
+	tables := readTables(db.options.TableName, db.options.FallbackTableName)
+
+	indexes := []*importIndex{
+		{name: "email", tables: tables},
+		{name: "url", tables: tables},
+		{name: "skeleton", tables: []string{db.options.TableName}},
+	}
+
+	return importTable(ctx, db.client, db.options.TableName, SCHEMA_TARGET_ACCOUNTS, db.options.Retry, rd, opts, indexes)
+}
+
+// Import loads access tokens previously written by Export from rd.
+func (db *DynamoDBAccessTokensDatabase) Import(ctx context.Context, rd io.Reader, opts *ImportOptions) (*ImportResult, error) {
+	return importTable(ctx, db.client, db.options.TableName, SCHEMA_TARGET_TOKENS, db.options.Retry, rd, opts, nil)
+}
+
+func exportTable(ctx context.Context, client *aws_dynamodb.DynamoDB, table string, target string, wr io.Writer, opts *ExportOptions) (int64, error) {
+
+	enc := json.NewEncoder(wr)
+
+	req := &aws_dynamodb.ScanInput{
+		TableName: aws.String(table),
+	}
+
+	count := int64(0)
+
+	err := scanPages(ctx, client, req, func(rsp *aws_dynamodb.ScanOutput) error {
+
+		for _, item := range rsp.Items {
+
+			if opts.Redact {
+				item = redactItem(target, item)
+			}
+
+			rec := ExportRecord{
+				Target:   target,
+				Redacted: opts.Redact,
+				Item:     toExportItem(item),
+			}
+
+			err := enc.Encode(rec)
+
+			if err != nil {
+				return err
+			}
+
+			count += 1
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(count)
+		}
+
+		return nil
+	})
+
+	return count, err
+}
+
+func importTable(ctx context.Context, client *aws_dynamodb.DynamoDB, table string, target string, retry *RetryOptions, rd io.Reader, opts *ImportOptions, indexes []*importIndex) (*ImportResult, error) {
+
+	switch opts.Conflict {
+	case IMPORT_CONFLICT_SKIP, IMPORT_CONFLICT_OVERWRITE, IMPORT_CONFLICT_FAIL:
+		// pass
+	default:
+		return nil, fmt.Errorf("Invalid conflict policy '%s'", opts.Conflict)
+	}
+
+	result := &ImportResult{}
+
+	// If rd can be rewound check every record for conflicts before writing anything, so that
+	// "fail" does not leave a partial import behind. Otherwise records are checked as they
+	// are written and earlier batches will already have been imported.
+
+	if opts.Conflict == IMPORT_CONFLICT_FAIL {
+
+		seeker, ok := rd.(io.Seeker)
+
+		var offset int64
+
+		if ok {
+
+			pos, err := seeker.Seek(0, io.SeekCurrent)
+
+			if err != nil {
+				ok = false
+			}
+
+			offset = pos
+		}
+
+		if ok {
+
+			collisions := newImportCollisions(client, indexes)
+
+			err := readImportRecords(ctx, rd, target, func(batch []map[string]*aws_dynamodb.AttributeValue) error {
+				return checkImportConflicts(client, table, retry, batch, collisions)
+			})
+
+			if err != nil {
+				return result, err
+			}
+
+			_, err = seeker.Seek(offset, io.SeekStart)
+
+			if err != nil {
+				return result, err
+			}
+		}
+	}
+
+	collisions := newImportCollisions(client, indexes)
+
+	err := readImportRecords(ctx, rd, target, func(batch []map[string]*aws_dynamodb.AttributeValue) error {
+
+		result.Read += int64(len(batch))
+
+		err := importBatch(client, table, retry, batch, opts.Conflict, collisions, result)
+
+		if err != nil {
+			return err
+		}
+
+		if opts.Progress != nil {
+			opts.Progress(result)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// readImportRecords parses the records for target in rd, passing them to cb in batches of
+// up to BATCH_WRITE_MAX_ITEMS items.
+func readImportRecords(ctx context.Context, rd io.Reader, target string, cb func([]map[string]*aws_dynamodb.AttributeValue) error) error {
+
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	batch := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+	count := 0
+
+	for scanner.Scan() {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		ln := scanner.Bytes()
+
+		if len(ln) == 0 {
+			continue
+		}
+
+		count += 1
+
+		var rec ExportRecord
+
+		err := json.Unmarshal(ln, &rec)
+
+		if err != nil {
+			return fmt.Errorf("Failed to parse record %d, %s", count, err)
+		}
+
+		if rec.Target != target {
+			return fmt.Errorf("Record %d is for %s, not %s", count, rec.Target, target)
+		}
+
+		if rec.Redacted {
+			return ErrImportRedacted
+		}
+
+		item := fromExportItem(rec.Item)
+
+		id, ok := item["id"]
+
+		if !ok || id == nil || id.N == nil {
+			return fmt.Errorf("Record %d is missing a numeric 'id' attribute", count)
+		}
+
+		batch = append(batch, item)
+
+		if len(batch) == BATCH_WRITE_MAX_ITEMS {
+
+			err := cb(batch)
+
+			if err != nil {
+				return err
+			}
+
+			batch = make([]map[string]*aws_dynamodb.AttributeValue, 0)
+		}
+	}
+
+	err := scanner.Err()
+
+	if err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		return cb(batch)
+	}
+
+	return nil
+}
+
+// checkImportConflicts returns an error if any of items already exist or collide with
+// another item.
+func checkImportConflicts(client *aws_dynamodb.DynamoDB, table string, retry *RetryOptions, items []map[string]*aws_dynamodb.AttributeValue, collisions *importCollisions) error {
+
+	keys := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+	for _, item := range items {
+		keys = append(keys, map[string]*aws_dynamodb.AttributeValue{"id": item["id"]})
+	}
+
+	existing, err := batchGetKeys(client, table, keys, &ReadOptions{ConsistentRead: true}, retry)
+
+	if err != nil {
+		return err
+	}
+
+	if len(existing) > 0 {
+		return fmt.Errorf("Failed to import item %s, %s", *existing[0]["id"].N, ErrImportConflict)
+	}
+
+	for _, item := range items {
+
+		reason, err := collisions.check(item)
+
+		if err != nil {
+			return err
+		}
+
+		if reason != "" {
+			return fmt.Errorf("Failed to import item %s, %s", *item["id"].N, reason)
+		}
+	}
+
+	return nil
+}
+
+// importBatch writes items according to conflict. Existing items are looked up before
+// writing, so an item created by someone else between the two may still be overwritten.
+// Items that collide with a different item are skipped, or fail the import if conflict is
+// IMPORT_CONFLICT_FAIL, since overwriting can not resolve them.
+func importBatch(client *aws_dynamodb.DynamoDB, table string, retry *RetryOptions, items []map[string]*aws_dynamodb.AttributeValue, conflict string, collisions *importCollisions, result *ImportResult) error {
+
+	keys := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+	for _, item := range items {
+		keys = append(keys, map[string]*aws_dynamodb.AttributeValue{"id": item["id"]})
+	}
+
+	existing, err := batchGetKeys(client, table, keys, &ReadOptions{ConsistentRead: true}, retry)
+
+	if err != nil {
+		return err
+	}
+
+	exists := make(map[string]bool)
+
+	for _, item := range existing {
+		exists[*item["id"].N] = true
+	}
+
+	requests := make([]*aws_dynamodb.WriteRequest, 0)
+	seen := make(map[string]bool)
+
+	for _, item := range items {
+
+		id := *item["id"].N
+
+		if seen[id] {
+			return fmt.Errorf("Item %s appears more than once in the same batch", id)
+		}
+
+		seen[id] = true
+
+		if exists[id] {
+
+			switch conflict {
+			case IMPORT_CONFLICT_SKIP:
+				result.Skipped += 1
+				continue
+			case IMPORT_CONFLICT_FAIL:
+				return fmt.Errorf("Failed to import item %s, %s", id, ErrImportConflict)
+			}
+		}
+
+		reason, err := collisions.check(item)
+
+		if err != nil {
+			return err
+		}
+
+		if reason != "" {
+
+			if conflict == IMPORT_CONFLICT_FAIL {
+				return fmt.Errorf("Failed to import item %s, %s", id, reason)
+			}
+
+			result.Skipped += 1
+			continue
+		}
+
+		if exists[id] {
+			result.Overwritten += 1
+		}
+
+		req := &aws_dynamodb.WriteRequest{
+			PutRequest: &aws_dynamodb.PutRequest{
+				Item: item,
+			},
+		}
+
+		requests = append(requests, req)
+	}
+
+	err = batchWriteItems(client, table, requests, retry)
+
+	if err != nil {
+		return err
+	}
+
+	result.Written += int64(len(requests))
+	return nil
+}
+
+func newImportCollisions(client *aws_dynamodb.DynamoDB, indexes []*importIndex) *importCollisions {
+
+	c := &importCollisions{
+		client:  client,
+		indexes: indexes,
+		claimed: make(map[string]string),
+	}
+
+	return c
+}
+
+// check returns a reason if any of item's index values belong to a different item, and
+// otherwise records them as belonging to item.
+func (c *importCollisions) check(item map[string]*aws_dynamodb.AttributeValue) (string, error) {
+
+	id := *item["id"].N
+
+	values := make(map[string]string)
+
+	for _, idx := range c.indexes {
+
+		v, ok := item[idx.name]
+
+		if !ok || v == nil || v.S == nil || *v.S == "" {
+			continue
+		}
+
+		key := idx.name + ":" + *v.S
+
+		owner, ok := c.claimed[key]
+
+		if ok && owner != id {
+			return fmt.Sprintf("Index value for '%s' belongs to item %s", idx.name, owner), nil
+		}
+
+		matches, err := queryPointer(c.client, idx.tables, idx.name, idx.name, *v.S)
+
+		if err != nil {
+			return "", fmt.Errorf("Failed to query '%s' index, %s", idx.name, err)
+		}
+
+		for _, m := range matches {
+
+			if *m["id"].N != id {
+				return fmt.Sprintf("Index value for '%s' belongs to item %s", idx.name, *m["id"].N), nil
+			}
+		}
+
+		values[key] = id
+	}
+
+	for k, v := range values {
+		c.claimed[k] = v
+	}
+
+	return "", nil
+}
+
+func redactItem(target string, item map[string]*aws_dynamodb.AttributeValue) map[string]*aws_dynamodb.AttributeValue {
+
+	redacted := copyItem(item)
+
+	for _, name := range exportRedactedAttributes[target] {
+
+		_, ok := redacted[name]
+
+		if ok {
+			redacted[name] = &aws_dynamodb.AttributeValue{S: aws.String(EXPORT_REDACTED)}
+		}
+	}
+
+	// Nested (ACCOUNT_SCHEMA_NESTED) accounts keep their password and MFA secrets inside
+	// the "account" map
+
+	nested, ok := redacted["account"]
+
+	if ok && nested.M != nil {
+
+		m := copyItem(nested.M)
+
+		for _, name := range exportRedactedAttributes[target] {
+
+			_, ok := m[name]
+
+			if ok {
+				m[name] = &aws_dynamodb.AttributeValue{S: aws.String(EXPORT_REDACTED)}
+			}
+		}
+
+		redacted["account"] = &aws_dynamodb.AttributeValue{M: m}
+	}
+
+	return redacted
+}
+
+func toExportItem(item map[string]*aws_dynamodb.AttributeValue) map[string]*ExportAttributeValue {
+
+	e := make(map[string]*ExportAttributeValue)
+
+	for k, v := range item {
+		e[k] = toExportAttributeValue(v)
+	}
+
+	return e
+}
+
+func toExportAttributeValue(v *aws_dynamodb.AttributeValue) *ExportAttributeValue {
+
+	if v == nil {
+		return nil
+	}
+
+	e := ExportAttributeValue{
+		B:    v.B,
+		BOOL: v.BOOL,
+		BS:   v.BS,
+		N:    v.N,
+		NS:   v.NS,
+		NULL: v.NULL,
+		S:    v.S,
+		SS:   v.SS,
+	}
+
+	if v.L != nil {
+
+		l := make([]*ExportAttributeValue, len(v.L))
+
+		for i, lv := range v.L {
+			l[i] = toExportAttributeValue(lv)
+		}
+
+		e.L = &l
+	}
+
+	if v.M != nil {
+		m := toExportItem(v.M)
+		e.M = &m
+	}
+
+	return &e
+}
+
+func fromExportItem(e map[string]*ExportAttributeValue) map[string]*aws_dynamodb.AttributeValue {
+
+	item := make(map[string]*aws_dynamodb.AttributeValue)
+
+	for k, v := range e {
+		item[k] = fromExportAttributeValue(v)
+	}
+
+	return item
+}
+
+func fromExportAttributeValue(e *ExportAttributeValue) *aws_dynamodb.AttributeValue {
+
+	if e == nil {
+		return nil
+	}
+
+	v := aws_dynamodb.AttributeValue{
+		B:    e.B,
+		BOOL: e.BOOL,
+		BS:   e.BS,
+		N:    e.N,
+		NS:   e.NS,
+		NULL: e.NULL,
+		S:    e.S,
+		SS:   e.SS,
+	}
+
+	if e.L != nil {
+
+		v.L = make([]*aws_dynamodb.AttributeValue, len(*e.L))
+
+		for i, le := range *e.L {
+			v.L[i] = fromExportAttributeValue(le)
+		}
+	}
+
+	if e.M != nil {
+		v.M = fromExportItem(*e.M)
+	}
+
+	return &v
+}
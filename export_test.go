@@ -0,0 +1,106 @@
+package dynamodb
+
+import (
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"reflect"
+	"testing"
+)
+
+func TestExportAttributeValueRoundTrip(t *testing.T) {
+
+	item := map[string]*aws_dynamodb.AttributeValue{
+		"id":    {N: aws.String("1234")},
+		"s":     {S: aws.String("value")},
+		"b":     {B: []byte("bytes")},
+		"bool":  {BOOL: aws.Bool(false)},
+		"null":  {NULL: aws.Bool(true)},
+		"ss":    {SS: []*string{aws.String("a"), aws.String("b")}},
+		"ns":    {NS: []*string{aws.String("1"), aws.String("2")}},
+		"bs":    {BS: [][]byte{[]byte("a"), []byte("b")}},
+		"empty": {L: []*aws_dynamodb.AttributeValue{}},
+		"l": {L: []*aws_dynamodb.AttributeValue{
+			{S: aws.String("a")},
+			{N: aws.String("1")},
+		}},
+		"m": {M: map[string]*aws_dynamodb.AttributeValue{
+			"nested": {M: map[string]*aws_dynamodb.AttributeValue{}},
+			"s":      {S: aws.String("value")},
+		}},
+	}
+
+	enc, err := json.Marshal(toExportItem(item))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decoded map[string]*ExportAttributeValue
+
+	err = json.Unmarshal(enc, &decoded)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	round_trip := fromExportItem(decoded)
+
+	if !reflect.DeepEqual(round_trip, item) {
+		t.Fatalf("Expected round trip to be lossless, got %s", enc)
+	}
+}
+
+func TestExportAttributeValueNil(t *testing.T) {
+
+	if toExportAttributeValue(nil) != nil {
+		t.Fatal("Expected nil")
+	}
+
+	if fromExportAttributeValue(nil) != nil {
+		t.Fatal("Expected nil")
+	}
+}
+
+func TestImportCollisions(t *testing.T) {
+
+	// Indexes without tables are only checked against the items imported before them
+
+	c := newImportCollisions(nil, []*importIndex{
+		{name: "email"},
+		{name: "url"},
+	})
+
+	tests := []struct {
+		id       string
+		email    string
+		url      string
+		collides bool
+	}{
+		{"1", "bob@example.com", "bob", false},
+		{"1", "bob@example.com", "bob", false},
+		{"2", "bob@example.com", "robert", true},
+		{"3", "robert@example.com", "bob", true},
+		{"4", "robert@example.com", "robert", false},
+		{"5", "", "", false},
+	}
+
+	for _, test := range tests {
+
+		item := map[string]*aws_dynamodb.AttributeValue{
+			"id":    {N: aws.String(test.id)},
+			"email": {S: aws.String(test.email)},
+			"url":   {S: aws.String(test.url)},
+		}
+
+		reason, err := c.check(item)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (reason != "") != test.collides {
+			t.Fatalf("Expected item %s (%s, %s) collision to be %t, got '%s'", test.id, test.email, test.url, test.collides, reason)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package dynamodb
+
+import (
+	"flag"
+	"fmt"
+	"strconv"
+)
+
+// AppendAccountsFlags adds the flags used to configure an accounts database, and which must
+// be the same for every tool that reads or writes a given accounts table, to fs. Once fs has
+// been parsed use AccountsOptionsFromFlags to create the corresponding options.
+func AppendAccountsFlags(fs *flag.FlagSet) {
+
+	fs.String("accounts-table", ACCOUNTS_DEFAULT_TABLENAME, "The name of the accounts table.")
+	fs.String("key-provider", "", "A URI for the key provider used to encrypt and decrypt sensitive account fields, for example file:///path/to/keys or kms://{KEY_ID}?dsn={AWS_DSN}.")
+	fs.String("email-index-key", "", "The path to a file containing the base64-encoded key used to derive the email blind index, if one is in use.")
+	fs.Bool("canonicalize-email", false, "Canonicalize provider-specific email address variations (for example Gmail dots and '+tag' suffixes) when matching accounts.")
+	fs.Int("account-schema", ACCOUNT_SCHEMA_NESTED, "The item format to write accounts in. Valid options are: 1 (nested) or 2 (flat). Accounts in either format can always be read.")
+}
+
+// AccountsOptionsFromFlags returns DefaultDynamoDBAccountsDatabaseOptions updated with the
+// values of the flags added by AppendAccountsFlags.
+func AccountsOptionsFromFlags(fs *flag.FlagSet) (*DynamoDBAccountsDatabaseOptions, error) {
+
+	opts := DefaultDynamoDBAccountsDatabaseOptions()
+
+	table, err := lookupStringFlag(fs, "accounts-table")
+
+	if err != nil {
+		return nil, err
+	}
+
+	schema, err := lookupIntFlag(fs, "account-schema")
+
+	if err != nil {
+		return nil, err
+	}
+
+	opts.TableName = table
+	opts.Schema = schema
+
+	key_provider_uri, err := lookupStringFlag(fs, "key-provider")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if key_provider_uri != "" {
+
+		key_provider, err := NewKeyProviderFromURI(key_provider_uri)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.KeyProvider = key_provider
+	}
+
+	email_index_key_path, err := lookupStringFlag(fs, "email-index-key")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if email_index_key_path != "" {
+
+		email_index_key, err := ReadEmailBlindIndexKey(email_index_key_path)
+
+		if err != nil {
+			return nil, err
+		}
+
+		opts.EmailBlindIndexKey = email_index_key
+	}
+
+	canonicalize_email, err := lookupBoolFlag(fs, "canonicalize-email")
+
+	if err != nil {
+		return nil, err
+	}
+
+	if canonicalize_email {
+		opts.EmailCanonicalizer = DefaultProviderEmailCanonicalizer()
+	}
+
+	return opts, nil
+}
+
+func lookupStringFlag(fs *flag.FlagSet, name string) (string, error) {
+
+	fl := fs.Lookup(name)
+
+	if fl == nil {
+		return "", fmt.Errorf("Missing -%s flag", name)
+	}
+
+	return fl.Value.String(), nil
+}
+
+func lookupIntFlag(fs *flag.FlagSet, name string) (int, error) {
+
+	str_value, err := lookupStringFlag(fs, name)
+
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.Atoi(str_value)
+}
+
+func lookupBoolFlag(fs *flag.FlagSet, name string) (bool, error) {
+
+	str_value, err := lookupStringFlag(fs, name)
+
+	if err != nil {
+		return false, err
+	}
+
+	return strconv.ParseBool(str_value)
+}
@@ -5,6 +5,9 @@ require (
 	github.com/aaronland/go-aws-session v0.0.2
 	github.com/aaronland/go-password v0.0.2
 	github.com/aws/aws-sdk-go v1.20.7
+	github.com/boombuler/barcode v1.0.1-0.20190219062509-6c824513bacc
+	github.com/pquerna/otp v1.2.0
+	golang.org/x/text v0.3.0
 )
 
 go 1.12
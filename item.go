@@ -0,0 +1,241 @@
+package dynamodb
+
+import (
+	"context"
+	"fmt"
+	aws "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// updateItem writes every attribute in item, other than the key attribute, to the item
+// identified by key_name and removes the attributes in remove. Unlike PutItem any other
+// attributes stored alongside them (for example login attempts or MFA state) are left
+// untouched.
+func updateItem(client *aws_dynamodb.DynamoDB, table string, key_name string, item map[string]*aws_dynamodb.AttributeValue, remove []string) error {
+
+	req, err := newUpdateItemInput(table, key_name, item, remove)
+
+	if err != nil {
+		return err
+	}
+
+	_, err = client.UpdateItem(req)
+	return err
+}
+
+// newUpdateItemInput returns the UpdateItem request used by updateItem so that callers can
+// add conditions, or use it as part of a transaction.
+func newUpdateItemInput(table string, key_name string, item map[string]*aws_dynamodb.AttributeValue, remove []string) (*aws_dynamodb.UpdateItemInput, error) {
+
+	key, ok := item[key_name]
+
+	if !ok {
+		return nil, fmt.Errorf("Item is missing '%s' attribute", key_name)
+	}
+
+	names := make([]string, 0)
+
+	for name := range item {
+
+		if name == key_name {
+			continue
+		}
+
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	attr_names := make(map[string]*string)
+	attr_values := make(map[string]*aws_dynamodb.AttributeValue)
+
+	set := make([]string, 0)
+
+	for i, name := range names {
+
+		k := fmt.Sprintf("#a%d", i)
+		v := fmt.Sprintf(":a%d", i)
+
+		attr_names[k] = aws.String(name)
+		attr_values[v] = item[name]
+
+		set = append(set, fmt.Sprintf("%s = %s", k, v))
+	}
+
+	unset := make([]string, 0)
+
+	for i, name := range remove {
+
+		if name == key_name {
+			continue
+		}
+
+		_, ok := item[name]
+
+		if ok {
+			continue
+		}
+
+		k := fmt.Sprintf("#r%d", i)
+		attr_names[k] = aws.String(name)
+
+		unset = append(unset, k)
+	}
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			key_name: key,
+		},
+	}
+
+	clauses := make([]string, 0)
+
+	if len(set) > 0 {
+		clauses = append(clauses, "SET "+strings.Join(set, ", "))
+		req.ExpressionAttributeValues = attr_values
+	}
+
+	if len(unset) > 0 {
+		clauses = append(clauses, "REMOVE "+strings.Join(unset, ", "))
+	}
+
+	if len(clauses) > 0 {
+		req.UpdateExpression = aws.String(strings.Join(clauses, " "))
+		req.ExpressionAttributeNames = attr_names
+	}
+
+	return req, nil
+}
+
+// preImageCondition returns a condition that is only true if each of the attributes in
+// names still has the value it has in item, or is still absent if it is absent from item.
+// The placeholders it uses are added to attr_names and attr_values.
+func preImageCondition(item map[string]*aws_dynamodb.AttributeValue, names []string, attr_names map[string]*string, attr_values map[string]*aws_dynamodb.AttributeValue) string {
+
+	conditions := make([]string, 0)
+
+	for i, name := range names {
+
+		k := fmt.Sprintf("#p%d", i)
+		attr_names[k] = aws.String(name)
+
+		v, ok := item[name]
+
+		if !ok {
+			conditions = append(conditions, fmt.Sprintf("attribute_not_exists(%s)", k))
+			continue
+		}
+
+		p := fmt.Sprintf(":p%d", i)
+		attr_values[p] = v
+
+		conditions = append(conditions, fmt.Sprintf("%s = %s", k, p))
+	}
+
+	return strings.Join(conditions, " AND ")
+}
+
+func idKey(id int64) map[string]*aws_dynamodb.AttributeValue {
+
+	str_id := strconv.FormatInt(id, 10)
+
+	key := map[string]*aws_dynamodb.AttributeValue{
+		"id": {
+			N: aws.String(str_id),
+		},
+	}
+
+	return key
+}
+
+func isConditionalCheckFailed(err error) bool {
+
+	aws_err, ok := err.(awserr.Error)
+
+	if !ok {
+		return false
+	}
+
+	return aws_err.Code() == aws_dynamodb.ErrCodeConditionalCheckFailedException
+}
+
+// isIndexUnavailable reports whether err was returned because a query used a global
+// secondary index that does not exist or is still being created (backfilled).
+func isIndexUnavailable(err error) bool {
+
+	aws_err, ok := err.(awserr.Error)
+
+	if !ok {
+		return false
+	}
+
+	if aws_err.Code() != "ValidationException" {
+		return false
+	}
+
+	msg := strings.ToLower(aws_err.Message())
+
+	return strings.Contains(msg, "specified index") || strings.Contains(msg, "backfilling")
+}
+
+// scanPages calls cb with each page of results for req, starting from its ExclusiveStartKey,
+// until the table has been read or ctx is cancelled.
+func scanPages(ctx context.Context, client *aws_dynamodb.DynamoDB, req *aws_dynamodb.ScanInput, cb func(*aws_dynamodb.ScanOutput) error) error {
+
+	for {
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+			// pass
+		}
+
+		rsp, err := client.Scan(req)
+
+		if err != nil {
+			return err
+		}
+
+		err = cb(rsp)
+
+		if err != nil {
+			return err
+		}
+
+		if rsp.LastEvaluatedKey == nil {
+			break
+		}
+
+		req.ExclusiveStartKey = rsp.LastEvaluatedKey
+	}
+
+	return nil
+}
+
+// scanItems calls cb with every item in table.
+func scanItems(ctx context.Context, client *aws_dynamodb.DynamoDB, table string, cb func(map[string]*aws_dynamodb.AttributeValue) error) error {
+
+	req := &aws_dynamodb.ScanInput{
+		TableName: aws.String(table),
+	}
+
+	return scanPages(ctx, client, req, func(rsp *aws_dynamodb.ScanOutput) error {
+
+		for _, item := range rsp.Items {
+
+			err := cb(item)
+
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,359 @@
+package dynamodb
+
+import (
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-auth/database"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+	"time"
+)
+
+var ErrAccountLocked = errors.New("Account is locked")
+
+type LoginAttempts struct {
+	Failed      int64
+	FirstFailed int64
+	LastFailed  int64
+	LockedUntil int64
+}
+
+// RecordFailedLogin atomically increments the number of failed login attempts (password
+// or TOTP) for acct, starting a new count if the current one is older than the lockout
+// window. It returns true if the account is now locked.
+func (db *DynamoDBAccountsDatabase) RecordFailedLogin(acct *account.Account) (bool, error) {
+
+	// Accounts that have not been copied from the fallback table yet can not be updated
+
+	err := db.copyAccountForward(acct.ID)
+
+	if err != nil {
+		return false, err
+	}
+
+	now := time.Now()
+
+	window_start := now.Add(-db.options.LockoutWindow)
+
+	str_now := strconv.FormatInt(now.Unix(), 10)
+	str_start := strconv.FormatInt(window_start.Unix(), 10)
+
+	var attrs map[string]*aws_dynamodb.AttributeValue
+
+	for attempt := 0; attempt < 3; attempt++ {
+
+		// First try to add to an existing count that is still inside the window
+
+		req := &aws_dynamodb.UpdateItemInput{
+			TableName:           aws.String(db.options.TableName),
+			Key:                 idKey(acct.ID),
+			UpdateExpression:    aws.String("ADD #failed :one SET #last = :now, #first = if_not_exists(#first, :now)"),
+			ConditionExpression: aws.String("attribute_exists(#id) AND (attribute_not_exists(#first) OR #first > :start)"),
+			ExpressionAttributeNames: map[string]*string{
+				"#id":     aws.String("id"),
+				"#failed": aws.String("failed_logins"),
+				"#first":  aws.String("failed_login_first"),
+				"#last":   aws.String("failed_login_last"),
+			},
+			ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+				":one":   {N: aws.String("1")},
+				":now":   {N: aws.String(str_now)},
+				":start": {N: aws.String(str_start)},
+			},
+			ReturnValues: aws.String(aws_dynamodb.ReturnValueAllNew),
+		}
+
+		rsp, err := db.client.UpdateItem(req)
+
+		if err == nil {
+			attrs = rsp.Attributes
+			break
+		}
+
+		if !isConditionalCheckFailed(err) {
+			return false, err
+		}
+
+		// Otherwise the existing count has expired so start a new one
+
+		req = &aws_dynamodb.UpdateItemInput{
+			TableName:           aws.String(db.options.TableName),
+			Key:                 idKey(acct.ID),
+			UpdateExpression:    aws.String("SET #failed = :one, #first = :now, #last = :now"),
+			ConditionExpression: aws.String("attribute_exists(#id) AND #first <= :start"),
+			ExpressionAttributeNames: map[string]*string{
+				"#id":     aws.String("id"),
+				"#failed": aws.String("failed_logins"),
+				"#first":  aws.String("failed_login_first"),
+				"#last":   aws.String("failed_login_last"),
+			},
+			ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+				":one":   {N: aws.String("1")},
+				":now":   {N: aws.String(str_now)},
+				":start": {N: aws.String(str_start)},
+			},
+			ReturnValues: aws.String(aws_dynamodb.ReturnValueAllNew),
+		}
+
+		rsp, err = db.client.UpdateItem(req)
+
+		if err == nil {
+			attrs = rsp.Attributes
+			break
+		}
+
+		if !isConditionalCheckFailed(err) {
+			return false, err
+		}
+	}
+
+	if attrs == nil {
+		return false, new(database.ErrNoAccount)
+	}
+
+	attempts, err := itemToLoginAttempts(attrs)
+
+	if err != nil {
+		return false, err
+	}
+
+	audit_attrs := map[string]interface{}{
+		"failed_logins": attempts.Failed,
+	}
+
+	if !lockoutThresholdReached(db.options.LockoutThreshold, attempts) {
+		db.auditAttributes(AUDIT_ACTION_LOGIN_FAILED, acct.ID, nil, nil, audit_attrs)
+		return false, nil
+	}
+
+	req := newLockUpdate(db.options.TableName, acct.ID, now, now.Add(db.options.LockoutDuration))
+
+	_, err = db.client.UpdateItem(req)
+
+	if err != nil && !isConditionalCheckFailed(err) {
+		return false, err
+	}
+
+	if err == nil {
+		audit_attrs["locked_until"] = now.Add(db.options.LockoutDuration).Unix()
+	}
+
+	db.auditAttributes(AUDIT_ACTION_LOGIN_FAILED, acct.ID, nil, nil, audit_attrs)
+	return true, nil
+}
+
+// lockoutThresholdReached reports whether attempts should lock an account. A threshold
+// less than 1 disables lockouts.
+func lockoutThresholdReached(threshold int, attempts *LoginAttempts) bool {
+	return threshold >= 1 && attempts.Failed >= int64(threshold)
+}
+
+// newLockUpdate returns the request to lock the account with ID id until until. The lock
+// is only set if the account exists and is not already locked, so that failed attempts
+// made while an account is locked do not extend the lock indefinitely.
+func newLockUpdate(table string, id int64, now time.Time, until time.Time) *aws_dynamodb.UpdateItemInput {
+
+	str_now := strconv.FormatInt(now.Unix(), 10)
+	str_until := strconv.FormatInt(until.Unix(), 10)
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(table),
+		Key:                 idKey(id),
+		UpdateExpression:    aws.String("SET #locked = :until"),
+		ConditionExpression: aws.String("attribute_exists(#id) AND (attribute_not_exists(#locked) OR #locked < :now)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":     aws.String("id"),
+			"#locked": aws.String("locked_until"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":now":   {N: aws.String(str_now)},
+			":until": {N: aws.String(str_until)},
+		},
+	}
+
+	return req
+}
+
+// RecordSuccessfulLogin clears any failed login attempts for acct. It does not unlock
+// a locked account so callers should check IsLocked before accepting a login.
+func (db *DynamoDBAccountsDatabase) RecordSuccessfulLogin(acct *account.Account) error {
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Key:                 idKey(acct.ID),
+		UpdateExpression:    aws.String("REMOVE #failed, #first, #last"),
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":     aws.String("id"),
+			"#failed": aws.String("failed_logins"),
+			"#first":  aws.String("failed_login_first"),
+			"#last":   aws.String("failed_login_last"),
+		},
+	}
+
+	_, err := db.client.UpdateItem(req)
+
+	if isConditionalCheckFailed(err) {
+		return new(database.ErrNoAccount)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	db.auditAttributes(AUDIT_ACTION_LOGIN_SUCCEEDED, acct.ID, nil, nil, clearedLoginAttempts())
+	return nil
+}
+
+// IsLocked reports whether acct is currently locked. Locks whose duration has elapsed
+// are cleared, along with the failed login attempts that caused them.
+func (db *DynamoDBAccountsDatabase) IsLocked(acct *account.Account) (bool, error) {
+
+	attempts, err := db.GetLoginAttempts(acct)
+
+	if err != nil {
+		return false, err
+	}
+
+	if attempts.LockedUntil == 0 {
+		return false, nil
+	}
+
+	now := time.Now()
+
+	if attempts.LockedUntil > now.Unix() {
+		return true, nil
+	}
+
+	str_until := strconv.FormatInt(attempts.LockedUntil, 10)
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Key:                 idKey(acct.ID),
+		UpdateExpression:    aws.String("REMOVE #locked, #failed, #first, #last"),
+		ConditionExpression: aws.String("#locked = :until"),
+		ExpressionAttributeNames: map[string]*string{
+			"#locked": aws.String("locked_until"),
+			"#failed": aws.String("failed_logins"),
+			"#first":  aws.String("failed_login_first"),
+			"#last":   aws.String("failed_login_last"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":until": {N: aws.String(str_until)},
+		},
+	}
+
+	_, err = db.client.UpdateItem(req)
+
+	if err != nil && !isConditionalCheckFailed(err) {
+		return false, err
+	}
+
+	if err == nil {
+		db.auditAttributes(AUDIT_ACTION_UNLOCK, acct.ID, nil, nil, clearedLoginAttempts())
+	}
+
+	return false, nil
+}
+
+// UnlockAccount removes any lock and failed login attempts for acct.
+func (db *DynamoDBAccountsDatabase) UnlockAccount(acct *account.Account) error {
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Key:                 idKey(acct.ID),
+		UpdateExpression:    aws.String("REMOVE #locked, #failed, #first, #last"),
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":     aws.String("id"),
+			"#locked": aws.String("locked_until"),
+			"#failed": aws.String("failed_logins"),
+			"#first":  aws.String("failed_login_first"),
+			"#last":   aws.String("failed_login_last"),
+		},
+	}
+
+	_, err := db.client.UpdateItem(req)
+
+	if isConditionalCheckFailed(err) {
+		return new(database.ErrNoAccount)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	db.auditAttributes(AUDIT_ACTION_UNLOCK, acct.ID, nil, nil, clearedLoginAttempts())
+	return nil
+}
+
+// clearedLoginAttempts returns the attributes recorded in the audit log when an account's
+// failed login attempts, and any lock, are removed.
+func clearedLoginAttempts() map[string]interface{} {
+
+	return map[string]interface{}{
+		"failed_logins": nil,
+		"locked_until":  nil,
+	}
+}
+
+func (db *DynamoDBAccountsDatabase) GetLoginAttempts(acct *account.Account) (*LoginAttempts, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:            aws.String(db.options.TableName),
+		Key:                  idKey(acct.ID),
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("#id, #failed, #first, #last, #locked"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":     aws.String("id"),
+			"#locked": aws.String("locked_until"),
+			"#failed": aws.String("failed_logins"),
+			"#first":  aws.String("failed_login_first"),
+			"#last":   aws.String("failed_login_last"),
+		},
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rsp.Item) == 0 {
+		return nil, new(database.ErrNoAccount)
+	}
+
+	return itemToLoginAttempts(rsp.Item)
+}
+
+func itemToLoginAttempts(item map[string]*aws_dynamodb.AttributeValue) (*LoginAttempts, error) {
+
+	attempts := LoginAttempts{}
+
+	fields := map[string]*int64{
+		"failed_logins":      &attempts.Failed,
+		"failed_login_first": &attempts.FirstFailed,
+		"failed_login_last":  &attempts.LastFailed,
+		"locked_until":       &attempts.LockedUntil,
+	}
+
+	for name, ptr := range fields {
+
+		v, ok := item[name]
+
+		if !ok || v.N == nil {
+			continue
+		}
+
+		i, err := strconv.ParseInt(*v.N, 10, 64)
+
+		if err != nil {
+			return nil, err
+		}
+
+		*ptr = i
+	}
+
+	return &attempts, nil
+}
@@ -0,0 +1,92 @@
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockoutThresholdReached(t *testing.T) {
+
+	tests := []struct {
+		threshold int
+		failed    int64
+		locked    bool
+	}{
+		{0, 100, false},
+		{-1, 100, false},
+		{5, 4, false},
+		{5, 5, true},
+		{5, 6, true},
+	}
+
+	for _, test := range tests {
+
+		attempts := &LoginAttempts{
+			Failed: test.failed,
+		}
+
+		if lockoutThresholdReached(test.threshold, attempts) != test.locked {
+			t.Fatalf("Expected %d failed logins with a threshold of %d to return %t", test.failed, test.threshold, test.locked)
+		}
+	}
+}
+
+func TestNewLockUpdate(t *testing.T) {
+
+	now := time.Unix(1000, 0)
+	until := now.Add(15 * time.Minute)
+
+	req := newLockUpdate("accounts", 1234, now, until)
+
+	cond := aws.StringValue(req.ConditionExpression)
+
+	// Failed attempts made while an account is locked must not extend the lock, and a
+	// removed account must not be recreated
+
+	if !strings.Contains(cond, "attribute_exists(#id)") {
+		t.Fatalf("Expected the lock to require an existing account, got '%s'", cond)
+	}
+
+	if !strings.Contains(cond, "#locked < :now") || strings.Contains(cond, "#locked < :until") {
+		t.Fatalf("Expected the lock to only replace an expired lock, got '%s'", cond)
+	}
+
+	if aws.StringValue(req.ExpressionAttributeValues[":now"].N) != "1000" {
+		t.Fatalf("Unexpected value for :now, %v", req.ExpressionAttributeValues[":now"])
+	}
+
+	if aws.StringValue(req.ExpressionAttributeValues[":until"].N) != "1900" {
+		t.Fatalf("Unexpected value for :until, %v", req.ExpressionAttributeValues[":until"])
+	}
+}
+
+func TestItemToLoginAttempts(t *testing.T) {
+
+	item := map[string]*aws_dynamodb.AttributeValue{
+		"id":                 {N: aws.String("1234")},
+		"failed_logins":      {N: aws.String("3")},
+		"failed_login_first": {N: aws.String("100")},
+		"failed_login_last":  {N: aws.String("200")},
+	}
+
+	attempts, err := itemToLoginAttempts(item)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if attempts.Failed != 3 || attempts.FirstFailed != 100 || attempts.LastFailed != 200 || attempts.LockedUntil != 0 {
+		t.Fatalf("Unexpected login attempts %v", attempts)
+	}
+
+	item["locked_until"] = &aws_dynamodb.AttributeValue{N: aws.String("invalid")}
+
+	_, err = itemToLoginAttempts(item)
+
+	if err == nil {
+		t.Fatal("Expected an invalid locked_until to fail")
+	}
+}
@@ -0,0 +1,180 @@
+package dynamodb
+
+import (
+	"crypto/subtle"
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"strconv"
+	"time"
+)
+
+// The number of seconds in each TOTP time-step.
+const TOTP_PERIOD int64 = 30
+
+var ErrMFANotConfigured = errors.New("MFA not configured")
+
+var ErrMFACodeReused = errors.New("MFA code has already been used")
+
+// VerifyMFACode returns true if code is a valid TOTP code for acct's MFA secret whose
+// time-step is newer than the last accepted one. The accepted time-step is recorded
+// using a conditional update so a code can only ever be consumed once, even by
+// concurrent logins. A valid code that has already been used returns ErrMFACodeReused.
+func (db *DynamoDBAccountsDatabase) VerifyMFACode(acct *account.Account, code string) (bool, error) {
+
+	if acct.MFA == nil {
+		return false, ErrMFANotConfigured
+	}
+
+	// Accounts that have not been copied from the fallback table yet can not be updated
+
+	err := db.copyAccountForward(acct.ID)
+
+	if err != nil {
+		return false, err
+	}
+
+	secret, err := acct.GetMFASecret()
+
+	if err != nil {
+		return false, err
+	}
+
+	step, ok, err := matchTOTPStep(secret, code, time.Now(), db.options.MFASkew)
+
+	if err != nil {
+		return false, err
+	}
+
+	if !ok {
+		return false, nil
+	}
+
+	str_step := strconv.FormatInt(step, 10)
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Key:                 idKey(acct.ID),
+		UpdateExpression:    aws.String("SET #step = :step"),
+		ConditionExpression: aws.String("attribute_exists(#id) AND (attribute_not_exists(#step) OR #step < :step)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":   aws.String("id"),
+			"#step": aws.String("mfa_last_step"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":step": {N: aws.String(str_step)},
+		},
+	}
+
+	_, err = db.client.UpdateItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+			return false, ErrMFACodeReused
+		}
+
+		return false, err
+	}
+
+	db.auditMFAStep(acct, step)
+	return true, nil
+}
+
+// matchTOTPStep returns the time-step, within skew steps either side of t, for which code
+// is the valid TOTP code for secret.
+func matchTOTPStep(secret string, code string, t time.Time, skew uint) (int64, bool, error) {
+
+	opts := totp.ValidateOpts{
+		Period:    uint(TOTP_PERIOD),
+		Skew:      0,
+		Digits:    otp.DigitsSix,
+		Algorithm: otp.AlgorithmSHA1,
+	}
+
+	current := t.Unix() / TOTP_PERIOD
+
+	// Check the newest steps first so that a code which is valid for more than one
+	// step (it happens) is recorded against the most recent of them.
+
+	for offset := int64(skew); offset >= -int64(skew); offset-- {
+
+		step := current + offset
+
+		expected, err := totp.GenerateCodeCustom(secret, time.Unix(step*TOTP_PERIOD, 0), opts)
+
+		if err != nil {
+			return 0, false, err
+		}
+
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return step, true, nil
+		}
+	}
+
+	return 0, false, nil
+}
+
+// ResetMFA replaces acct's MFA secret with secret, but only if code is a valid TOTP
+// code for the new secret, confirming that it has been enrolled successfully.
+func (db *DynamoDBAccountsDatabase) ResetMFA(acct *account.Account, secret string, code string) (*account.Account, error) {
+
+	step, ok, err := matchTOTPStep(secret, code, time.Now(), db.options.MFASkew)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, errors.New("Invalid MFA code")
+	}
+
+	if acct.MFA == nil {
+		acct.MFA = new(account.MFA)
+	}
+
+	acct.MFA.Secret = secret
+
+	acct, err = db.UpdateAccount(acct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Record the confirmation code as used so it can not be replayed
+
+	str_step := strconv.FormatInt(step, 10)
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:        aws.String(db.options.TableName),
+		Key:              idKey(acct.ID),
+		UpdateExpression: aws.String("SET #step = :step"),
+		ExpressionAttributeNames: map[string]*string{
+			"#step": aws.String("mfa_last_step"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":step": {N: aws.String(str_step)},
+		},
+	}
+
+	_, err = db.client.UpdateItem(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	db.auditMFAStep(acct, step)
+	return acct, nil
+}
+
+func (db *DynamoDBAccountsDatabase) auditMFAStep(acct *account.Account, step int64) {
+
+	attrs := map[string]interface{}{
+		"mfa_last_step": step,
+	}
+
+	db.auditAttributes(AUDIT_ACTION_MFA_VERIFY, acct.ID, nil, nil, attrs)
+}
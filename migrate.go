@@ -0,0 +1,509 @@
+package dynamodb
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// SCHEMA_VERSION_ATTRIBUTE is the name of the attribute recording the format of an item.
+const SCHEMA_VERSION_ATTRIBUTE string = "schema_version"
+
+const SCHEMA_TARGET_ACCOUNTS string = "accounts"
+
+const SCHEMA_TARGET_TOKENS string = "tokens"
+
+// TOKEN_SCHEMA_VERSION is the schema version of access token items written by this package.
+const TOKEN_SCHEMA_VERSION int = 1
+
+var ErrMigrationConflict = errors.New("Item was modified during migration")
+
+// MigrationStep transforms an item from one schema version to the next. Apply is passed a
+// copy of the item and should return the item to write; it does not need to set the
+// schema_version attribute. Steps must only depend on the item they are given, so that
+// re-running a migration is always safe.
+type MigrationStep struct {
+	Target      string
+	From        int
+	To          int
+	Description string
+	Apply       func(map[string]*aws_dynamodb.AttributeValue) (map[string]*aws_dynamodb.AttributeValue, error)
+}
+
+var migration_steps = []*MigrationStep{
+	{
+		Target:      SCHEMA_TARGET_ACCOUNTS,
+		From:        ACCOUNT_SCHEMA_NESTED,
+		To:          ACCOUNT_SCHEMA_FLAT,
+		Description: "Flatten the nested account map in to top-level attributes",
+		Apply:       migrateNestedAccountToFlat,
+	},
+}
+
+var migration_steps_mu = new(sync.RWMutex)
+
+// RegisterMigrationStep adds step to the registry of migrations used by MigrateTable. Only
+// one step may be registered for each target and starting version.
+func RegisterMigrationStep(step *MigrationStep) error {
+
+	if step.To <= step.From {
+		return errors.New("Migration steps must increase the schema version")
+	}
+
+	if step.Apply == nil {
+		return errors.New("Migration step is missing an Apply function")
+	}
+
+	migration_steps_mu.Lock()
+	defer migration_steps_mu.Unlock()
+
+	for _, s := range migration_steps {
+
+		if s.Target == step.Target && s.From == step.From {
+			return fmt.Errorf("A migration step from version %d is already registered for %s", step.From, step.Target)
+		}
+	}
+
+	migration_steps = append(migration_steps, step)
+	return nil
+}
+
+// MigrationSteps returns the registered steps for target, in order of their starting version.
+func MigrationSteps(target string) []*MigrationStep {
+
+	migration_steps_mu.RLock()
+	defer migration_steps_mu.RUnlock()
+
+	steps := make([]*MigrationStep, 0)
+
+	for _, s := range migration_steps {
+
+		if s.Target == target {
+			steps = append(steps, s)
+		}
+	}
+
+	sort.Slice(steps, func(i, j int) bool { return steps[i].From < steps[j].From })
+
+	return steps
+}
+
+// LatestSchemaVersion returns the highest schema version that items for target can be
+// migrated to.
+func LatestSchemaVersion(target string) int {
+
+	latest := 1
+
+	for _, s := range MigrationSteps(target) {
+
+		if s.To > latest {
+			latest = s.To
+		}
+	}
+
+	return latest
+}
+
+// MigrationOptions controls a call to MigrateTable. Target is the schema version to migrate
+// items to; if 0 accounts are migrated to the database's configured Schema, so that the
+// migrated items match what it writes, and other items to the latest version. Checkpoint is
+// the value reported by a previous (interrupted) run to resume from and Callback, if set,
+// is called after each page of items with the results so far, whose Checkpoint can be saved.
+type MigrationOptions struct {
+	Target   int
+	DryRun   bool
+	PageSize int64
+	Resume   string
+	Callback func(*MigrationResult) error
+}
+
+// MigrationResult summarizes a migration. Migrated counts items transformed by one or more
+// steps (or that would be, for a dry run) and Stamped counts items that were already in the
+// target format but did not record a schema version.
+type MigrationResult struct {
+	Scanned    int64          `json:"scanned"`
+	Migrated   int64          `json:"migrated"`
+	Stamped    int64          `json:"stamped"`
+	Skipped    int64          `json:"skipped"`
+	Steps      map[string]int `json:"steps"`
+	Checkpoint string         `json:"checkpoint"`
+}
+
+// Migrate applies the registered account migration steps to every item in the accounts table.
+func (db *DynamoDBAccountsDatabase) Migrate(ctx context.Context, opts *MigrationOptions) (*MigrationResult, error) {
+
+	if opts.Target == 0 {
+
+		copy := *opts
+		copy.Target = db.options.Schema
+
+		opts = &copy
+	}
+
+	return migrateTable(ctx, db.client, db.options.TableName, SCHEMA_TARGET_ACCOUNTS, opts)
+}
+
+// Migrate applies the registered access token migration steps to every item in the access tokens table.
+func (db *DynamoDBAccessTokensDatabase) Migrate(ctx context.Context, opts *MigrationOptions) (*MigrationResult, error) {
+	return migrateTable(ctx, db.client, db.options.TableName, SCHEMA_TARGET_TOKENS, opts)
+}
+
+func migrateTable(ctx context.Context, client *aws_dynamodb.DynamoDB, table string, target string, opts *MigrationOptions) (*MigrationResult, error) {
+
+	version := opts.Target
+
+	if version == 0 {
+		version = LatestSchemaVersion(target)
+	}
+
+	steps := MigrationSteps(target)
+
+	start_key, err := decodeCheckpoint(opts.Resume)
+
+	if err != nil {
+		return nil, err
+	}
+
+	page_size := opts.PageSize
+
+	if page_size < 1 {
+		page_size = PAGE_DEFAULT_SIZE
+	}
+
+	result := &MigrationResult{
+		Steps: make(map[string]int),
+	}
+
+	req := &aws_dynamodb.ScanInput{
+		TableName:         aws.String(table),
+		ExclusiveStartKey: start_key,
+		Limit:             aws.Int64(page_size),
+		ConsistentRead:    aws.Bool(true),
+	}
+
+	err = scanPages(ctx, client, req, func(rsp *aws_dynamodb.ScanOutput) error {
+
+		for _, item := range rsp.Items {
+
+			result.Scanned += 1
+
+			err := migrateItem(client, table, target, item, steps, version, opts.DryRun, result)
+
+			if err == ErrMigrationConflict {
+				result.Skipped += 1
+				continue
+			}
+
+			if err != nil {
+				return err
+			}
+		}
+
+		checkpoint, err := encodeCheckpoint(rsp.LastEvaluatedKey)
+
+		if err != nil {
+			return err
+		}
+
+		result.Checkpoint = checkpoint
+
+		if opts.Callback != nil {
+			return opts.Callback(result)
+		}
+
+		return nil
+	})
+
+	return result, err
+}
+
+// migrateItem applies steps to item until it reaches version and writes the changes back,
+// on the condition that the item still exists and that none of the attributes being
+// changed have been modified since it was read. Other attributes (for example failed
+// login attempts) are left untouched so concurrent updates to them are preserved.
+func migrateItem(client *aws_dynamodb.DynamoDB, table string, target string, item map[string]*aws_dynamodb.AttributeValue, steps []*MigrationStep, version int, dry_run bool, result *MigrationResult) error {
+
+	_, stamped := item[SCHEMA_VERSION_ATTRIBUTE]
+
+	migrated, applied, err := applyMigrationSteps(target, item, steps, version)
+
+	if err != nil {
+		return err
+	}
+
+	if len(applied) == 0 && stamped {
+		return nil
+	}
+
+	if len(applied) > 0 {
+		result.Migrated += 1
+	} else {
+		result.Stamped += 1
+	}
+
+	for _, s := range applied {
+		result.Steps[fmt.Sprintf("%d-%d", s.From, s.To)] += 1
+	}
+
+	if dry_run {
+		return nil
+	}
+
+	req := newMigrationUpdate(table, "id", item, migrated)
+
+	_, err = client.UpdateItem(req)
+
+	if isConditionalCheckFailed(err) {
+		return ErrMigrationConflict
+	}
+
+	return err
+}
+
+// newMigrationUpdate returns the request that changes item into migrated. Only attributes
+// whose values differ are set or removed and the update is conditional on each of them
+// still having the value it had in item, which is the pre-image, and on the item existing.
+func newMigrationUpdate(table string, key_name string, item map[string]*aws_dynamodb.AttributeValue, migrated map[string]*aws_dynamodb.AttributeValue) *aws_dynamodb.UpdateItemInput {
+
+	names := make([]string, 0)
+	seen := make(map[string]bool)
+
+	for _, attrs := range []map[string]*aws_dynamodb.AttributeValue{item, migrated} {
+
+		for name := range attrs {
+
+			if name == key_name || seen[name] {
+				continue
+			}
+
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+
+	attr_names := map[string]*string{
+		"#key": aws.String(key_name),
+	}
+
+	attr_values := make(map[string]*aws_dynamodb.AttributeValue)
+
+	set := make([]string, 0)
+	remove := make([]string, 0)
+
+	conditions := []string{
+		"attribute_exists(#key)",
+	}
+
+	for i, name := range names {
+
+		old_v, had := item[name]
+		new_v, has := migrated[name]
+
+		if had && has && reflect.DeepEqual(old_v, new_v) {
+			continue
+		}
+
+		k := fmt.Sprintf("#a%d", i)
+		attr_names[k] = aws.String(name)
+
+		if has {
+			v := fmt.Sprintf(":n%d", i)
+			attr_values[v] = new_v
+			set = append(set, fmt.Sprintf("%s = %s", k, v))
+		} else {
+			remove = append(remove, k)
+		}
+
+		if had {
+			v := fmt.Sprintf(":o%d", i)
+			attr_values[v] = old_v
+			conditions = append(conditions, fmt.Sprintf("%s = %s", k, v))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("attribute_not_exists(%s)", k))
+		}
+	}
+
+	expr := make([]string, 0)
+
+	if len(set) > 0 {
+		expr = append(expr, "SET "+strings.Join(set, ", "))
+	}
+
+	if len(remove) > 0 {
+		expr = append(expr, "REMOVE "+strings.Join(remove, ", "))
+	}
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			key_name: item[key_name],
+		},
+		UpdateExpression:         aws.String(strings.Join(expr, " ")),
+		ConditionExpression:      aws.String(strings.Join(conditions, " AND ")),
+		ExpressionAttributeNames: attr_names,
+	}
+
+	if len(attr_values) > 0 {
+		req.ExpressionAttributeValues = attr_values
+	}
+
+	return req
+}
+
+// applyMigrationSteps returns a copy of item transformed by steps to version, with its
+// schema_version attribute set, and the steps that were applied.
+func applyMigrationSteps(target string, item map[string]*aws_dynamodb.AttributeValue, steps []*MigrationStep, version int) (map[string]*aws_dynamodb.AttributeValue, []*MigrationStep, error) {
+
+	current := itemSchemaVersion(target, item)
+
+	if current > version {
+		return nil, nil, fmt.Errorf("Item has schema version %d which is newer than %d", current, version)
+	}
+
+	migrated := copyItem(item)
+	applied := make([]*MigrationStep, 0)
+
+	for _, s := range steps {
+
+		if s.From != current || s.To > version {
+			continue
+		}
+
+		next, err := s.Apply(copyItem(migrated))
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Failed to apply migration from version %d to %d, %s", s.From, s.To, err)
+		}
+
+		migrated = next
+		current = s.To
+
+		applied = append(applied, s)
+	}
+
+	if current != version {
+		return nil, nil, fmt.Errorf("No migration path from version %d to %d", current, version)
+	}
+
+	migrated[SCHEMA_VERSION_ATTRIBUTE] = schemaVersionAttribute(version)
+
+	return migrated, applied, nil
+}
+
+// itemSchemaVersion returns the schema version recorded in item or, for items written
+// before schema versions were recorded, the version implied by its format.
+func itemSchemaVersion(target string, item map[string]*aws_dynamodb.AttributeValue) int {
+
+	v, ok := item[SCHEMA_VERSION_ATTRIBUTE]
+
+	if ok && v.N != nil {
+
+		version, err := strconv.Atoi(*v.N)
+
+		if err == nil {
+			return version
+		}
+	}
+
+	if target == SCHEMA_TARGET_ACCOUNTS && !isNestedAccountItem(item) {
+		return ACCOUNT_SCHEMA_FLAT
+	}
+
+	return 1
+}
+
+func schemaVersionAttribute(version int) *aws_dynamodb.AttributeValue {
+	return &aws_dynamodb.AttributeValue{N: aws.String(strconv.Itoa(version))}
+}
+
+func migrateNestedAccountToFlat(item map[string]*aws_dynamodb.AttributeValue) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+	dynamodb_acct, err := unmarshalAccountItem(item)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if dynamodb_acct == nil || dynamodb_acct.Account == nil {
+		return nil, errors.New("Item is not an account")
+	}
+
+	flat, err := aws_dynamodbattribute.MarshalMap(dynamodbAccountToFlatAccount(dynamodb_acct))
+
+	if err != nil {
+		return nil, err
+	}
+
+	// Keep any attributes stored alongside the account (login attempts, MFA state, etc.)
+
+	for k, v := range item {
+
+		if k == "account" {
+			continue
+		}
+
+		_, ok := flat[k]
+
+		if !ok {
+			flat[k] = v
+		}
+	}
+
+	return flat, nil
+}
+
+func copyItem(item map[string]*aws_dynamodb.AttributeValue) map[string]*aws_dynamodb.AttributeValue {
+
+	c := make(map[string]*aws_dynamodb.AttributeValue)
+
+	for k, v := range item {
+		c[k] = v
+	}
+
+	return c
+}
+
+// encodeCheckpoint returns an opaque string for resuming a scan from key. Unlike cursors
+// checkpoints are not signed since they are only ever handled by operators.
+func encodeCheckpoint(key map[string]*aws_dynamodb.AttributeValue) (string, error) {
+
+	if key == nil {
+		return "", nil
+	}
+
+	enc, err := json.Marshal(key)
+
+	if err != nil {
+		return "", err
+	}
+
+	return string(enc), nil
+}
+
+func decodeCheckpoint(checkpoint string) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+	if checkpoint == "" {
+		return nil, nil
+	}
+
+	var key map[string]*aws_dynamodb.AttributeValue
+
+	err := json.Unmarshal([]byte(checkpoint), &key)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid checkpoint, %s", err)
+	}
+
+	return key, nil
+}
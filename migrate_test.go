@@ -0,0 +1,194 @@
+package dynamodb
+
+import (
+	"errors"
+	"github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"strings"
+	"testing"
+)
+
+func testMigrationSteps() []*MigrationStep {
+
+	rename := func(from string, to string) func(map[string]*aws_dynamodb.AttributeValue) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+		return func(item map[string]*aws_dynamodb.AttributeValue) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+			v, ok := item[from]
+
+			if !ok {
+				return nil, errors.New("Missing attribute")
+			}
+
+			delete(item, from)
+			item[to] = v
+
+			return item, nil
+		}
+	}
+
+	steps := []*MigrationStep{
+		{
+			Target: SCHEMA_TARGET_TOKENS,
+			From:   1,
+			To:     2,
+			Apply:  rename("a", "b"),
+		},
+		{
+			Target: SCHEMA_TARGET_TOKENS,
+			From:   2,
+			To:     3,
+			Apply:  rename("b", "c"),
+		},
+	}
+
+	return steps
+}
+
+func TestApplyMigrationSteps(t *testing.T) {
+
+	item := map[string]*aws_dynamodb.AttributeValue{
+		"id": {N: aws.String("1234")},
+		"a":  {S: aws.String("value")},
+	}
+
+	migrated, applied, err := applyMigrationSteps(SCHEMA_TARGET_TOKENS, item, testMigrationSteps(), 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 2 {
+		t.Fatalf("Expected 2 steps to be applied, got %d", len(applied))
+	}
+
+	if _, ok := migrated["c"]; !ok {
+		t.Fatal("Expected migrated item to have attribute 'c'")
+	}
+
+	if _, ok := item["a"]; !ok {
+		t.Fatal("Expected the original item to be unchanged")
+	}
+
+	if itemSchemaVersion(SCHEMA_TARGET_TOKENS, migrated) != 3 {
+		t.Fatal("Expected migrated item to be stamped with version 3")
+	}
+
+	// Re-applying the steps to the migrated item is a no-op
+
+	_, applied, err = applyMigrationSteps(SCHEMA_TARGET_TOKENS, migrated, testMigrationSteps(), 3)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 0 {
+		t.Fatalf("Expected no steps to be applied, got %d", len(applied))
+	}
+
+	// Partial migrations stop at the requested version
+
+	partial, applied, err := applyMigrationSteps(SCHEMA_TARGET_TOKENS, item, testMigrationSteps(), 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(applied) != 1 || partial["b"] == nil {
+		t.Fatal("Expected only the first step to be applied")
+	}
+
+	_, _, err = applyMigrationSteps(SCHEMA_TARGET_TOKENS, migrated, testMigrationSteps(), 2)
+
+	if err == nil {
+		t.Fatal("Expected migrating to an older version to fail")
+	}
+
+	_, _, err = applyMigrationSteps(SCHEMA_TARGET_TOKENS, item, testMigrationSteps(), 4)
+
+	if err == nil {
+		t.Fatal("Expected migrating to an unknown version to fail")
+	}
+}
+
+func TestItemSchemaVersion(t *testing.T) {
+
+	nested := map[string]*aws_dynamodb.AttributeValue{
+		"id":      {N: aws.String("1234")},
+		"account": {M: map[string]*aws_dynamodb.AttributeValue{}},
+	}
+
+	flat := map[string]*aws_dynamodb.AttributeValue{
+		"id":          {N: aws.String("1234")},
+		"address_uri": {S: aws.String("bob@example.com")},
+	}
+
+	if itemSchemaVersion(SCHEMA_TARGET_ACCOUNTS, nested) != ACCOUNT_SCHEMA_NESTED {
+		t.Fatal("Expected unstamped nested item to be ACCOUNT_SCHEMA_NESTED")
+	}
+
+	if itemSchemaVersion(SCHEMA_TARGET_ACCOUNTS, flat) != ACCOUNT_SCHEMA_FLAT {
+		t.Fatal("Expected unstamped flat item to be ACCOUNT_SCHEMA_FLAT")
+	}
+
+	flat[SCHEMA_VERSION_ATTRIBUTE] = schemaVersionAttribute(5)
+
+	if itemSchemaVersion(SCHEMA_TARGET_ACCOUNTS, flat) != 5 {
+		t.Fatal("Expected stamped version to be used")
+	}
+}
+
+func TestNewMigrationUpdate(t *testing.T) {
+
+	item := map[string]*aws_dynamodb.AttributeValue{
+		"id":            {N: aws.String("1234")},
+		"a":             {S: aws.String("value")},
+		"failed_logins": {N: aws.String("2")},
+	}
+
+	migrated, _, err := applyMigrationSteps(SCHEMA_TARGET_TOKENS, item, testMigrationSteps(), 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req := newMigrationUpdate("tokens", "id", item, migrated)
+
+	update := *req.UpdateExpression
+	condition := *req.ConditionExpression
+
+	if !strings.Contains(condition, "attribute_exists(#key)") {
+		t.Fatalf("Expected condition to require the item to exist: %s", condition)
+	}
+
+	for k, v := range req.ExpressionAttributeNames {
+
+		switch *v {
+		case "failed_logins":
+			t.Fatal("Expected unchanged attributes not to be updated")
+		case "a":
+
+			if !strings.Contains(update, "REMOVE "+k) {
+				t.Fatalf("Expected 'a' to be removed: %s", update)
+			}
+
+			if !strings.Contains(condition, k+" = ") {
+				t.Fatalf("Expected 'a' to be conditioned on its old value: %s", condition)
+			}
+
+		case "b", SCHEMA_VERSION_ATTRIBUTE:
+
+			if !strings.Contains(update, k+" = ") {
+				t.Fatalf("Expected '%s' to be set: %s", *v, update)
+			}
+
+			if !strings.Contains(condition, "attribute_not_exists("+k+")") {
+				t.Fatalf("Expected '%s' to be conditioned on not existing: %s", *v, condition)
+			}
+		}
+	}
+
+	if *req.Key["id"].N != "1234" {
+		t.Fatal("Unexpected key")
+	}
+}
@@ -0,0 +1,262 @@
+package dynamodb
+
+import (
+	"context"
+	"github.com/aaronland/go-auth/account"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"sort"
+	"strings"
+)
+
+// EmailCanonicalizer rewrites an already trimmed and lower-cased email address in to the
+// canonical form used by its mail provider, for example by removing "+tag" suffixes.
+type EmailCanonicalizer interface {
+	Canonicalize(addr string) string
+}
+
+// ProviderEmailCanonicalizer canonicalizes addresses for domains whose mail providers ignore
+// dots and/or "+tag" suffixes in the local part of an address. Domains are mapped to the
+// domain that should be used in the canonical address.
+type ProviderEmailCanonicalizer struct {
+	IgnoreDots     map[string]string
+	IgnoreSubaddrs map[string]string
+}
+
+// DefaultProviderEmailCanonicalizer returns a ProviderEmailCanonicalizer for Gmail, Outlook
+// and Fastmail addresses.
+func DefaultProviderEmailCanonicalizer() *ProviderEmailCanonicalizer {
+
+	c := ProviderEmailCanonicalizer{
+		IgnoreDots: map[string]string{
+			"gmail.com":      "gmail.com",
+			"googlemail.com": "gmail.com",
+		},
+		IgnoreSubaddrs: map[string]string{
+			"gmail.com":      "gmail.com",
+			"googlemail.com": "gmail.com",
+			"outlook.com":    "outlook.com",
+			"hotmail.com":    "hotmail.com",
+			"fastmail.com":   "fastmail.com",
+		},
+	}
+
+	return &c
+}
+
+func (c *ProviderEmailCanonicalizer) Canonicalize(addr string) string {
+
+	idx := strings.LastIndex(addr, "@")
+
+	if idx < 1 {
+		return addr
+	}
+
+	local := addr[:idx]
+	domain := addr[idx+1:]
+
+	if canonical_domain, ok := c.IgnoreSubaddrs[domain]; ok {
+
+		plus := strings.Index(local, "+")
+
+		if plus > 0 {
+			local = local[:plus]
+		}
+
+		domain = canonical_domain
+	}
+
+	if canonical_domain, ok := c.IgnoreDots[domain]; ok {
+		local = strings.Replace(local, ".", "", -1)
+		domain = canonical_domain
+	}
+
+	return local + "@" + domain
+}
+
+// NormalizeEmailAddress trims whitespace from addr and case-folds it.
+func NormalizeEmailAddress(addr string) string {
+	return strings.ToLower(strings.TrimSpace(addr))
+}
+
+// NormalizeURL trims whitespace from url, applies Unicode NFKC normalization and case-folds it.
+func NormalizeURL(url string) string {
+	return strings.ToLower(NormalizeUsername(url))
+}
+
+// normalizeEmailAddress returns the value that the email index is derived from for addr.
+func normalizeEmailAddress(opts *DynamoDBAccountsDatabaseOptions, addr string) string {
+
+	addr = NormalizeEmailAddress(addr)
+
+	if opts.EmailCanonicalizer != nil {
+		addr = opts.EmailCanonicalizer.Canonicalize(addr)
+	}
+
+	return addr
+}
+
+// urlIndexValue returns the value stored in, and queried against, the "url" index for url.
+func urlIndexValue(opts *DynamoDBAccountsDatabaseOptions, url string) string {
+	return NormalizeURL(url)
+}
+
+// IndexCollision is a set of accounts whose email addresses, URLs or usernames are distinct
+// as stored but which share the same normalized index value.
+type IndexCollision struct {
+	Index      string  `json:"index"`
+	Value      string  `json:"value"`
+	AccountIDs []int64 `json:"account_ids"`
+}
+
+// FindIndexCollisions scans every account and returns the groups of accounts that would
+// share an "email", "url" or "skeleton" index value once normalized. These need to be resolved before
+// NormalizeAccountIndexes is run, since lookups for them will otherwise fail. It also returns
+// the IDs of accounts which are missing an email address or username, and so can not be
+// normalized, without checking them for collisions.
+func (db *DynamoDBAccountsDatabase) FindIndexCollisions(ctx context.Context) ([]*IndexCollision, []int64, error) {
+
+	by_email := make(map[string][]int64)
+	by_url := make(map[string][]int64)
+	by_skeleton := make(map[string][]int64)
+
+	invalid := make([]int64, 0)
+
+	err := db.eachAccount(ctx, func(item map[string]*aws_dynamodb.AttributeValue, dynamodb_acct *DynamoDBAccount, acct *account.Account) error {
+
+		if !hasIndexFields(acct) {
+			invalid = append(invalid, acct.ID)
+			return nil
+		}
+
+		email := emailIndexValue(db.options, acct.Address.URI)
+		url := urlIndexValue(db.options, acct.Username.Safe)
+
+		by_email[email] = append(by_email[email], acct.ID)
+		by_url[url] = append(by_url[url], acct.ID)
+
+		skeleton := usernameSkeletonValue(acct)
+
+		if skeleton != "" {
+			by_skeleton[skeleton] = append(by_skeleton[skeleton], acct.ID)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	collisions := make([]*IndexCollision, 0)
+
+	indexes := map[string]map[string][]int64{
+		"email":    by_email,
+		"url":      by_url,
+		"skeleton": by_skeleton,
+	}
+
+	for idx, values := range indexes {
+
+		for v, ids := range values {
+
+			if len(ids) < 2 {
+				continue
+			}
+
+			sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+
+			c := &IndexCollision{
+				Index:      idx,
+				Value:      v,
+				AccountIDs: ids,
+			}
+
+			collisions = append(collisions, c)
+		}
+	}
+
+	sort.Slice(collisions, func(i, j int) bool {
+
+		if collisions[i].Index != collisions[j].Index {
+			return collisions[i].Index < collisions[j].Index
+		}
+
+		return collisions[i].Value < collisions[j].Value
+	})
+
+	return collisions, invalid, nil
+}
+
+// NormalizeAccountIndexes rewrites every account whose stored "email", "url" or "skeleton"
+// attribute is not its normalized index value. Accounts without an email address or username
+// are reported as invalid and accounts modified since they were read are skipped. It is safe
+// to run more than once.
+func (db *DynamoDBAccountsDatabase) NormalizeAccountIndexes(ctx context.Context) (*RewriteResult, error) {
+
+	result := &RewriteResult{}
+
+	err := db.eachAccount(ctx, func(item map[string]*aws_dynamodb.AttributeValue, dynamodb_acct *DynamoDBAccount, acct *account.Account) error {
+
+		if !hasIndexFields(acct) {
+			result.Invalid = append(result.Invalid, acct.ID)
+			return nil
+		}
+
+		email := emailIndexValue(db.options, acct.Address.URI)
+		url := urlIndexValue(db.options, acct.Username.Safe)
+		skeleton := usernameSkeletonValue(acct)
+
+		if dynamodb_acct.Email == email && dynamodb_acct.URL == url && dynamodb_acct.Skeleton == skeleton {
+			return nil
+		}
+
+		req, err := newAccountRewrite(db.options, item, acct, nil)
+
+		if err != nil {
+			return err
+		}
+
+		if req == nil {
+			return nil
+		}
+
+		applied, err := applyRewrite(db.client, req)
+
+		if err != nil {
+			return err
+		}
+
+		result.add(applied)
+		return nil
+	})
+
+	return result, err
+}
+
+// hasIndexFields reports whether acct has the email address and username its "email" and
+// "url" index values are derived from.
+func hasIndexFields(acct *account.Account) bool {
+	return acct.Address != nil && acct.Address.URI != "" && acct.Username != nil && acct.Username.Safe != ""
+}
+
+// eachAccount scans the accounts table calling cb with each stored item, its decoded form
+// and its (decrypted) account, stopping at the first error.
+func (db *DynamoDBAccountsDatabase) eachAccount(ctx context.Context, cb func(map[string]*aws_dynamodb.AttributeValue, *DynamoDBAccount, *account.Account) error) error {
+
+	return scanItems(ctx, db.client, db.options.TableName, func(item map[string]*aws_dynamodb.AttributeValue) error {
+
+		dynamodb_acct, err := itemToDynamoDBAccount(item)
+
+		if err != nil {
+			return err
+		}
+
+		acct, err := dynamodbAccountToAccount(db.options, dynamodb_acct)
+
+		if err != nil {
+			return err
+		}
+
+		return cb(item, dynamodb_acct, acct)
+	})
+}
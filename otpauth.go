@@ -0,0 +1,176 @@
+package dynamodb
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-auth/account"
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/qr"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"image/color"
+	"image/png"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+)
+
+const MFA_OUTPUT_SECRET string = "secret"
+
+const MFA_OUTPUT_URI string = "uri"
+
+const MFA_OUTPUT_PNG string = "png"
+
+const MFA_OUTPUT_TERMINAL string = "terminal"
+
+const MFA_QRCODE_DEFAULT_SIZE int = 256
+
+// NewMFAKey generates a new TOTP key, and secret, for acct.
+func NewMFAKey(issuer string, acct *account.Account) (*otp.Key, error) {
+
+	opts := totp.GenerateOpts{
+		Issuer:      issuer,
+		AccountName: acct.Address.URI,
+	}
+
+	return totp.Generate(opts)
+}
+
+// MFAProvisioningURI returns the otpauth:// URI used to enroll secret in an authenticator app.
+func MFAProvisioningURI(issuer string, account_name string, secret string) string {
+
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", "6")
+	params.Set("period", strconv.FormatInt(TOTP_PERIOD, 10))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account_name,
+		RawQuery: params.Encode(),
+	}
+
+	return u.String()
+}
+
+// WriteMFAProvisioning writes the provisioning details for secret in the format named by
+// output, one of the MFA_OUTPUT_ constants. The "png" output is written to png_path
+// rather than wr.
+func WriteMFAProvisioning(wr io.Writer, output string, issuer string, account_name string, secret string, png_path string) error {
+
+	uri := MFAProvisioningURI(issuer, account_name, secret)
+
+	switch output {
+	case MFA_OUTPUT_SECRET:
+		_, err := fmt.Fprintln(wr, secret)
+		return err
+	case MFA_OUTPUT_URI:
+		_, err := fmt.Fprintln(wr, uri)
+		return err
+	case MFA_OUTPUT_TERMINAL:
+		return WriteMFAQRCodeTerminal(wr, uri)
+	case MFA_OUTPUT_PNG:
+
+		if png_path == "" {
+			return errors.New("Missing PNG path")
+		}
+
+		fh, err := os.OpenFile(png_path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+
+		if err != nil {
+			return err
+		}
+
+		err = WriteMFAQRCodePNG(fh, uri, MFA_QRCODE_DEFAULT_SIZE)
+
+		if err != nil {
+			fh.Close()
+			return err
+		}
+
+		return fh.Close()
+	default:
+		return fmt.Errorf("Invalid output '%s'", output)
+	}
+}
+
+func WriteMFAQRCodePNG(wr io.Writer, uri string, size int) error {
+
+	code, err := qr.Encode(uri, qr.M, qr.Auto)
+
+	if err != nil {
+		return err
+	}
+
+	code, err = barcode.Scale(code, size, size)
+
+	if err != nil {
+		return err
+	}
+
+	return png.Encode(wr, code)
+}
+
+// WriteMFAQRCodeTerminal renders uri as a QR code using Unicode half blocks, two modules
+// per character, drawing the light modules so that it can be scanned from a terminal
+// with a dark background.
+func WriteMFAQRCodeTerminal(wr io.Writer, uri string) error {
+
+	code, err := qr.Encode(uri, qr.M, qr.Auto)
+
+	if err != nil {
+		return err
+	}
+
+	bounds := code.Bounds()
+
+	// QR codes require a "quiet zone" of light modules around them
+
+	quiet := 2
+
+	min_x := bounds.Min.X - quiet
+	max_x := bounds.Max.X + quiet
+	min_y := bounds.Min.Y - quiet
+	max_y := bounds.Max.Y + quiet
+
+	is_light := func(x int, y int) bool {
+
+		if x < bounds.Min.X || x >= bounds.Max.X || y < bounds.Min.Y || y >= bounds.Max.Y {
+			return true
+		}
+
+		gray := color.GrayModel.Convert(code.At(x, y)).(color.Gray)
+		return gray.Y > 128
+	}
+
+	buf := bufio.NewWriter(wr)
+
+	for y := min_y; y < max_y; y += 2 {
+
+		for x := min_x; x < max_x; x++ {
+
+			top := is_light(x, y)
+			bottom := is_light(x, y+1)
+
+			switch {
+			case top && bottom:
+				buf.WriteString("█")
+			case top:
+				buf.WriteString("▀")
+			case bottom:
+				buf.WriteString("▄")
+			default:
+				buf.WriteString(" ")
+			}
+		}
+
+		buf.WriteString("\n")
+	}
+
+	return buf.Flush()
+}
@@ -0,0 +1,239 @@
+package dynamodb
+
+import (
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"strconv"
+)
+
+// PASSWORD_HISTORY_DEFAULT_SIZE is the default number of previous passwords that can not be reused.
+const PASSWORD_HISTORY_DEFAULT_SIZE int = 5
+
+var ErrPasswordReused = errors.New("Password has been used recently and can not be reused")
+
+// ChangePassword sets acct's password to pswd unless it matches the current password or
+// one of the last options.PasswordHistorySize passwords, in which case ErrPasswordReused
+// is returned. The password must also satisfy options.PasswordPolicy, if set. The replaced
+// password's hash is added to the account's history.
+func (db *DynamoDBAccountsDatabase) ChangePassword(acct *account.Account, pswd string) (*account.Account, error) {
+
+	previous, err := db.newPasswordHistory(acct, pswd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if previous == nil {
+
+		acct, err := acct.UpdatePassword(pswd)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return db.UpdateAccount(acct)
+	}
+
+	enc_history, err := passwordHistoryAttribute(db.options, acct.ID, previous)
+
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err = acct.UpdatePassword(pswd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	// The history is written in the same update as the password so that neither can be
+	// changed without the other
+
+	extra := map[string]*aws_dynamodb.AttributeValue{
+		"password_history": enc_history,
+	}
+
+	return db.updateAccount(acct, extra)
+}
+
+// CheckNewPassword returns an error if pswd does not satisfy options.PasswordPolicy or if
+// ChangePassword would reject it as recently used by acct. It does not modify the account.
+func (db *DynamoDBAccountsDatabase) CheckNewPassword(acct *account.Account, pswd string) error {
+
+	_, err := db.newPasswordHistory(acct, pswd)
+	return err
+}
+
+// newPasswordHistory validates pswd for acct and returns the password history to store once
+// it has been set, or nil if password history is disabled.
+func (db *DynamoDBAccountsDatabase) newPasswordHistory(acct *account.Account, pswd string) ([]*account.Password, error) {
+
+	err := CheckPassword(db.options.PasswordPolicy, pswd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	size := db.options.PasswordHistorySize
+
+	if size < 1 {
+		return nil, nil
+	}
+
+	history, err := db.getPasswordHistory(acct)
+
+	if err != nil {
+		return nil, err
+	}
+
+	previous := make([]*account.Password, 0)
+
+	if acct.Password != nil {
+
+		// Copy the current password in case UpdatePassword modifies it in place
+
+		current := *acct.Password
+		previous = append(previous, &current)
+	}
+
+	previous = append(previous, history...)
+
+	for _, p := range previous {
+
+		if passwordMatches(p, pswd) {
+			return nil, ErrPasswordReused
+		}
+	}
+
+	if len(previous) > size {
+		previous = previous[0:size]
+	}
+
+	return previous, nil
+}
+
+func (db *DynamoDBAccountsDatabase) getPasswordHistory(acct *account.Account) ([]*account.Password, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:            aws.String(db.options.TableName),
+		Key:                  idKey(acct.ID),
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("#history"),
+		ExpressionAttributeNames: map[string]*string{
+			"#history": aws.String("password_history"),
+		},
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return passwordHistoryFromAttribute(db.options, acct.ID, rsp.Item["password_history"])
+}
+
+// passwordHistoryAttribute encodes history as a list of password hashes or, if a key
+// provider is configured, as an encrypted envelope (a map) containing that list.
+func passwordHistoryAttribute(opts *DynamoDBAccountsDatabaseOptions, id int64, history []*account.Password) (*aws_dynamodb.AttributeValue, error) {
+
+	if opts.KeyProvider == nil {
+
+		enc_history, err := aws_dynamodbattribute.MarshalList(history)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return &aws_dynamodb.AttributeValue{L: enc_history}, nil
+	}
+
+	values := map[string]interface{}{
+		"password_history": history,
+	}
+
+	enc, err := encryptValues(opts.KeyProvider, strconv.FormatInt(id, 10), values)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return aws_dynamodbattribute.Marshal(enc)
+}
+
+func passwordHistoryFromAttribute(opts *DynamoDBAccountsDatabaseOptions, id int64, v *aws_dynamodb.AttributeValue) ([]*account.Password, error) {
+
+	history := make([]*account.Password, 0)
+
+	if v == nil {
+		return history, nil
+	}
+
+	if v.L != nil {
+
+		err := aws_dynamodbattribute.UnmarshalList(v.L, &history)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return history, nil
+	}
+
+	if v.M == nil {
+		return history, nil
+	}
+
+	enc, err := passwordHistoryEnvelope(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	targets := map[string]interface{}{
+		"password_history": &history,
+	}
+
+	err = decryptValues(opts.KeyProvider, enc, strconv.FormatInt(id, 10), targets)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+func passwordHistoryEnvelope(v *aws_dynamodb.AttributeValue) (*EncryptedFields, error) {
+
+	var enc *EncryptedFields
+
+	err := aws_dynamodbattribute.Unmarshal(v, &enc)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return enc, nil
+}
+
+func passwordMatches(p *account.Password, pswd string) bool {
+
+	if p == nil {
+		return false
+	}
+
+	tmp := account.Account{
+		Password: p,
+	}
+
+	digest, err := tmp.GetPassword()
+
+	if err != nil {
+		return false
+	}
+
+	return digest.Compare(pswd) == nil
+}
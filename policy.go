@@ -0,0 +1,249 @@
+package dynamodb
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const PASSWORD_DEFAULT_MINIMUM_LENGTH int = 10
+
+const PASSWORD_DEFAULT_MINIMUM_ENTROPY float64 = 40.0
+
+var ErrPasswordPolicyUnchecked = errors.New("Password policy can not be checked without the plaintext password")
+
+// PasswordPolicy is the interface for rules that a new password must satisfy. Check returns
+// a PasswordPolicyError describing why a password was rejected.
+type PasswordPolicy interface {
+	Check(string) error
+}
+
+type PasswordPolicyError struct {
+	Reason string
+}
+
+func (e *PasswordPolicyError) Error() string {
+	return fmt.Sprintf("Password rejected: %s", e.Reason)
+}
+
+// PasswordPolicies is a PasswordPolicy that requires a password to satisfy every one of its members.
+type PasswordPolicies []PasswordPolicy
+
+func (policies PasswordPolicies) Check(pswd string) error {
+
+	for _, p := range policies {
+
+		err := p.Check(pswd)
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+type MinimumLengthPolicy struct {
+	Length int
+}
+
+func (p *MinimumLengthPolicy) Check(pswd string) error {
+
+	if utf8.RuneCountInString(pswd) < p.Length {
+		return &PasswordPolicyError{fmt.Sprintf("it must be at least %d characters long", p.Length)}
+	}
+
+	return nil
+}
+
+// MinimumEntropyPolicy rejects passwords whose estimated entropy, based on their length and
+// the classes of characters they use, is less than Bits.
+type MinimumEntropyPolicy struct {
+	Bits float64
+}
+
+func (p *MinimumEntropyPolicy) Check(pswd string) error {
+
+	if PasswordEntropy(pswd) < p.Bits {
+		return &PasswordPolicyError{"it is too easy to guess, try a longer password or a wider mix of characters"}
+	}
+
+	return nil
+}
+
+// BreachedPasswordPolicy rejects passwords whose SHA-1 hash appears in a local copy of a
+// breached passwords list, without making any network requests. Path may be either a
+// single file of "HASH" or "HASH:COUNT" lines or a directory of files named for the
+// first five (uppercase) characters of a hash, each containing "SUFFIX:COUNT" lines, as
+// returned by the k-anonymity "range" API of haveibeenpwned.com.
+type BreachedPasswordPolicy struct {
+	Path string
+}
+
+func (p *BreachedPasswordPolicy) Check(pswd string) error {
+
+	breached, err := IsBreachedPassword(p.Path, pswd)
+
+	if err != nil {
+		return err
+	}
+
+	if breached {
+		return &PasswordPolicyError{"it has appeared in a data breach and must not be used"}
+	}
+
+	return nil
+}
+
+// NewPasswordPolicy returns a PasswordPolicy combining the rules above. Rules are omitted
+// if min_length or min_entropy are less than 1 or breached_path is empty.
+func NewPasswordPolicy(min_length int, min_entropy float64, breached_path string) PasswordPolicy {
+
+	policies := make(PasswordPolicies, 0)
+
+	if min_length > 0 {
+		policies = append(policies, &MinimumLengthPolicy{min_length})
+	}
+
+	if min_entropy > 0 {
+		policies = append(policies, &MinimumEntropyPolicy{min_entropy})
+	}
+
+	if breached_path != "" {
+		policies = append(policies, &BreachedPasswordPolicy{breached_path})
+	}
+
+	return policies
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return NewPasswordPolicy(PASSWORD_DEFAULT_MINIMUM_LENGTH, PASSWORD_DEFAULT_MINIMUM_ENTROPY, "")
+}
+
+func CheckPassword(policy PasswordPolicy, pswd string) error {
+
+	if policy == nil {
+		return nil
+	}
+
+	return policy.Check(pswd)
+}
+
+func PasswordEntropy(pswd string) float64 {
+
+	var has_lower, has_upper, has_digit, has_symbol, has_other bool
+
+	for _, r := range pswd {
+
+		switch {
+		case r <= unicode.MaxASCII && unicode.IsLower(r):
+			has_lower = true
+		case r <= unicode.MaxASCII && unicode.IsUpper(r):
+			has_upper = true
+		case r <= unicode.MaxASCII && unicode.IsDigit(r):
+			has_digit = true
+		case r <= unicode.MaxASCII:
+			has_symbol = true
+		default:
+			has_other = true
+		}
+	}
+
+	pool := 0
+
+	if has_lower {
+		pool += 26
+	}
+
+	if has_upper {
+		pool += 26
+	}
+
+	if has_digit {
+		pool += 10
+	}
+
+	if has_symbol {
+		pool += 33
+	}
+
+	if has_other {
+		pool += 100
+	}
+
+	if pool == 0 {
+		return 0.0
+	}
+
+	return float64(utf8.RuneCountInString(pswd)) * math.Log2(float64(pool))
+}
+
+func IsBreachedPassword(path string, pswd string) (bool, error) {
+
+	sum := sha1.Sum([]byte(pswd))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	prefix := hash[0:5]
+	suffix := hash[5:]
+
+	info, err := os.Stat(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	if info.IsDir() {
+
+		range_path := filepath.Join(path, prefix)
+
+		_, err := os.Stat(range_path)
+
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+
+		return scanBreachedPasswords(range_path, suffix)
+	}
+
+	return scanBreachedPasswords(path, hash)
+}
+
+func scanBreachedPasswords(path string, hash string) (bool, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return false, err
+	}
+
+	defer fh.Close()
+
+	scanner := bufio.NewScanner(fh)
+
+	for scanner.Scan() {
+
+		line := strings.TrimSpace(scanner.Text())
+
+		parts := strings.SplitN(line, ":", 2)
+
+		if strings.EqualFold(parts[0], hash) {
+			return true, nil
+		}
+	}
+
+	err = scanner.Err()
+
+	if err != nil {
+		return false, err
+	}
+
+	return false, nil
+}
@@ -0,0 +1,103 @@
+package dynamodb
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// The SHA-1 hash of "password"
+const TEST_BREACHED_HASH string = "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"
+
+func TestPasswordEntropy(t *testing.T) {
+
+	tests := []struct {
+		password string
+		entropy  float64
+	}{
+		{"", 0.0},
+		{"abc", 3 * math.Log2(26)},
+		{"ABC", 3 * math.Log2(26)},
+		{"1234", 4 * math.Log2(10)},
+		{"!!", 2 * math.Log2(33)},
+		{"aB3$", 4 * math.Log2(95)},
+		{"éé", 2 * math.Log2(100)},
+		{"aé", 2 * math.Log2(126)},
+	}
+
+	for _, test := range tests {
+
+		entropy := PasswordEntropy(test.password)
+
+		if math.Abs(entropy-test.entropy) > 0.0001 {
+			t.Fatalf("Expected entropy of '%s' to be %f, got %f", test.password, test.entropy, entropy)
+		}
+	}
+}
+
+func TestIsBreachedPassword(t *testing.T) {
+
+	dir, err := ioutil.TempDir("", "breached")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	list_path := filepath.Join(dir, "list")
+
+	err = ioutil.WriteFile(list_path, []byte("0000000000000000000000000000000000000000:1\n"+TEST_BREACHED_HASH+":3730471\n"), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	range_dir := filepath.Join(dir, "range")
+
+	err = os.Mkdir(range_dir, 0700)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(filepath.Join(range_dir, TEST_BREACHED_HASH[0:5]), []byte(TEST_BREACHED_HASH[5:]+":3730471\n"), 0600)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		path     string
+		password string
+		breached bool
+	}{
+		{list_path, "password", true},
+		{list_path, "Password", false},
+		{list_path, "correct horse battery staple", false},
+		{range_dir, "password", true},
+		{range_dir, "Password", false},
+		{range_dir, "correct horse battery staple", false},
+	}
+
+	for _, test := range tests {
+
+		breached, err := IsBreachedPassword(test.path, test.password)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if breached != test.breached {
+			t.Fatalf("Expected '%s' in %s to return %t", test.password, test.path, test.breached)
+		}
+	}
+
+	_, err = IsBreachedPassword(filepath.Join(dir, "missing"), "password")
+
+	if err == nil {
+		t.Fatal("Expected a missing breached passwords list to fail")
+	}
+}
@@ -0,0 +1,199 @@
+package dynamodb
+
+import (
+	"github.com/aaronland/go-auth/account"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"strconv"
+)
+
+// ReadOptions controls how individual lookups are performed. A nil ReadOptions is the same
+// as the database's default read options.
+//
+// ConsistentRead controls whether items are fetched with strongly consistent GetItem
+// requests, in which case a lookup returns the latest version of an item.
+//
+// Lookups by email address, URL or access token first resolve the item's ID using a global
+// secondary index. Global secondary indexes are updated asynchronously and DynamoDB does
+// not support strongly consistent reads on them, so regardless of ConsistentRead these
+// lookups may (briefly) fail to find an item which was only just created or whose indexed
+// attribute was only just changed.
+type ReadOptions struct {
+	ConsistentRead bool
+}
+
+// PartialAccount is an account returned by one of the GetPartialAccount methods, which only
+// read the named account fields ("address", "password", "username", "mfa", "created",
+// "lastmodified" or "status") plus the ID. Only accounts stored using ACCOUNT_SCHEMA_FLAT
+// can be partially read; accounts stored using ACCOUNT_SCHEMA_NESTED are always read in
+// full. Account must not be passed to UpdateAccount, or any other method that writes an
+// account, since the fields that were not read would be lost.
+type PartialAccount struct {
+	Account *account.Account
+	Fields  []string
+}
+
+// readTables returns the tables to read from, in order.
+func readTables(table string, fallback string) []string {
+
+	tables := []string{
+		table,
+	}
+
+	if fallback != "" && fallback != table {
+		tables = append(tables, fallback)
+	}
+
+	return tables
+}
+
+// queryPointer returns the (ID-only) items matching value in the index idx of the first
+// table in tables with any matches. Matches in a fallback table are ignored if the item
+// they point to exists in the first table, since the pointer may since have changed there.
+func queryPointer(client *aws_dynamodb.DynamoDB, tables []string, idx string, key string, value string) ([]map[string]*aws_dynamodb.AttributeValue, error) {
+
+	for i, table := range tables {
+
+		req := &aws_dynamodb.QueryInput{
+			TableName: aws.String(table),
+			KeyConditions: map[string]*aws_dynamodb.Condition{
+				key: {
+					ComparisonOperator: aws.String("EQ"),
+					AttributeValueList: []*aws_dynamodb.AttributeValue{
+						{
+							S: aws.String(value),
+						},
+					},
+				},
+			},
+			ProjectionExpression: aws.String("id"),
+			IndexName:            aws.String(idx),
+		}
+
+		rsp, err := client.Query(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		items := rsp.Items
+
+		if i > 0 {
+
+			current := make([]map[string]*aws_dynamodb.AttributeValue, 0)
+
+			for _, item := range items {
+
+				exists, err := itemExists(client, tables[0], item["id"])
+
+				if err != nil {
+					return nil, err
+				}
+
+				if !exists {
+					current = append(current, item)
+				}
+			}
+
+			items = current
+		}
+
+		if len(items) > 0 {
+			return items, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// itemExists reports whether an item with the key id exists in table.
+func itemExists(client *aws_dynamodb.DynamoDB, table string, id *aws_dynamodb.AttributeValue) (bool, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName: aws.String(table),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			"id": id,
+		},
+		ProjectionExpression: aws.String("#id"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id": aws.String("id"),
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rsp, err := client.GetItem(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	return len(rsp.Item) > 0, nil
+}
+
+// copyAccountForward copies the account with ID id from FallbackTableName to TableName if
+// it does not already exist there, so that the conditional updates to its login attempts,
+// MFA state and recovery codes find it. It does nothing if there is no fallback table.
+func (db *DynamoDBAccountsDatabase) copyAccountForward(id int64) error {
+
+	if len(readTables(db.options.TableName, db.options.FallbackTableName)) < 2 {
+		return nil
+	}
+
+	str_id := strconv.FormatInt(id, 10)
+
+	key := &aws_dynamodb.AttributeValue{
+		N: aws.String(str_id),
+	}
+
+	exists, err := itemExists(db.client, db.options.TableName, key)
+
+	if err != nil {
+		return err
+	}
+
+	if exists {
+		return nil
+	}
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName: aws.String(db.options.FallbackTableName),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			"id": key,
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return err
+	}
+
+	if len(rsp.Item) == 0 {
+		return nil
+	}
+
+	return db.putAccountItemForward(rsp.Item)
+}
+
+// putAccountItemForward writes item, read in full from FallbackTableName, to TableName
+// unless an item with the same ID has been written there in the meantime.
+func (db *DynamoDBAccountsDatabase) putAccountItemForward(item map[string]*aws_dynamodb.AttributeValue) error {
+
+	req := &aws_dynamodb.PutItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id": aws.String("id"),
+		},
+	}
+
+	_, err := db.client.PutItem(req)
+
+	if isConditionalCheckFailed(err) {
+		return nil
+	}
+
+	return err
+}
@@ -0,0 +1,64 @@
+package dynamodb
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"testing"
+)
+
+func TestAccountProjection(t *testing.T) {
+
+	projection, names, err := accountProjection(nil)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if projection != "" || names != nil {
+		t.Fatalf("Expected no projection, got '%s'", projection)
+	}
+
+	// Nested accounts are read in full, and need their encrypted fields to be decrypted,
+	// whichever fields are asked for
+
+	_, names, err = accountProjection([]string{"status"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	projected := make(map[string]bool)
+
+	for _, name := range names {
+		projected[aws.StringValue(name)] = true
+	}
+
+	for _, name := range []string{"id", "account", "encrypted", "status"} {
+
+		if !projected[name] {
+			t.Fatalf("Expected '%s' to be projected, got %v", name, projected)
+		}
+	}
+
+	if projected["password_digest"] {
+		t.Fatal("Expected 'password_digest' not to be projected")
+	}
+
+	_, _, err = accountProjection([]string{"secret"})
+
+	if err == nil {
+		t.Fatal("Expected an unsupported field to fail")
+	}
+}
+
+func TestPartialAccountRequiresFields(t *testing.T) {
+
+	db := &DynamoDBAccountsDatabase{
+		options: DefaultDynamoDBAccountsDatabaseOptions(),
+	}
+
+	_, err := db.GetPartialAccountByID(1234, nil, nil)
+
+	if err == nil {
+		t.Fatal("Expected a partial read without fields to fail")
+	}
+}
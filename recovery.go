@@ -0,0 +1,264 @@
+package dynamodb
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/hex"
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-auth/database"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"strings"
+)
+
+const MFA_RECOVERY_CODES_DEFAULT_COUNT int = 10
+
+// MFA_RECOVERY_SALT_SIZE is the size, in bytes, of the per-account salt recovery codes are
+// hashed with.
+const MFA_RECOVERY_SALT_SIZE int = 16
+
+// GenerateMFARecoveryCodes creates count new one-time recovery codes for acct, replacing
+// any existing ones. Only a keyed hash of each code is stored, using a new random salt for
+// each account, so the codes returned here must be shown to the user and can not be
+// retrieved again.
+func (db *DynamoDBAccountsDatabase) GenerateMFARecoveryCodes(acct *account.Account, count int) ([]string, error) {
+
+	if count < 1 {
+		return nil, errors.New("Invalid count")
+	}
+
+	// Accounts that have not been copied from the fallback table yet can not be updated
+
+	err := db.copyAccountForward(acct.ID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, MFA_RECOVERY_SALT_SIZE)
+
+	_, err = rand.Read(salt)
+
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0)
+	hashes := make([]*string, 0)
+
+	seen := make(map[string]bool)
+
+	for len(codes) < count {
+
+		code, err := newMFARecoveryCode()
+
+		if err != nil {
+			return nil, err
+		}
+
+		hash := hashMFARecoveryCode(salt, code)
+
+		if seen[hash] {
+			continue
+		}
+
+		seen[hash] = true
+
+		codes = append(codes, code)
+		hashes = append(hashes, aws.String(hash))
+	}
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Key:                 idKey(acct.ID),
+		UpdateExpression:    aws.String("SET #codes = :codes, #salt = :salt"),
+		ConditionExpression: aws.String("attribute_exists(#id)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":    aws.String("id"),
+			"#codes": aws.String("mfa_recovery_codes"),
+			"#salt":  aws.String("mfa_recovery_salt"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":codes": {SS: hashes},
+			":salt":  {B: salt},
+		},
+	}
+
+	_, err = db.client.UpdateItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+			return nil, new(database.ErrNoAccount)
+		}
+
+		return nil, err
+	}
+
+	attrs := map[string]interface{}{
+		"mfa_recovery_codes": len(codes),
+	}
+
+	db.auditAttributes(AUDIT_ACTION_MFA_RECOVERY_CODES, acct.ID, nil, nil, attrs)
+	return codes, nil
+}
+
+// RedeemMFARecoveryCode returns true if code is one of acct's unused recovery codes, in
+// which case it is atomically removed so that it can never be used again.
+func (db *DynamoDBAccountsDatabase) RedeemMFARecoveryCode(acct *account.Account, code string) (bool, error) {
+
+	err := db.copyAccountForward(acct.ID)
+
+	if err != nil {
+		return false, err
+	}
+
+	salt, err := db.getMFARecoverySalt(acct)
+
+	if err != nil {
+		return false, err
+	}
+
+	// No codes have been generated for acct
+
+	if salt == nil {
+		return false, nil
+	}
+
+	hash := hashMFARecoveryCode(salt, code)
+
+	req := &aws_dynamodb.UpdateItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Key:                 idKey(acct.ID),
+		UpdateExpression:    aws.String("DELETE #codes :codes"),
+		ConditionExpression: aws.String("contains(#codes, :code)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#codes": aws.String("mfa_recovery_codes"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":code":  {S: aws.String(hash)},
+			":codes": {SS: []*string{aws.String(hash)}},
+		},
+	}
+
+	_, err = db.client.UpdateItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+			return false, nil
+		}
+
+		return false, err
+	}
+
+	attrs := map[string]interface{}{
+		"mfa_recovery_codes": hash,
+	}
+
+	db.auditAttributes(AUDIT_ACTION_MFA_RECOVERY_REDEEM, acct.ID, nil, nil, attrs)
+	return true, nil
+}
+
+// CountMFARecoveryCodes returns the number of unused recovery codes for acct.
+func (db *DynamoDBAccountsDatabase) CountMFARecoveryCodes(acct *account.Account) (int, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:            aws.String(db.options.TableName),
+		Key:                  idKey(acct.ID),
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("#id, #codes"),
+		ExpressionAttributeNames: map[string]*string{
+			"#id":    aws.String("id"),
+			"#codes": aws.String("mfa_recovery_codes"),
+		},
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rsp.Item) == 0 {
+		return 0, new(database.ErrNoAccount)
+	}
+
+	codes, ok := rsp.Item["mfa_recovery_codes"]
+
+	if !ok {
+		return 0, nil
+	}
+
+	return len(codes.SS), nil
+}
+
+// getMFARecoverySalt returns the salt acct's recovery codes were hashed with, or nil if
+// none have been generated.
+func (db *DynamoDBAccountsDatabase) getMFARecoverySalt(acct *account.Account) ([]byte, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:            aws.String(db.options.TableName),
+		Key:                  idKey(acct.ID),
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("#salt"),
+		ExpressionAttributeNames: map[string]*string{
+			"#salt": aws.String("mfa_recovery_salt"),
+		},
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := rsp.Item["mfa_recovery_salt"]
+
+	if !ok || len(v.B) == 0 {
+		return nil, nil
+	}
+
+	return v.B, nil
+}
+
+// Recovery codes are sixteen random base32 characters (80 bits) formatted as
+// "xxxx-xxxx-xxxx-xxxx".
+func newMFARecoveryCode() (string, error) {
+
+	b := make([]byte, 10)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b)
+	enc = strings.ToLower(enc[0:16])
+
+	return enc[0:4] + "-" + enc[4:8] + "-" + enc[8:12] + "-" + enc[12:16], nil
+}
+
+// hashMFARecoveryCode returns the HMAC-SHA256, keyed with the account's salt, of code.
+// Salting means that codes have to be guessed one account at a time.
+func hashMFARecoveryCode(salt []byte, code string) string {
+
+	mac := hmac.New(sha256.New, salt)
+	mac.Write([]byte(normalizeMFARecoveryCode(code)))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func normalizeMFARecoveryCode(code string) string {
+
+	code = strings.ToLower(code)
+	code = strings.Replace(code, "-", "", -1)
+	code = strings.Replace(code, " ", "", -1)
+	code = strings.TrimSpace(code)
+
+	return code
+}
@@ -0,0 +1,54 @@
+package dynamodb
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestHashMFARecoveryCode(t *testing.T) {
+
+	salt := []byte("0123456789abcdef")
+	other_salt := []byte("fedcba9876543210")
+
+	code := "abcd-efgh-ijkl-mnop"
+
+	hash := hashMFARecoveryCode(salt, code)
+
+	if hash != hashMFARecoveryCode(salt, "ABCD EFGH IJKL MNOP") {
+		t.Fatal("Expected hashes of equivalent codes to match")
+	}
+
+	if hash == hashMFARecoveryCode(other_salt, code) {
+		t.Fatal("Expected hashes with different salts to differ")
+	}
+
+	if hash == hashMFARecoveryCode(salt, "abcd-efgh-ijkl-mnoq") {
+		t.Fatal("Expected hashes of different codes to differ")
+	}
+}
+
+func TestNewMFARecoveryCode(t *testing.T) {
+
+	re := regexp.MustCompile(`^[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}-[a-z2-7]{4}$`)
+
+	seen := make(map[string]bool)
+
+	for i := 0; i < 100; i++ {
+
+		code, err := newMFARecoveryCode()
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !re.MatchString(code) {
+			t.Fatalf("Unexpected code format '%s'", code)
+		}
+
+		if seen[code] {
+			t.Fatalf("Unexpected duplicate code '%s'", code)
+		}
+
+		seen[code] = true
+	}
+}
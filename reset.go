@@ -0,0 +1,369 @@
+package dynamodb
+
+import (
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-auth/database"
+	"github.com/aaronland/go-aws-session"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"strconv"
+	"time"
+)
+
+const RESETTOKENS_DEFAULT_TABLENAME string = "reset_tokens"
+
+var ErrInvalidResetToken = errors.New("Invalid or expired reset token")
+
+var ErrResetRateLimited = errors.New("Too many reset tokens requested")
+
+type DynamoDBResetTokensDatabaseOptions struct {
+	TableName       string
+	BillingMode     string
+	CreateTable     bool
+	Retry           *RetryOptions
+	TTL             time.Duration
+	RateLimit       int
+	RateLimitWindow time.Duration
+}
+
+func DefaultDynamoDBResetTokensDatabaseOptions() *DynamoDBResetTokensDatabaseOptions {
+
+	opts := DynamoDBResetTokensDatabaseOptions{
+		TableName:       RESETTOKENS_DEFAULT_TABLENAME,
+		BillingMode:     "PAY_PER_REQUEST",
+		CreateTable:     false,
+		Retry:           DefaultRetryOptions(),
+		TTL:             1 * time.Hour,
+		RateLimit:       3,
+		RateLimitWindow: 1 * time.Hour,
+	}
+
+	return &opts
+}
+
+// ResetToken is the stored form of a password reset token. Token is a SHA-256 hash of the
+// token given to the user; the token itself is never stored. Expires is also used as the
+// table's TTL attribute.
+type ResetToken struct {
+	Token     string `json:"token"`
+	AccountID int64  `json:"account_id"`
+	Created   int64  `json:"created"`
+	Expires   int64  `json:"expires"`
+}
+
+type DynamoDBResetTokensDatabase struct {
+	client  *aws_dynamodb.DynamoDB
+	options *DynamoDBResetTokensDatabaseOptions
+}
+
+func NewDynamoDBResetTokensDatabaseWithDSN(dsn string, opts *DynamoDBResetTokensDatabaseOptions) (*DynamoDBResetTokensDatabase, error) {
+
+	sess, err := session.NewSessionWithDSN(dsn)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDynamoDBResetTokensDatabaseWithSession(sess, opts)
+}
+
+func NewDynamoDBResetTokensDatabaseWithSession(sess *aws_session.Session, opts *DynamoDBResetTokensDatabaseOptions) (*DynamoDBResetTokensDatabase, error) {
+
+	client := newDynamoDBClient(sess, opts.Retry)
+
+	if opts.CreateTable {
+
+		_, err := CreateResetTokensTable(client, opts)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	db := DynamoDBResetTokensDatabase{
+		client:  client,
+		options: opts,
+	}
+
+	return &db, nil
+}
+
+// IssueToken creates a new single-use reset token for acct and returns it. It returns
+// ErrResetRateLimited if more than options.RateLimit tokens have been issued for acct
+// in the last options.RateLimitWindow.
+func (db *DynamoDBResetTokensDatabase) IssueToken(acct *account.Account) (string, error) {
+
+	err := db.checkRateLimit(acct)
+
+	if err != nil {
+		return "", err
+	}
+
+	raw, err := newSecretToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	tok := ResetToken{
+		Token:     hashSecretToken(raw),
+		AccountID: acct.ID,
+		Created:   now.Unix(),
+		Expires:   now.Add(db.options.TTL).Unix(),
+	}
+
+	item, err := aws_dynamodbattribute.MarshalMap(tok)
+
+	if err != nil {
+		return "", err
+	}
+
+	req := &aws_dynamodb.PutItemInput{
+		TableName:           aws.String(db.options.TableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#token)"),
+		ExpressionAttributeNames: map[string]*string{
+			"#token": aws.String("token"),
+		},
+	}
+
+	_, err = db.client.PutItem(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+// ConsumeToken deletes the reset token raw and returns the ID of the account it was issued
+// for. Because the delete is conditional on the token existing and not having expired a
+// token can only be consumed once.
+func (db *DynamoDBResetTokensDatabase) ConsumeToken(raw string) (int64, error) {
+
+	now := time.Now()
+	str_now := strconv.FormatInt(now.Unix(), 10)
+
+	req := &aws_dynamodb.DeleteItemInput{
+		TableName: aws.String(db.options.TableName),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			"token": {
+				S: aws.String(hashSecretToken(raw)),
+			},
+		},
+		ConditionExpression: aws.String("attribute_exists(#token) AND #expires > :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#token":   aws.String("token"),
+			"#expires": aws.String("expires"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":now": {N: aws.String(str_now)},
+		},
+		ReturnValues: aws.String(aws_dynamodb.ReturnValueAllOld),
+	}
+
+	rsp, err := db.client.DeleteItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+			return 0, ErrInvalidResetToken
+		}
+
+		return 0, err
+	}
+
+	var tok *ResetToken
+
+	err = aws_dynamodbattribute.UnmarshalMap(rsp.Attributes, &tok)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if tok.AccountID == 0 {
+		return 0, ErrInvalidResetToken
+	}
+
+	return tok.AccountID, nil
+}
+
+// LookupToken returns the ID of the account the reset token raw was issued for without
+// consuming it.
+func (db *DynamoDBResetTokensDatabase) LookupToken(raw string) (int64, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName: aws.String(db.options.TableName),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			"token": {
+				S: aws.String(hashSecretToken(raw)),
+			},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if len(rsp.Item) == 0 {
+		return 0, ErrInvalidResetToken
+	}
+
+	var tok *ResetToken
+
+	err = aws_dynamodbattribute.UnmarshalMap(rsp.Item, &tok)
+
+	if err != nil {
+		return 0, err
+	}
+
+	if tok.AccountID == 0 || tok.Expires <= time.Now().Unix() {
+		return 0, ErrInvalidResetToken
+	}
+
+	return tok.AccountID, nil
+}
+
+// ResetPassword sets the password of the account the reset token raw was issued for to
+// pswd. The new password is validated before the token is consumed so that a rejected
+// password does not use up the token.
+func (db *DynamoDBResetTokensDatabase) ResetPassword(accounts_db database.AccountsDatabase, raw string, pswd string) (*account.Account, error) {
+
+	account_id, err := db.LookupToken(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := accounts_db.GetAccountByID(account_id)
+
+	if err != nil {
+		return nil, err
+	}
+
+	dynamodb_db, ok := accounts_db.(*DynamoDBAccountsDatabase)
+
+	if ok {
+
+		err = dynamodb_db.CheckNewPassword(acct, pswd)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	consumed_id, err := db.ConsumeToken(raw)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if consumed_id != account_id {
+		return nil, ErrInvalidResetToken
+	}
+
+	if ok {
+		return dynamodb_db.ChangePassword(acct, pswd)
+	}
+
+	acct, err = acct.UpdatePassword(pswd)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return accounts_db.UpdateAccount(acct)
+}
+
+// checkRateLimit atomically counts token requests for acct in a per-account item stored
+// alongside the tokens themselves. The count is reset once the window has elapsed.
+func (db *DynamoDBResetTokensDatabase) checkRateLimit(acct *account.Account) error {
+
+	if db.options.RateLimit < 1 {
+		return nil
+	}
+
+	now := time.Now()
+
+	str_now := strconv.FormatInt(now.Unix(), 10)
+	str_start := strconv.FormatInt(now.Add(-db.options.RateLimitWindow).Unix(), 10)
+	str_expires := strconv.FormatInt(now.Add(db.options.RateLimitWindow).Unix(), 10)
+	str_limit := strconv.Itoa(db.options.RateLimit)
+
+	key := map[string]*aws_dynamodb.AttributeValue{
+		"token": {
+			S: aws.String("ratelimit#" + strconv.FormatInt(acct.ID, 10)),
+		},
+	}
+
+	names := map[string]*string{
+		"#count":   aws.String("count"),
+		"#start":   aws.String("window_start"),
+		"#expires": aws.String("expires"),
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+
+		req := &aws_dynamodb.UpdateItemInput{
+			TableName:                aws.String(db.options.TableName),
+			Key:                      key,
+			UpdateExpression:         aws.String("ADD #count :one SET #start = if_not_exists(#start, :now), #expires = :expires"),
+			ConditionExpression:      aws.String("attribute_not_exists(#start) OR (#start > :start AND #count < :limit)"),
+			ExpressionAttributeNames: names,
+			ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+				":one":     {N: aws.String("1")},
+				":now":     {N: aws.String(str_now)},
+				":start":   {N: aws.String(str_start)},
+				":limit":   {N: aws.String(str_limit)},
+				":expires": {N: aws.String(str_expires)},
+			},
+		}
+
+		_, err := db.client.UpdateItem(req)
+
+		if err == nil {
+			return nil
+		}
+
+		if !isConditionalCheckFailed(err) {
+			return err
+		}
+
+		req = &aws_dynamodb.UpdateItemInput{
+			TableName:                aws.String(db.options.TableName),
+			Key:                      key,
+			UpdateExpression:         aws.String("SET #count = :one, #start = :now, #expires = :expires"),
+			ConditionExpression:      aws.String("#start <= :start"),
+			ExpressionAttributeNames: names,
+			ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+				":one":     {N: aws.String("1")},
+				":now":     {N: aws.String(str_now)},
+				":start":   {N: aws.String(str_start)},
+				":expires": {N: aws.String(str_expires)},
+			},
+		}
+
+		_, err = db.client.UpdateItem(req)
+
+		if err == nil {
+			return nil
+		}
+
+		if !isConditionalCheckFailed(err) {
+			return err
+		}
+
+		// The window has not elapsed so we are over the limit, unless another request reset
+		// the count between the two updates in which case try again
+	}
+
+	return ErrResetRateLimited
+}
@@ -0,0 +1,113 @@
+package dynamodb
+
+import (
+	aws "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/client"
+	"github.com/aws/aws-sdk-go/aws/request"
+	aws_session "github.com/aws/aws-sdk-go/aws/session"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"math/rand"
+	"time"
+)
+
+// RetryOptions controls how failed or throttled requests, and unprocessed batch items,
+// are retried. MaxAttempts includes the initial attempt. Delays grow exponentially from
+// BaseDelay up to MaxDelay and Jitter (0.0 - 1.0) is the fraction of each delay that
+// may be randomly subtracted from it.
+type RetryOptions struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+}
+
+func DefaultRetryOptions() *RetryOptions {
+
+	opts := RetryOptions{
+		MaxAttempts: 10,
+		BaseDelay:   50 * time.Millisecond,
+		MaxDelay:    5 * time.Second,
+		Jitter:      0.5,
+	}
+
+	return &opts
+}
+
+// Attempts returns the number of times a request is made, including the initial attempt.
+// Values of MaxAttempts less than 1 are treated as a single attempt.
+func (opts *RetryOptions) Attempts() int {
+
+	if opts.MaxAttempts < 1 {
+		return 1
+	}
+
+	return opts.MaxAttempts
+}
+
+// Delay returns how long to wait before retrying after the given (zero-based) attempt.
+func (opts *RetryOptions) Delay(attempt int) time.Duration {
+
+	delay := opts.BaseDelay
+
+	for i := 0; i < attempt && delay < opts.MaxDelay; i++ {
+		delay = delay * 2
+	}
+
+	if delay > opts.MaxDelay {
+		delay = opts.MaxDelay
+	}
+
+	if opts.Jitter > 0.0 && delay > 0 {
+
+		jitter := opts.Jitter
+
+		if jitter > 1.0 {
+			jitter = 1.0
+		}
+
+		max_jitter := int64(float64(delay) * jitter)
+
+		if max_jitter > 0 {
+			delay = delay - time.Duration(rand.Int63n(max_jitter))
+		}
+	}
+
+	return delay
+}
+
+type retryer struct {
+	client.DefaultRetryer
+	options *RetryOptions
+}
+
+func (r retryer) MaxRetries() int {
+	return r.options.Attempts() - 1
+}
+
+func (r retryer) RetryRules(req *request.Request) time.Duration {
+	return r.options.Delay(req.RetryCount)
+}
+
+func newDynamoDBClient(sess *aws_session.Session, opts *RetryOptions) *aws_dynamodb.DynamoDB {
+
+	if opts == nil {
+		return aws_dynamodb.New(sess)
+	}
+
+	r := retryer{
+		options: opts,
+	}
+
+	cfg := request.WithRetryer(aws.NewConfig(), r)
+
+	return aws_dynamodb.New(sess, cfg)
+}
+
+func retryOptionsOrDefault(opts *RetryOptions) *RetryOptions {
+
+	if opts == nil {
+		return DefaultRetryOptions()
+	}
+
+	return opts
+}
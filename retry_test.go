@@ -0,0 +1,78 @@
+package dynamodb
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRetryAttempts(t *testing.T) {
+
+	tests := []struct {
+		max_attempts int
+		attempts     int
+	}{
+		{-1, 1},
+		{0, 1},
+		{1, 1},
+		{10, 10},
+	}
+
+	for _, test := range tests {
+
+		opts := &RetryOptions{
+			MaxAttempts: test.max_attempts,
+		}
+
+		if opts.Attempts() != test.attempts {
+			t.Fatalf("Expected %d attempts for MaxAttempts %d, got %d", test.attempts, test.max_attempts, opts.Attempts())
+		}
+
+		r := retryer{
+			options: opts,
+		}
+
+		if r.MaxRetries() != test.attempts-1 {
+			t.Fatalf("Expected %d retries for MaxAttempts %d, got %d", test.attempts-1, test.max_attempts, r.MaxRetries())
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+
+	opts := &RetryOptions{
+		BaseDelay: 50 * time.Millisecond,
+		MaxDelay:  time.Second,
+	}
+
+	tests := []struct {
+		attempt int
+		delay   time.Duration
+	}{
+		{0, 50 * time.Millisecond},
+		{1, 100 * time.Millisecond},
+		{2, 200 * time.Millisecond},
+		{4, 800 * time.Millisecond},
+		{5, time.Second},
+		{100, time.Second},
+	}
+
+	for _, test := range tests {
+
+		delay := opts.Delay(test.attempt)
+
+		if delay != test.delay {
+			t.Fatalf("Expected a delay of %v for attempt %d, got %v", test.delay, test.attempt, delay)
+		}
+	}
+
+	opts.Jitter = 0.5
+
+	for i := 0; i < 100; i++ {
+
+		delay := opts.Delay(5)
+
+		if delay <= 500*time.Millisecond || delay > time.Second {
+			t.Fatalf("Delay %v outside of jitter range", delay)
+		}
+	}
+}
@@ -0,0 +1,421 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/aaronland/go-auth/account"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ACCOUNT_SCHEMA_NESTED stores the account.Account as a single "account" map attribute
+// alongside the top-level attributes used for indexes. This is the original item format.
+const ACCOUNT_SCHEMA_NESTED int = 1
+
+// ACCOUNT_SCHEMA_FLAT stores each account field as its own typed top-level attribute so
+// that items can be filtered and projected on, for example, status, created or whether
+// MFA is configured.
+const ACCOUNT_SCHEMA_FLAT int = 2
+
+// DynamoDBFlatAccount is the ACCOUNT_SCHEMA_FLAT representation of an account. Fields
+// which are encrypted are omitted and stored in Encrypted instead.
+type DynamoDBFlatAccount struct {
+	ID                   int64            `json:"id"`
+	Created              int64            `json:"created"`
+	LastModified         int64            `json:"lastmodified"`
+	Status               int              `json:"status"`
+	Email                string           `json:"email"`
+	URL                  string           `json:"url"`
+	Skeleton             string           `json:"skeleton,omitempty"`
+	AddressURI           string           `json:"address_uri,omitempty"`
+	AddressConfirmed     *bool            `json:"address_confirmed,omitempty"`
+	UsernameRaw          string           `json:"username_raw,omitempty"`
+	UsernameSafe         string           `json:"username_safe,omitempty"`
+	PasswordDigest       string           `json:"password_digest,omitempty"`
+	PasswordSalt         string           `json:"password_salt,omitempty"`
+	PasswordLastModified int64            `json:"password_lastmodified,omitempty"`
+	MFASecret            string           `json:"mfa_secret,omitempty"`
+	MFALastModified      int64            `json:"mfa_lastmodified,omitempty"`
+	HasMFA               bool             `json:"has_mfa"`
+	Encrypted            *EncryptedFields `json:"encrypted,omitempty"`
+}
+
+// accountSchemaAttributes are the attributes written by either schema. Any of them that
+// are not part of a newly encoded item are removed when it is written, so that switching
+// schemas (or encrypting a field) does not leave stale copies of account data behind.
+var accountSchemaAttributes = []string{
+	"account",
+	"lastmodified",
+	"status",
+	"skeleton",
+	"address_uri",
+	"address_confirmed",
+	"username_raw",
+	"username_safe",
+	"password_digest",
+	"password_salt",
+	"password_lastmodified",
+	"mfa_secret",
+	"mfa_lastmodified",
+	"has_mfa",
+	"encrypted",
+}
+
+// accountFieldAttributes maps account.Account fields (by JSON name) to the ACCOUNT_SCHEMA_FLAT
+// attributes they are stored in, for building projections.
+var accountFieldAttributes = map[string][]string{
+	"address":      {"address_uri", "address_confirmed"},
+	"password":     {"password_digest", "password_salt", "password_lastmodified"},
+	"username":     {"username_raw", "username_safe"},
+	"mfa":          {"mfa_secret", "mfa_lastmodified", "has_mfa"},
+	"created":      {"created"},
+	"lastmodified": {"lastmodified"},
+	"status":       {"status"},
+}
+
+// AccountsFilter limits the accounts returned by ListAccountsPageWithFilter. Nil or zero
+// values are ignored. Filters are applied to ACCOUNT_SCHEMA_FLAT attributes, so accounts
+// stored using ACCOUNT_SCHEMA_NESTED will never match a non-empty filter.
+type AccountsFilter struct {
+	Status        *int
+	CreatedAfter  int64
+	CreatedBefore int64
+	HasMFA        *bool
+}
+
+func validateSchemaOptions(opts *DynamoDBAccountsDatabaseOptions) error {
+
+	switch opts.Schema {
+	case ACCOUNT_SCHEMA_NESTED, ACCOUNT_SCHEMA_FLAT:
+		return nil
+	default:
+		return fmt.Errorf("Unsupported account schema %d", opts.Schema)
+	}
+}
+
+// ListAccountsPageWithFilter is like ListAccountsPage but only returns accounts matching
+// filter. Because DynamoDB applies filters after reading items, and the whole table may
+// need to be read to fill a page, filters which match few accounts can be slow.
+func (db *DynamoDBAccountsDatabase) ListAccountsPageWithFilter(ctx context.Context, opts *PageOptions, filter *AccountsFilter) ([]*account.Account, string, error) {
+
+	err := validateCursorSecret(db.cursor_secret)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	opts = pageOptions(opts)
+
+	start_key, err := decodeCursor(db.cursor_secret, db.options.TableName, opts.Cursor)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	req := &aws_dynamodb.ScanInput{
+		TableName:         aws.String(db.options.TableName),
+		ExclusiveStartKey: start_key,
+	}
+
+	err = applyAccountsFilter(req, filter)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	items, last_key, err := scanPage(db.client, req, pageSize(opts), []string{"id"})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	accounts := make([]*account.Account, 0)
+
+	for _, item := range items {
+
+		acct, err := itemToAccount(db.options, item)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		accounts = append(accounts, acct)
+	}
+
+	cursor, err := encodeCursor(db.cursor_secret, db.options.TableName, last_key)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return accounts, cursor, nil
+}
+
+func applyAccountsFilter(req *aws_dynamodb.ScanInput, filter *AccountsFilter) error {
+
+	if filter == nil {
+		return nil
+	}
+
+	conditions := make([]string, 0)
+	names := make(map[string]*string)
+	values := make(map[string]*aws_dynamodb.AttributeValue)
+
+	if filter.Status != nil {
+		conditions = append(conditions, "#status = :status")
+		names["#status"] = aws.String("status")
+		values[":status"] = &aws_dynamodb.AttributeValue{N: aws.String(strconv.Itoa(*filter.Status))}
+	}
+
+	if filter.CreatedAfter > 0 {
+		conditions = append(conditions, "#created > :created_after")
+		names["#created"] = aws.String("created")
+		values[":created_after"] = &aws_dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(filter.CreatedAfter, 10))}
+	}
+
+	if filter.CreatedBefore > 0 {
+		conditions = append(conditions, "#created < :created_before")
+		names["#created"] = aws.String("created")
+		values[":created_before"] = &aws_dynamodb.AttributeValue{N: aws.String(strconv.FormatInt(filter.CreatedBefore, 10))}
+	}
+
+	if filter.HasMFA != nil {
+		conditions = append(conditions, "#has_mfa = :has_mfa")
+		names["#has_mfa"] = aws.String("has_mfa")
+		values[":has_mfa"] = &aws_dynamodb.AttributeValue{BOOL: aws.Bool(*filter.HasMFA)}
+	}
+
+	if filter.CreatedAfter > 0 && filter.CreatedBefore > 0 && filter.CreatedAfter >= filter.CreatedBefore {
+		return errors.New("Invalid created range")
+	}
+
+	if len(conditions) == 0 {
+		return nil
+	}
+
+	req.FilterExpression = aws.String(strings.Join(conditions, " AND "))
+	req.ExpressionAttributeNames = names
+	req.ExpressionAttributeValues = values
+
+	return nil
+}
+
+// accountProjection returns the projection expression, and attribute names, needed to read
+// the account fields in fields from either schema. An empty expression means that the
+// whole item should be read. Encrypted fields are always read, since nested accounts are
+// read in full and need them to be decrypted.
+func accountProjection(fields []string) (string, map[string]*string, error) {
+
+	if len(fields) == 0 {
+		return "", nil, nil
+	}
+
+	attrs := map[string]bool{
+		"id":        true,
+		"account":   true,
+		"encrypted": true,
+	}
+
+	for _, f := range fields {
+
+		f_attrs, ok := accountFieldAttributes[f]
+
+		if !ok {
+			return "", nil, fmt.Errorf("Unsupported account field '%s'", f)
+		}
+
+		for _, a := range f_attrs {
+			attrs[a] = true
+		}
+	}
+
+	sorted := make([]string, 0)
+
+	for a := range attrs {
+		sorted = append(sorted, a)
+	}
+
+	sort.Strings(sorted)
+
+	projection := make([]string, 0)
+	names := make(map[string]*string)
+
+	for i, a := range sorted {
+		k := fmt.Sprintf("#p%d", i)
+		names[k] = aws.String(a)
+		projection = append(projection, k)
+	}
+
+	return strings.Join(projection, ", "), names, nil
+}
+
+// staleAccountAttributes returns the account attributes that are not part of item and
+// should be removed from the stored item when it is written.
+func staleAccountAttributes(item map[string]*aws_dynamodb.AttributeValue) []string {
+
+	stale := make([]string, 0)
+
+	for _, name := range accountSchemaAttributes {
+
+		_, ok := item[name]
+
+		if !ok {
+			stale = append(stale, name)
+		}
+	}
+
+	return stale
+}
+
+func dynamodbAccountToFlatAccount(dynamodb_acct *DynamoDBAccount) *DynamoDBFlatAccount {
+
+	acct := dynamodb_acct.Account
+
+	flat := DynamoDBFlatAccount{
+		ID:           dynamodb_acct.ID,
+		Created:      dynamodb_acct.Created,
+		LastModified: acct.LastModified,
+		Status:       acct.Status,
+		Email:        dynamodb_acct.Email,
+		URL:          dynamodb_acct.URL,
+		Skeleton:     dynamodb_acct.Skeleton,
+		Encrypted:    dynamodb_acct.Encrypted,
+	}
+
+	if acct.Address != nil {
+		flat.AddressURI = acct.Address.URI
+		flat.AddressConfirmed = aws.Bool(acct.Address.Confirmed)
+	}
+
+	if acct.Username != nil {
+		flat.UsernameRaw = acct.Username.Raw
+		flat.UsernameSafe = acct.Username.Safe
+	}
+
+	if acct.Password != nil {
+		flat.PasswordDigest = acct.Password.Digest
+		flat.PasswordSalt = acct.Password.Salt
+		flat.PasswordLastModified = acct.Password.LastModified
+	}
+
+	if acct.MFA != nil {
+		flat.MFASecret = acct.MFA.Secret
+		flat.MFALastModified = acct.MFA.LastModified
+	}
+
+	flat.HasMFA = acct.MFA != nil || (dynamodb_acct.Encrypted != nil && dynamodb_acct.Encrypted.Fields["mfa"] != nil)
+
+	return &flat
+}
+
+func flatAccountToDynamoDBAccount(flat *DynamoDBFlatAccount) *DynamoDBAccount {
+
+	acct := account.Account{
+		ID:           flat.ID,
+		Created:      flat.Created,
+		LastModified: flat.LastModified,
+		Status:       flat.Status,
+	}
+
+	if flat.AddressURI != "" || flat.AddressConfirmed != nil {
+
+		acct.Address = &account.Address{
+			URI: flat.AddressURI,
+		}
+
+		if flat.AddressConfirmed != nil {
+			acct.Address.Confirmed = *flat.AddressConfirmed
+		}
+	}
+
+	if flat.UsernameRaw != "" || flat.UsernameSafe != "" {
+
+		acct.Username = &account.Username{
+			Raw:  flat.UsernameRaw,
+			Safe: flat.UsernameSafe,
+		}
+	}
+
+	if flat.PasswordDigest != "" {
+
+		acct.Password = &account.Password{
+			Digest:       flat.PasswordDigest,
+			Salt:         flat.PasswordSalt,
+			LastModified: flat.PasswordLastModified,
+		}
+	}
+
+	if flat.MFASecret != "" {
+
+		acct.MFA = &account.MFA{
+			Secret:       flat.MFASecret,
+			LastModified: flat.MFALastModified,
+		}
+	}
+
+	dynamodb_acct := DynamoDBAccount{
+		ID:        flat.ID,
+		Created:   flat.Created,
+		Email:     flat.Email,
+		URL:       flat.URL,
+		Skeleton:  flat.Skeleton,
+		Account:   &acct,
+		Encrypted: flat.Encrypted,
+	}
+
+	return &dynamodb_acct
+}
+
+// isNestedAccountItem reports whether item was written using ACCOUNT_SCHEMA_NESTED.
+func isNestedAccountItem(item map[string]*aws_dynamodb.AttributeValue) bool {
+	_, ok := item["account"]
+	return ok
+}
+
+func marshalAccountItem(opts *DynamoDBAccountsDatabaseOptions, dynamodb_acct *DynamoDBAccount) (map[string]*aws_dynamodb.AttributeValue, error) {
+
+	if opts.Schema == ACCOUNT_SCHEMA_FLAT {
+		return aws_dynamodbattribute.MarshalMap(dynamodbAccountToFlatAccount(dynamodb_acct))
+	}
+
+	return aws_dynamodbattribute.MarshalMap(dynamodb_acct)
+}
+
+func unmarshalAccountItem(item map[string]*aws_dynamodb.AttributeValue) (*DynamoDBAccount, error) {
+
+	if len(item) == 0 {
+		return nil, nil
+	}
+
+	if isNestedAccountItem(item) {
+
+		var dynamodb_acct *DynamoDBAccount
+
+		err := aws_dynamodbattribute.UnmarshalMap(item, &dynamodb_acct)
+
+		if err != nil {
+			return nil, err
+		}
+
+		return dynamodb_acct, nil
+	}
+
+	var flat *DynamoDBFlatAccount
+
+	err := aws_dynamodbattribute.UnmarshalMap(item, &flat)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if flat == nil {
+		return nil, nil
+	}
+
+	return flatAccountToDynamoDBAccount(flat), nil
+}
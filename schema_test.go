@@ -0,0 +1,89 @@
+package dynamodb
+
+import (
+	"github.com/aaronland/go-auth/account"
+	"reflect"
+	"testing"
+)
+
+func TestFlatAccountRoundTrip(t *testing.T) {
+
+	acct := &account.Account{
+		ID: 1234,
+		Address: &account.Address{
+			URI:       "bob@example.com",
+			Confirmed: true,
+		},
+		Password: &account.Password{
+			Digest:       "digest",
+			Salt:         "salt",
+			LastModified: 10,
+		},
+		Username: &account.Username{
+			Raw:  "Bob",
+			Safe: "bob",
+		},
+		MFA: &account.MFA{
+			Secret:       "JBSWY3DPEHPK3PXP",
+			LastModified: 20,
+		},
+		Created:      1,
+		LastModified: 30,
+		Status:       1,
+	}
+
+	dynamodb_acct := &DynamoDBAccount{
+		ID:       acct.ID,
+		Created:  acct.Created,
+		Email:    "bob@example.com",
+		URL:      "bob",
+		Skeleton: "bob",
+		Account:  acct,
+	}
+
+	flat := dynamodbAccountToFlatAccount(dynamodb_acct)
+
+	if !flat.HasMFA {
+		t.Fatal("Expected flat account to have MFA")
+	}
+
+	nested := flatAccountToDynamoDBAccount(flat)
+
+	if !reflect.DeepEqual(nested, dynamodb_acct) {
+		t.Fatalf("Expected round trip to be lossless: %+v != %+v", nested.Account, acct)
+	}
+}
+
+func TestFlatAccountRoundTripWithoutMFA(t *testing.T) {
+
+	acct := &account.Account{
+		ID: 1234,
+		Address: &account.Address{
+			URI: "bob@example.com",
+		},
+		Created: 1,
+	}
+
+	dynamodb_acct := &DynamoDBAccount{
+		ID:      acct.ID,
+		Created: acct.Created,
+		Email:   "bob@example.com",
+		Account: acct,
+	}
+
+	flat := dynamodbAccountToFlatAccount(dynamodb_acct)
+
+	if flat.HasMFA {
+		t.Fatal("Expected flat account not to have MFA")
+	}
+
+	nested := flatAccountToDynamoDBAccount(flat)
+
+	if nested.Account.MFA != nil || nested.Account.Password != nil || nested.Account.Username != nil {
+		t.Fatal("Expected missing fields to remain nil")
+	}
+
+	if nested.Account.Address.URI != acct.Address.URI || nested.Account.Address.Confirmed {
+		t.Fatal("Unexpected address")
+	}
+}
@@ -0,0 +1,29 @@
+package dynamodb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// newSecretToken returns a random, URL-safe token suitable for sending to a user. Only
+// the output of hashSecretToken should ever be stored.
+func newSecretToken() (string, error) {
+
+	b := make([]byte, 32)
+
+	_, err := rand.Read(b)
+
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func hashSecretToken(raw string) string {
+
+	sum := sha256.Sum256([]byte(raw))
+	return hex.EncodeToString(sum[:])
+}
@@ -15,6 +15,13 @@ func CreateAccountsTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccountsDa
 	}
 
 	if has_table {
+
+		err := createAccountsSkeletonIndex(client, opts)
+
+		if err != nil {
+			return false, err
+		}
+
 		return true, nil
 	}
 
@@ -32,6 +39,10 @@ func CreateAccountsTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccountsDa
 				AttributeName: aws.String("url"),
 				AttributeType: aws.String("S"),
 			},
+			{
+				AttributeName: aws.String("skeleton"),
+				AttributeType: aws.String("S"),
+			},
 		},
 		KeySchema: []*aws_dynamodb.KeySchemaElement{
 			{
@@ -70,6 +81,21 @@ func CreateAccountsTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccountsDa
 					},
 				},
 			},
+			{
+				IndexName: aws.String("skeleton"),
+				KeySchema: []*aws_dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("skeleton"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &aws_dynamodb.Projection{
+					ProjectionType: aws.String("INCLUDE"),
+					NonKeyAttributes: []*string{
+						aws.String("id"),
+					},
+				},
+			},
 		},
 		BillingMode: aws.String(opts.BillingMode),
 		TableName:   aws.String(opts.TableName),
@@ -84,6 +110,107 @@ func CreateAccountsTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccountsDa
 	return true, nil
 }
 
+// createAccountsSkeletonIndex adds the "skeleton" index, used to detect confusable
+// usernames, to accounts tables created before it existed. Existing accounts have no
+// "skeleton" attribute so the index starts out empty; once it is active run
+// normalize-indexes -apply to backfill it. Until the index is active adding or renaming
+// accounts fails with ErrUsernameCheckUnavailable.
+func createAccountsSkeletonIndex(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccountsDatabaseOptions) error {
+
+	describe_req := &aws_dynamodb.DescribeTableInput{
+		TableName: aws.String(opts.TableName),
+	}
+
+	rsp, err := client.DescribeTable(describe_req)
+
+	if err != nil {
+		return err
+	}
+
+	for _, idx := range rsp.Table.GlobalSecondaryIndexes {
+
+		if *idx.IndexName == "skeleton" {
+			return nil
+		}
+	}
+
+	req := &aws_dynamodb.UpdateTableInput{
+		TableName: aws.String(opts.TableName),
+		AttributeDefinitions: []*aws_dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("skeleton"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		GlobalSecondaryIndexUpdates: []*aws_dynamodb.GlobalSecondaryIndexUpdate{
+			{
+				Create: &aws_dynamodb.CreateGlobalSecondaryIndexAction{
+					IndexName: aws.String("skeleton"),
+					KeySchema: []*aws_dynamodb.KeySchemaElement{
+						{
+							AttributeName: aws.String("skeleton"),
+							KeyType:       aws.String("HASH"),
+						},
+					},
+					Projection: &aws_dynamodb.Projection{
+						ProjectionType: aws.String("INCLUDE"),
+						NonKeyAttributes: []*string{
+							aws.String("id"),
+						},
+					},
+				},
+			},
+		},
+	}
+
+	_, err = client.UpdateTable(req)
+	return err
+}
+
+func CreateEmailVerificationsTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccountsDatabaseOptions) (bool, error) {
+
+	has_table, err := hasTable(client, opts.EmailVerificationsTableName)
+
+	if err != nil {
+		return false, err
+	}
+
+	if has_table {
+		return true, nil
+	}
+
+	req := &aws_dynamodb.CreateTableInput{
+		AttributeDefinitions: []*aws_dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("token"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*aws_dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("token"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		BillingMode: aws.String(opts.BillingMode),
+		TableName:   aws.String(opts.EmailVerificationsTableName),
+	}
+
+	_, err = client.CreateTable(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	err = enableTimeToLive(client, opts.EmailVerificationsTableName, "expires")
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func CreateAccessTokensTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccessTokensDatabaseOptions) (bool, error) {
 
 	has_table, err := hasTable(client, opts.TableName)
@@ -162,6 +289,227 @@ func CreateAccessTokensTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccess
 	return true, nil
 }
 
+func CreateMFADevicesTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBMFADevicesDatabaseOptions) (bool, error) {
+
+	has_table, err := hasTable(client, opts.TableName)
+
+	if err != nil {
+		return false, err
+	}
+
+	if has_table {
+		return true, nil
+	}
+
+	req := &aws_dynamodb.CreateTableInput{
+		AttributeDefinitions: []*aws_dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("account_id"),
+				AttributeType: aws.String("N"),
+			},
+			{
+				AttributeName: aws.String("name"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*aws_dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("account_id"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("name"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		BillingMode: aws.String(opts.BillingMode),
+		TableName:   aws.String(opts.TableName),
+	}
+
+	_, err = client.CreateTable(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func CreateCredentialsTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBCredentialsDatabaseOptions) (bool, error) {
+
+	has_table, err := hasTable(client, opts.TableName)
+
+	if err != nil {
+		return false, err
+	}
+
+	if has_table {
+		return true, nil
+	}
+
+	req := &aws_dynamodb.CreateTableInput{
+		AttributeDefinitions: []*aws_dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("id"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("account_id"),
+				AttributeType: aws.String("N"),
+			},
+		},
+		KeySchema: []*aws_dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("id"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		GlobalSecondaryIndexes: []*aws_dynamodb.GlobalSecondaryIndex{
+			{
+				IndexName: aws.String("account_id"),
+				KeySchema: []*aws_dynamodb.KeySchemaElement{
+					{
+						AttributeName: aws.String("account_id"),
+						KeyType:       aws.String("HASH"),
+					},
+				},
+				Projection: &aws_dynamodb.Projection{
+					ProjectionType: aws.String("INCLUDE"),
+					NonKeyAttributes: []*string{
+						aws.String("id"),
+					},
+				},
+			},
+		},
+		BillingMode: aws.String(opts.BillingMode),
+		TableName:   aws.String(opts.TableName),
+	}
+
+	_, err = client.CreateTable(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+func CreateResetTokensTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBResetTokensDatabaseOptions) (bool, error) {
+
+	has_table, err := hasTable(client, opts.TableName)
+
+	if err != nil {
+		return false, err
+	}
+
+	if has_table {
+		return true, nil
+	}
+
+	req := &aws_dynamodb.CreateTableInput{
+		AttributeDefinitions: []*aws_dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("token"),
+				AttributeType: aws.String("S"),
+			},
+		},
+		KeySchema: []*aws_dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("token"),
+				KeyType:       aws.String("HASH"),
+			},
+		},
+		BillingMode: aws.String(opts.BillingMode),
+		TableName:   aws.String(opts.TableName),
+	}
+
+	_, err = client.CreateTable(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	err = enableTimeToLive(client, opts.TableName, "expires")
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// enableTimeToLive waits for table to become active and then enables automatic expiry of
+// items using the (epoch seconds) attribute.
+func enableTimeToLive(client *aws_dynamodb.DynamoDB, table string, attribute string) error {
+
+	describe_req := &aws_dynamodb.DescribeTableInput{
+		TableName: aws.String(table),
+	}
+
+	err := client.WaitUntilTableExists(describe_req)
+
+	if err != nil {
+		return err
+	}
+
+	ttl_req := &aws_dynamodb.UpdateTimeToLiveInput{
+		TableName: aws.String(table),
+		TimeToLiveSpecification: &aws_dynamodb.TimeToLiveSpecification{
+			AttributeName: aws.String(attribute),
+			Enabled:       aws.Bool(true),
+		},
+	}
+
+	_, err = client.UpdateTimeToLive(ttl_req)
+	return err
+}
+
+func CreateAuditLogTable(client *aws_dynamodb.DynamoDB, opts *DynamoDBAuditLogOptions) (bool, error) {
+
+	has_table, err := hasTable(client, opts.TableName)
+
+	if err != nil {
+		return false, err
+	}
+
+	if has_table {
+		return true, nil
+	}
+
+	req := &aws_dynamodb.CreateTableInput{
+		AttributeDefinitions: []*aws_dynamodb.AttributeDefinition{
+			{
+				AttributeName: aws.String("target"),
+				AttributeType: aws.String("S"),
+			},
+			{
+				AttributeName: aws.String("timestamp"),
+				AttributeType: aws.String("N"),
+			},
+		},
+		KeySchema: []*aws_dynamodb.KeySchemaElement{
+			{
+				AttributeName: aws.String("target"),
+				KeyType:       aws.String("HASH"),
+			},
+			{
+				AttributeName: aws.String("timestamp"),
+				KeyType:       aws.String("RANGE"),
+			},
+		},
+		BillingMode: aws.String(opts.BillingMode),
+		TableName:   aws.String(opts.TableName),
+	}
+
+	_, err = client.CreateTable(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func hasTable(client *aws_dynamodb.DynamoDB, table string) (bool, error) {
 
 	tables, err := listTables(client)
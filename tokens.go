@@ -18,17 +18,30 @@ import (
 const ACCESSTOKENS_DEFAULT_TABLENAME string = "tokens"
 
 type DynamoDBAccessTokensDatabaseOptions struct {
-	TableName   string
-	BillingMode string
-	CreateTable bool
+	TableName      string
+	BillingMode    string
+	CreateTable    bool
+	Retry          *RetryOptions
+	ConsistentRead bool
+	AuditLog       *DynamoDBAuditLog
+
+	// CursorSecret is required to page through tokens. See
+	// DynamoDBAccountsDatabaseOptions.CursorSecret.
+	CursorSecret []byte
+
+	// FallbackTableName, if set, is read from when a token can not be found in TableName.
+	// Tokens are removed from both tables. See DynamoDBAccountsDatabaseOptions.FallbackTableName.
+	FallbackTableName string
 }
 
 func DefaultDynamoDBAccessTokensDatabaseOptions() *DynamoDBAccessTokensDatabaseOptions {
 
 	opts := DynamoDBAccessTokensDatabaseOptions{
-		TableName:   ACCESSTOKENS_DEFAULT_TABLENAME,
-		BillingMode: "PAY_PER_REQUEST",
-		CreateTable: false,
+		TableName:      ACCESSTOKENS_DEFAULT_TABLENAME,
+		BillingMode:    "PAY_PER_REQUEST",
+		CreateTable:    false,
+		Retry:          DefaultRetryOptions(),
+		ConsistentRead: false,
 	}
 
 	return &opts
@@ -36,8 +49,10 @@ func DefaultDynamoDBAccessTokensDatabaseOptions() *DynamoDBAccessTokensDatabaseO
 
 type DynamoDBAccessTokensDatabase struct {
 	database.AccessTokensDatabase
-	client  *aws_dynamodb.DynamoDB
-	options *DynamoDBAccessTokensDatabaseOptions
+	client        *aws_dynamodb.DynamoDB
+	options       *DynamoDBAccessTokensDatabaseOptions
+	cursor_secret []byte
+	ctx           context.Context
 }
 
 func NewDynamoDBAccessTokensDatabaseWithDSN(dsn string, opts *DynamoDBAccessTokensDatabaseOptions) (database.AccessTokensDatabase, error) {
@@ -53,7 +68,7 @@ func NewDynamoDBAccessTokensDatabaseWithDSN(dsn string, opts *DynamoDBAccessToke
 
 func NewDynamoDBAccessTokensDatabaseWithSession(sess *aws_session.Session, opts *DynamoDBAccessTokensDatabaseOptions) (database.AccessTokensDatabase, error) {
 
-	client := aws_dynamodb.New(sess)
+	client := newDynamoDBClient(sess, opts.Retry)
 
 	if opts.CreateTable {
 		_, err := CreateAccessTokensTable(client, opts)
@@ -64,65 +79,84 @@ func NewDynamoDBAccessTokensDatabaseWithSession(sess *aws_session.Session, opts
 	}
 
 	db := DynamoDBAccessTokensDatabase{
-		client:  client,
-		options: opts,
+		client:        client,
+		options:       opts,
+		cursor_secret: opts.CursorSecret,
+		ctx:           context.Background(),
 	}
 
 	return &db, nil
 }
 
+// WithContext returns a shallow copy of db which uses ctx for the changes it makes, for
+// example to record the actor set with WithAuditActor in the audit log.
+func (db *DynamoDBAccessTokensDatabase) WithContext(ctx context.Context) *DynamoDBAccessTokensDatabase {
+
+	copy := *db
+	copy.ctx = ctx
+
+	return &copy
+}
+
 func (db *DynamoDBAccessTokensDatabase) GetTokenByID(id int64) (*token.Token, error) {
+	return db.GetTokenByIDWithReadOptions(id, db.readOptions())
+}
+
+func (db *DynamoDBAccessTokensDatabase) GetTokenByIDWithReadOptions(id int64, read_opts *ReadOptions) (*token.Token, error) {
+
+	if read_opts == nil {
+		read_opts = db.readOptions()
+	}
 
 	str_id := strconv.FormatInt(id, 10)
 
-	req := &aws_dynamodb.GetItemInput{
-		TableName: aws.String(db.options.TableName),
-		Key: map[string]*aws_dynamodb.AttributeValue{
-			"id": {
-				N: aws.String(str_id),
+	var item map[string]*aws_dynamodb.AttributeValue
+
+	for _, table := range readTables(db.options.TableName, db.options.FallbackTableName) {
+
+		req := &aws_dynamodb.GetItemInput{
+			TableName: aws.String(table),
+			Key: map[string]*aws_dynamodb.AttributeValue{
+				"id": {
+					N: aws.String(str_id),
+				},
 			},
-		},
-	}
+			ConsistentRead: aws.Bool(read_opts.ConsistentRead),
+		}
 
-	rsp, err := db.client.GetItem(req)
+		rsp, err := db.client.GetItem(req)
 
-	if err != nil {
-		return nil, err
+		if err != nil {
+			return nil, err
+		}
+
+		item = rsp.Item
+
+		if len(item) > 0 {
+			break
+		}
 	}
 
-	return itemToToken(rsp.Item)
+	return itemToToken(item)
 }
 
 func (db *DynamoDBAccessTokensDatabase) GetTokenByAccessToken(access_token string) (*token.Token, error) {
-
-	return db.getAccountByPointer("access_token", "access_token", access_token)
+	return db.GetTokenByAccessTokenWithReadOptions(access_token, db.readOptions())
 }
 
-func (db *DynamoDBAccessTokensDatabase) getAccountByPointer(idx string, key string, value string) (*token.Token, error) {
+func (db *DynamoDBAccessTokensDatabase) GetTokenByAccessTokenWithReadOptions(access_token string, read_opts *ReadOptions) (*token.Token, error) {
+	return db.getAccountByPointer("access_token", "access_token", access_token, read_opts)
+}
 
-	req := &aws_dynamodb.QueryInput{
-		TableName: aws.String(db.options.TableName),
-		KeyConditions: map[string]*aws_dynamodb.Condition{
-			key: {
-				ComparisonOperator: aws.String("EQ"),
-				AttributeValueList: []*aws_dynamodb.AttributeValue{
-					{
-						S: aws.String(value),
-					},
-				},
-			},
-		},
-		ProjectionExpression: aws.String("id"),
-		IndexName:            aws.String(idx),
-	}
+func (db *DynamoDBAccessTokensDatabase) getAccountByPointer(idx string, key string, value string, read_opts *ReadOptions) (*token.Token, error) {
 
-	rsp, err := db.client.Query(req)
+	items, err := queryPointer(db.client, readTables(db.options.TableName, db.options.FallbackTableName), idx, key, value)
 
 	if err != nil {
 		return nil, err
 	}
 
-	count_items := len(rsp.Items)
+	count_items := len(items)
 
 	if count_items < 1 {
 		return nil, new(database.ErrNoToken)
@@ -132,7 +166,7 @@ func (db *DynamoDBAccessTokensDatabase) getAccountByPointer(idx string, key stri
 		return nil, errors.New("Multiple results for key!")
 	}
 
-	rsp_id := rsp.Items[0]["id"]
+	rsp_id := items[0]["id"]
 	str_id := *rsp_id.N
 
 	id, err := strconv.ParseInt(str_id, 10, 64)
@@ -141,7 +175,7 @@ func (db *DynamoDBAccessTokensDatabase) getAccountByPointer(idx string, key stri
 		return nil, err
 	}
 
-	return db.GetTokenByID(id)
+	return db.GetTokenByIDWithReadOptions(id, read_opts)
 }
 
 func (db *DynamoDBAccessTokensDatabase) AddToken(tok *token.Token) (*token.Token, error) {
@@ -160,11 +194,28 @@ func (db *DynamoDBAccessTokensDatabase) AddToken(tok *token.Token) (*token.Token
 		return nil, err
 	}
 
+	db.audit(AUDIT_ACTION_ADD, tok.ID, nil, tok)
+
 	return tok, nil
 }
 
 func (db *DynamoDBAccessTokensDatabase) UpdateToken(tok *token.Token) (*token.Token, error) {
 
+	var old_tok *token.Token
+
+	if db.options.AuditLog != nil {
+
+		t, err := db.GetTokenByIDWithReadOptions(tok.ID, &ReadOptions{ConsistentRead: true})
+
+		if err != nil {
+			return tok, err
+		}
+
+		if t != nil && t.ID != 0 {
+			old_tok = t
+		}
+	}
+
 	now := time.Now()
 	tok.LastModified = now.Unix()
 
@@ -174,6 +225,8 @@ func (db *DynamoDBAccessTokensDatabase) UpdateToken(tok *token.Token) (*token.To
 		return tok, err
 	}
 
+	db.audit(AUDIT_ACTION_UPDATE, tok.ID, old_tok, tok)
+
 	return tok, nil
 }
 
@@ -181,19 +234,43 @@ func (db *DynamoDBAccessTokensDatabase) RemoveToken(tok *token.Token) (*token.To
 
 	str_id := strconv.FormatInt(tok.ID, 10)
 
-	req := &aws_dynamodb.DeleteItemInput{
-		TableName: aws.String(db.options.TableName),
-		Key: map[string]*aws_dynamodb.AttributeValue{
-			"id": {
-				N: aws.String(str_id),
+	var old_item map[string]*aws_dynamodb.AttributeValue
+
+	// Remove (revoke) the token in the fallback table as well, otherwise it would still
+	// resolve there
+
+	for _, table := range readTables(db.options.TableName, db.options.FallbackTableName) {
+
+		req := &aws_dynamodb.DeleteItemInput{
+			TableName: aws.String(table),
+			Key: map[string]*aws_dynamodb.AttributeValue{
+				"id": {
+					N: aws.String(str_id),
+				},
 			},
-		},
+			ReturnValues: aws.String(aws_dynamodb.ReturnValueAllOld),
+		}
+
+		rsp, err := db.client.DeleteItem(req)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if len(old_item) == 0 {
+			old_item = rsp.Attributes
+		}
 	}
 
-	_, err := db.client.DeleteItem(req)
+	if db.options.AuditLog != nil && len(old_item) > 0 {
 
-	if err != nil {
-		return nil, err
+		old_tok, err := itemToToken(old_item)
+
+		if err != nil {
+			return nil, err
+		}
+
+		db.audit(AUDIT_ACTION_REMOVE, tok.ID, old_tok, nil)
 	}
 
 	return nil, nil
@@ -229,6 +306,173 @@ func (db *DynamoDBAccessTokensDatabase) ListAccessTokensForAccount(ctx context.C
 	return scanTokens(ctx, db.client, req, callback)
 }
 
+// ListAccessTokensPage returns up to opts.PageSize tokens starting from opts.Cursor and the
+// cursor for the next page, which will be empty when there are no more tokens.
+func (db *DynamoDBAccessTokensDatabase) ListAccessTokensPage(ctx context.Context, opts *PageOptions) ([]*token.Token, string, error) {
+
+	err := validateCursorSecret(db.cursor_secret)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	req := &aws_dynamodb.ScanInput{
+		TableName: aws.String(db.options.TableName),
+	}
+
+	return db.scanTokensPage(ctx, req, opts)
+}
+
+// ListAccessTokensForAccountPage is the page-oriented equivalent of ListAccessTokensForAccount.
+func (db *DynamoDBAccessTokensDatabase) ListAccessTokensForAccountPage(ctx context.Context, acct *account.Account, opts *PageOptions) ([]*token.Token, string, error) {
+
+	err := validateCursorSecret(db.cursor_secret)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	opts = pageOptions(opts)
+
+	str_id := strconv.FormatInt(acct.ID, 10)
+
+	// Cursors are scoped to the account so they can not be used to page through (or be
+	// confused with pages of) another account's tokens
+
+	cursor_scope := db.options.TableName + "#account_id#" + str_id
+
+	start_key, err := decodeCursor(db.cursor_secret, cursor_scope, opts.Cursor)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	req := &aws_dynamodb.QueryInput{
+		TableName:              aws.String(db.options.TableName),
+		IndexName:              aws.String("account_id"),
+		KeyConditionExpression: aws.String("#account_id = :account_id"),
+		ExpressionAttributeNames: map[string]*string{
+			"#account_id": aws.String("account_id"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":account_id": {
+				N: aws.String(str_id),
+			},
+		},
+		ExclusiveStartKey: start_key,
+	}
+
+	items, last_key, err := queryPage(db.client, req, pageSize(opts), []string{"account_id", "id"})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	// The account_id index only projects token IDs
+
+	ids := make([]int64, 0)
+
+	for _, item := range items {
+
+		id, ok := itemID(item)
+
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+
+	tokens_by_id, _, err := db.GetTokensByIDs(ids)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	tokens := make([]*token.Token, 0)
+
+	for _, id := range ids {
+
+		// Tokens removed since the index was read are skipped
+
+		tok, ok := tokens_by_id[id]
+
+		if ok {
+			tokens = append(tokens, tok)
+		}
+	}
+
+	cursor, err := encodeCursor(db.cursor_secret, cursor_scope, last_key)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tokens, cursor, nil
+}
+
+func (db *DynamoDBAccessTokensDatabase) scanTokensPage(ctx context.Context, req *aws_dynamodb.ScanInput, opts *PageOptions) ([]*token.Token, string, error) {
+
+	opts = pageOptions(opts)
+
+	start_key, err := decodeCursor(db.cursor_secret, db.options.TableName, opts.Cursor)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	req.ExclusiveStartKey = start_key
+
+	items, last_key, err := scanPage(db.client, req, pageSize(opts), []string{"id"})
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	tokens := make([]*token.Token, 0)
+
+	for _, item := range items {
+
+		tok, err := itemToToken(item)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		tokens = append(tokens, tok)
+	}
+
+	cursor, err := encodeCursor(db.cursor_secret, db.options.TableName, last_key)
+
+	if err != nil {
+		return nil, "", err
+	}
+
+	return tokens, cursor, nil
+}
+
+// audit records action on the token id in the audit log, if one is configured. It is
+// called once the change has been written so errors are logged rather than returned.
+func (db *DynamoDBAccessTokensDatabase) audit(action string, id int64, old *token.Token, new *token.Token) {
+
+	if db.options.AuditLog == nil {
+		return
+	}
+
+	err := db.options.AuditLog.Record(db.ctx, action, AUDIT_TARGET_TOKEN, id, old, new)
+
+	if err != nil {
+		logAuditError(AUDIT_TARGET_TOKEN, id, action, err)
+	}
+}
+
+func (db *DynamoDBAccessTokensDatabase) readOptions() *ReadOptions {
+
+	read_opts := ReadOptions{
+		ConsistentRead: db.options.ConsistentRead,
+	}
+
+	return &read_opts
+}
+
 func putToken(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccessTokensDatabaseOptions, tok *token.Token) error {
 
 	item, err := aws_dynamodbattribute.MarshalMap(tok)
@@ -237,6 +481,8 @@ func putToken(client *aws_dynamodb.DynamoDB, opts *DynamoDBAccessTokensDatabaseO
 		return err
 	}
 
+	item[SCHEMA_VERSION_ATTRIBUTE] = schemaVersionAttribute(TOKEN_SCHEMA_VERSION)
+
 	req := &aws_dynamodb.PutItemInput{
 		Item:      item,
 		TableName: aws.String(opts.TableName),
@@ -0,0 +1,269 @@
+package dynamodb
+
+import (
+	"bufio"
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	"golang.org/x/text/unicode/norm"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrUsernameUnavailable = errors.New("Username is unavailable")
+
+var ErrUsernameReserved = errors.New("Username is reserved")
+
+var ErrUsernameCheckUnavailable = errors.New("Username collision check is unavailable until the skeleton index is active and backfilled (run normalize-indexes -apply)")
+
+// NormalizeUsername trims whitespace from name and applies Unicode NFKC normalization, so
+// that compatibility characters (for example fullwidth letters or ligatures) are replaced
+// by their canonical equivalents.
+func NormalizeUsername(name string) string {
+	return norm.NFKC.String(strings.TrimSpace(name))
+}
+
+// UsernameSkeleton returns the value used to detect usernames which look the same but are
+// made of different code points. Following the "skeleton" algorithm described in Unicode
+// Technical Standard #39 the name is normalized and case-folded, combining marks and
+// invisible formatting characters are removed and any remaining non-Latin characters that
+// are easily confused with a Latin letter or digit are replaced with it. Two usernames
+// with the same skeleton are considered to collide. ASCII letters and digits are never
+// folded, so distinct Latin names such as "clark" and "dark" or "modern" and "modem" do
+// not collide. Stored skeletons are updated by running normalize-indexes -apply.
+func UsernameSkeleton(name string) string {
+
+	name = strings.ToLower(NormalizeUsername(name))
+	name = norm.NFD.String(name)
+
+	var b strings.Builder
+
+	for _, r := range name {
+
+		if unicode.Is(unicode.Mn, r) || unicode.Is(unicode.Cf, r) {
+			continue
+		}
+
+		if repl, ok := usernameConfusables[r]; ok {
+			b.WriteString(repl)
+			continue
+		}
+
+		b.WriteRune(r)
+	}
+
+	return norm.NFC.String(b.String())
+}
+
+// ReadReservedUsernames reads a list of reserved usernames, one per line, from path. Blank
+// lines and lines starting with "#" are ignored.
+func ReadReservedUsernames(path string) ([]string, error) {
+
+	fh, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer fh.Close()
+
+	names := make([]string, 0)
+
+	scanner := bufio.NewScanner(fh)
+
+	for scanner.Scan() {
+
+		ln := strings.TrimSpace(scanner.Text())
+
+		if ln == "" || strings.HasPrefix(ln, "#") {
+			continue
+		}
+
+		names = append(names, ln)
+	}
+
+	err = scanner.Err()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return names, nil
+}
+
+// usernameSkeletonValue returns the value stored in, and queried against, the "skeleton"
+// index for acct.
+func usernameSkeletonValue(acct *account.Account) string {
+
+	if acct.Username == nil {
+		return ""
+	}
+
+	name := acct.Username.Raw
+
+	if name == "" {
+		name = acct.Username.Safe
+	}
+
+	return UsernameSkeleton(name)
+}
+
+// isReservedUsername reports whether skeleton matches the skeleton of any of
+// options.ReservedUsernames.
+func (db *DynamoDBAccountsDatabase) isReservedUsername(skeleton string) bool {
+
+	for _, name := range db.options.ReservedUsernames {
+
+		if UsernameSkeleton(name) == skeleton {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checkUsernameAvailable returns ErrUsernameReserved or ErrUsernameUnavailable if acct's
+// username is reserved or collides with the username of any account other than acct. If
+// the "skeleton" index is missing or still being created it returns
+// ErrUsernameCheckUnavailable.
+func (db *DynamoDBAccountsDatabase) checkUsernameAvailable(acct *account.Account) error {
+
+	skeleton := usernameSkeletonValue(acct)
+
+	if skeleton == "" {
+		return nil
+	}
+
+	if db.isReservedUsername(skeleton) {
+		return ErrUsernameReserved
+	}
+
+	req := &aws_dynamodb.QueryInput{
+		TableName:              aws.String(db.options.TableName),
+		IndexName:              aws.String("skeleton"),
+		KeyConditionExpression: aws.String("#skeleton = :skeleton"),
+		ExpressionAttributeNames: map[string]*string{
+			"#skeleton": aws.String("skeleton"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":skeleton": {S: aws.String(skeleton)},
+		},
+		ProjectionExpression: aws.String("id"),
+	}
+
+	rsp, err := db.client.Query(req)
+
+	if err != nil {
+
+		if isIndexUnavailable(err) {
+			return ErrUsernameCheckUnavailable
+		}
+
+		return err
+	}
+
+	for _, item := range rsp.Items {
+
+		v, ok := item["id"]
+
+		if !ok || v.N == nil {
+			continue
+		}
+
+		id, err := strconv.ParseInt(*v.N, 10, 64)
+
+		if err != nil {
+			return err
+		}
+
+		if id != acct.ID {
+			return ErrUsernameUnavailable
+		}
+	}
+
+	return nil
+}
+
+// usernameConfusables maps characters to the Latin letters or digits they are most commonly
+// mistaken for. It is a subset of the Unicode confusables data covering the Cyrillic,
+// Greek, Armenian and symbol characters that are most often used to impersonate Latin
+// usernames; compatibility forms are already handled by NFKC normalization.
+var usernameConfusables = map[rune]string{
+	// ASCII symbols
+	'|': "l",
+	// Latin
+	'ı': "i",
+	'ȷ': "j",
+	'ɑ': "a",
+	'ɡ': "g",
+	'ɩ': "i",
+	'ʟ': "l",
+	'ɴ': "n",
+	'ᴏ': "o",
+	'ß': "ss",
+	'ø': "o",
+	'đ': "d",
+	'ħ': "h",
+	'ł': "l",
+	// Cyrillic
+	'а': "a",
+	'в': "b",
+	'с': "c",
+	'ԁ': "d",
+	'е': "e",
+	'ё': "e",
+	'һ': "h",
+	'і': "i",
+	'ї': "i",
+	'ј': "j",
+	'к': "k",
+	'ӏ': "l",
+	'м': "m",
+	'н': "h",
+	'о': "o",
+	'р': "p",
+	'ԛ': "q",
+	'г': "r",
+	'ѕ': "s",
+	'т': "t",
+	'ѵ': "v",
+	'ԝ': "w",
+	'х': "x",
+	'у': "y",
+	'з': "3",
+	'ь': "b",
+	// Greek
+	'α': "a",
+	'β': "b",
+	'ε': "e",
+	'η': "n",
+	'ι': "i",
+	'κ': "k",
+	'ν': "v",
+	'ο': "o",
+	'ρ': "p",
+	'τ': "t",
+	'υ': "u",
+	'χ': "x",
+	'γ': "y",
+	'ω': "w",
+	// Armenian
+	'օ': "o",
+	'ս': "u",
+	'հ': "h",
+	'ո': "n",
+	'ց': "g",
+	'զ': "q",
+	// Punctuation
+	'‐': "-",
+	'‑': "-",
+	'‒': "-",
+	'–': "-",
+	'—': "-",
+	'−': "-",
+	'․': ".",
+	'‚': ",",
+}
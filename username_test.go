@@ -0,0 +1,40 @@
+package dynamodb
+
+import (
+	"testing"
+)
+
+func TestUsernameSkeleton(t *testing.T) {
+
+	collide := [][2]string{
+		{"bob", "BOB"},
+		{"bob", " bob "},
+		{"paypal", "pаypal"}, // Cyrillic а
+		{"apple", "аррӏе"},   // Cyrillic а, р, ӏ and е
+		{"bob", "ｂｏｂ"},       // fullwidth
+		{"jose", "josé"},
+		{"admin", "adm​in"}, // zero width space
+	}
+
+	for _, pair := range collide {
+
+		if UsernameSkeleton(pair[0]) != UsernameSkeleton(pair[1]) {
+			t.Fatalf("Expected '%s' and '%s' to collide", pair[0], pair[1])
+		}
+	}
+
+	distinct := [][2]string{
+		{"clark", "dark"},
+		{"modern", "modem"},
+		{"vvolf", "wolf"},
+		{"bob0", "bobo"},
+		{"bi11", "bill"},
+	}
+
+	for _, pair := range distinct {
+
+		if UsernameSkeleton(pair[0]) == UsernameSkeleton(pair[1]) {
+			t.Fatalf("Expected '%s' and '%s' not to collide", pair[0], pair[1])
+		}
+	}
+}
@@ -0,0 +1,514 @@
+package dynamodb
+
+import (
+	"errors"
+	"github.com/aaronland/go-auth/account"
+	"github.com/aaronland/go-auth/database"
+	aws "github.com/aws/aws-sdk-go/aws"
+	aws_dynamodb "github.com/aws/aws-sdk-go/service/dynamodb"
+	aws_dynamodbattribute "github.com/aws/aws-sdk-go/service/dynamodb/dynamodbattribute"
+	"strconv"
+	"time"
+)
+
+const EMAILVERIFICATIONS_DEFAULT_TABLENAME string = "email_verifications"
+
+const EMAIL_VERIFICATION_PURPOSE_VERIFY string = "verify"
+
+const EMAIL_VERIFICATION_PURPOSE_CHANGE string = "change"
+
+const EMAIL_VERIFICATION_PURPOSE_RESERVATION string = "reservation"
+
+var ErrInvalidVerificationToken = errors.New("Invalid or expired verification token")
+
+var ErrEmailAddressUnavailable = errors.New("Email address is already in use")
+
+// EmailVerification is the stored form of both email verification tokens, where Token is a
+// SHA-256 hash of the token given to the user, and of email address reservations, where
+// Token is "email#" followed by Email. Email is the address's index value (a blind index if
+// options.EmailBlindIndexKey is set) rather than the address itself. Email change tokens
+// also carry the new address, encrypted if options.KeyProvider is set. Expires is also used
+// as the table's TTL attribute so abandoned tokens and reservations are eventually removed.
+type EmailVerification struct {
+	Token     string           `json:"token"`
+	AccountID int64            `json:"account_id"`
+	Email     string           `json:"email"`
+	Address   string           `json:"address,omitempty"`
+	Encrypted *EncryptedFields `json:"encrypted,omitempty"`
+	Purpose   string           `json:"purpose"`
+	Created   int64            `json:"created"`
+	Expires   int64            `json:"expires"`
+}
+
+// IsEmailVerified reports whether acct's current email address has been verified.
+func (db *DynamoDBAccountsDatabase) IsEmailVerified(acct *account.Account) (bool, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName:            aws.String(db.options.TableName),
+		Key:                  idKey(acct.ID),
+		ConsistentRead:       aws.Bool(true),
+		ProjectionExpression: aws.String("#verified"),
+		ExpressionAttributeNames: map[string]*string{
+			"#verified": aws.String("email_verified"),
+		},
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return false, err
+	}
+
+	v, ok := rsp.Item["email_verified"]
+
+	if !ok || v.BOOL == nil {
+		return false, nil
+	}
+
+	return *v.BOOL, nil
+}
+
+// RequestEmailVerification returns a new token which can be passed to VerifyEmail to
+// mark acct's current email address as verified.
+func (db *DynamoDBAccountsDatabase) RequestEmailVerification(acct *account.Account) (string, error) {
+	return db.putEmailVerification(acct, acct.Address.URI, EMAIL_VERIFICATION_PURPOSE_VERIFY)
+}
+
+func (db *DynamoDBAccountsDatabase) VerifyEmail(raw string) (*account.Account, error) {
+
+	v, err := db.getEmailVerification(raw, EMAIL_VERIFICATION_PURPOSE_VERIFY)
+
+	if err != nil {
+		return nil, err
+	}
+
+	acct, err := db.GetAccountByIDWithReadOptions(v.AccountID, &ReadOptions{ConsistentRead: true})
+
+	if err != nil {
+		return nil, err
+	}
+
+	str_now := strconv.FormatInt(time.Now().Unix(), 10)
+
+	req := &aws_dynamodb.TransactWriteItemsInput{
+		TransactItems: []*aws_dynamodb.TransactWriteItem{
+			{
+				Delete: db.deleteEmailVerification(v.Token, str_now),
+			},
+			{
+				Update: &aws_dynamodb.Update{
+					TableName:           aws.String(db.options.TableName),
+					Key:                 idKey(acct.ID),
+					UpdateExpression:    aws.String("SET #verified = :true"),
+					ConditionExpression: aws.String("#email = :email"),
+					ExpressionAttributeNames: map[string]*string{
+						"#verified": aws.String("email_verified"),
+						"#email":    aws.String("email"),
+					},
+					ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+						":true":  {BOOL: aws.Bool(true)},
+						":email": {S: aws.String(v.Email)},
+					},
+				},
+			},
+		},
+	}
+
+	_, err = db.client.TransactWriteItems(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]interface{}{
+		"email_verified": true,
+	}
+
+	db.auditAttributes(AUDIT_ACTION_VERIFY_EMAIL, acct.ID, nil, nil, attrs)
+	return acct, nil
+}
+
+// RequestEmailChange reserves addr for acct, so that no other account can claim it, and
+// returns a token which can be passed to ConfirmEmailChange to make it acct's address.
+func (db *DynamoDBAccountsDatabase) RequestEmailChange(acct *account.Account, addr string) (string, error) {
+
+	if addr == acct.Address.URI {
+		return "", errors.New("Email address has not changed")
+	}
+
+	err := db.checkEmailAvailable(addr, acct.ID)
+
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	reservation := EmailVerification{
+		Token:     db.emailReservationKey(addr),
+		AccountID: acct.ID,
+		Email:     emailIndexValue(db.options, addr),
+		Purpose:   EMAIL_VERIFICATION_PURPOSE_RESERVATION,
+		Created:   now.Unix(),
+		Expires:   now.Add(db.options.EmailVerificationTTL).Unix(),
+	}
+
+	item, err := aws_dynamodbattribute.MarshalMap(reservation)
+
+	if err != nil {
+		return "", err
+	}
+
+	str_now := strconv.FormatInt(now.Unix(), 10)
+	str_id := strconv.FormatInt(acct.ID, 10)
+
+	req := &aws_dynamodb.PutItemInput{
+		TableName:           aws.String(db.options.EmailVerificationsTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#token) OR #expires < :now OR #account_id = :account_id"),
+		ExpressionAttributeNames: map[string]*string{
+			"#token":      aws.String("token"),
+			"#expires":    aws.String("expires"),
+			"#account_id": aws.String("account_id"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":now":        {N: aws.String(str_now)},
+			":account_id": {N: aws.String(str_id)},
+		},
+	}
+
+	_, err = db.client.PutItem(req)
+
+	if err != nil {
+
+		if isConditionalCheckFailed(err) {
+			return "", ErrEmailAddressUnavailable
+		}
+
+		return "", err
+	}
+
+	return db.putEmailVerification(acct, addr, EMAIL_VERIFICATION_PURPOSE_CHANGE)
+}
+
+// ConfirmEmailChange consumes a token created by RequestEmailChange and, in a single
+// transaction, swaps the new (now verified) address into the account and releases the
+// reservation.
+func (db *DynamoDBAccountsDatabase) ConfirmEmailChange(raw string) (*account.Account, error) {
+
+	v, err := db.getEmailVerification(raw, EMAIL_VERIFICATION_PURPOSE_CHANGE)
+
+	if err != nil {
+		return nil, err
+	}
+
+	addr, err := db.emailVerificationAddress(v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.checkEmailAvailable(addr, v.AccountID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	item, acct, err := db.getAccountItem(v.AccountID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	old_acct, err := itemToAccount(db.options, item)
+
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+
+	acct.Address.URI = addr
+	acct.LastModified = now.Unix()
+
+	// The account is only updated if none of the attributes being changed (which always
+	// includes its email address and last modified time) have been modified since it was
+	// read, so that concurrent changes, for example to its password, are not reverted
+
+	extra := map[string]*aws_dynamodb.AttributeValue{
+		"email_verified": {BOOL: aws.Bool(true)},
+	}
+
+	update_req, err := newAccountRewrite(db.options, item, acct, extra)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if update_req == nil {
+		return nil, errors.New("Email address has not changed")
+	}
+
+	str_now := strconv.FormatInt(now.Unix(), 10)
+	str_id := strconv.FormatInt(acct.ID, 10)
+
+	req := &aws_dynamodb.TransactWriteItemsInput{
+		TransactItems: []*aws_dynamodb.TransactWriteItem{
+			{
+				Delete: db.deleteEmailVerification(v.Token, str_now),
+			},
+			{
+				Update: &aws_dynamodb.Update{
+					TableName:                 update_req.TableName,
+					Key:                       update_req.Key,
+					UpdateExpression:          update_req.UpdateExpression,
+					ConditionExpression:       update_req.ConditionExpression,
+					ExpressionAttributeNames:  update_req.ExpressionAttributeNames,
+					ExpressionAttributeValues: update_req.ExpressionAttributeValues,
+				},
+			},
+			{
+				Delete: &aws_dynamodb.Delete{
+					TableName: aws.String(db.options.EmailVerificationsTableName),
+					Key: map[string]*aws_dynamodb.AttributeValue{
+						"token": {S: aws.String(db.emailReservationKey(addr))},
+					},
+					ConditionExpression: aws.String("#account_id = :account_id"),
+					ExpressionAttributeNames: map[string]*string{
+						"#account_id": aws.String("account_id"),
+					},
+					ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+						":account_id": {N: aws.String(str_id)},
+					},
+				},
+			},
+		},
+	}
+
+	_, err = db.client.TransactWriteItems(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	attrs := map[string]interface{}{
+		"email_verified": true,
+	}
+
+	db.auditAttributes(AUDIT_ACTION_CHANGE_EMAIL, acct.ID, old_acct, acct, attrs)
+	return acct, nil
+}
+
+// checkEmailAvailable returns ErrEmailAddressUnavailable if addr belongs to, or has been
+// reserved by, an account other than account_id.
+func (db *DynamoDBAccountsDatabase) checkEmailAvailable(addr string, account_id int64) error {
+
+	existing_acct, err := db.GetAccountByEmailAddress(addr)
+
+	if err != nil && !database.IsNotExist(err) {
+		return err
+	}
+
+	if existing_acct != nil && existing_acct.ID != account_id {
+		return ErrEmailAddressUnavailable
+	}
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName: aws.String(db.options.EmailVerificationsTableName),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			"token": {S: aws.String(db.emailReservationKey(addr))},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return err
+	}
+
+	if len(rsp.Item) == 0 {
+		return nil
+	}
+
+	var reservation *EmailVerification
+
+	err = aws_dynamodbattribute.UnmarshalMap(rsp.Item, &reservation)
+
+	if err != nil {
+		return err
+	}
+
+	if reservation.AccountID != account_id && reservation.Expires > time.Now().Unix() {
+		return ErrEmailAddressUnavailable
+	}
+
+	return nil
+}
+
+func (db *DynamoDBAccountsDatabase) putEmailVerification(acct *account.Account, addr string, purpose string) (string, error) {
+
+	raw, err := newSecretToken()
+
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+
+	v := EmailVerification{
+		Token:     hashSecretToken(raw),
+		AccountID: acct.ID,
+		Email:     emailIndexValue(db.options, addr),
+		Purpose:   purpose,
+		Created:   now.Unix(),
+		Expires:   now.Add(db.options.EmailVerificationTTL).Unix(),
+	}
+
+	// Only a change token needs the address itself, to make it the account's address
+
+	if purpose == EMAIL_VERIFICATION_PURPOSE_CHANGE {
+
+		err := db.setEmailVerificationAddress(&v, addr)
+
+		if err != nil {
+			return "", err
+		}
+	}
+
+	item, err := aws_dynamodbattribute.MarshalMap(v)
+
+	if err != nil {
+		return "", err
+	}
+
+	req := &aws_dynamodb.PutItemInput{
+		TableName: aws.String(db.options.EmailVerificationsTableName),
+		Item:      item,
+	}
+
+	_, err = db.client.PutItem(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	return raw, nil
+}
+
+func (db *DynamoDBAccountsDatabase) getEmailVerification(raw string, purpose string) (*EmailVerification, error) {
+
+	req := &aws_dynamodb.GetItemInput{
+		TableName: aws.String(db.options.EmailVerificationsTableName),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			"token": {S: aws.String(hashSecretToken(raw))},
+		},
+		ConsistentRead: aws.Bool(true),
+	}
+
+	rsp, err := db.client.GetItem(req)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rsp.Item) == 0 {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	var v *EmailVerification
+
+	err = aws_dynamodbattribute.UnmarshalMap(rsp.Item, &v)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if v.Purpose != purpose || v.Expires <= time.Now().Unix() {
+		return nil, ErrInvalidVerificationToken
+	}
+
+	return v, nil
+}
+
+// setEmailVerificationAddress stores addr in v, encrypted if options.KeyProvider is set.
+// Without a key provider account addresses are themselves stored in the clear.
+func (db *DynamoDBAccountsDatabase) setEmailVerificationAddress(v *EmailVerification, addr string) error {
+
+	if db.options.KeyProvider == nil {
+		v.Address = addr
+		return nil
+	}
+
+	values := map[string]interface{}{
+		"address": addr,
+	}
+
+	enc, err := encryptValues(db.options.KeyProvider, emailVerificationAADPrefix(v), values)
+
+	if err != nil {
+		return err
+	}
+
+	v.Encrypted = enc
+	return nil
+}
+
+// emailVerificationAddress returns the address stored in v by setEmailVerificationAddress.
+func (db *DynamoDBAccountsDatabase) emailVerificationAddress(v *EmailVerification) (string, error) {
+
+	if v.Encrypted == nil {
+
+		if v.Address == "" {
+			return "", ErrInvalidVerificationToken
+		}
+
+		return v.Address, nil
+	}
+
+	var addr string
+
+	targets := map[string]interface{}{
+		"address": &addr,
+	}
+
+	err := decryptValues(db.options.KeyProvider, v.Encrypted, emailVerificationAADPrefix(v), targets)
+
+	if err != nil {
+		return "", err
+	}
+
+	return addr, nil
+}
+
+// emailVerificationAADPrefix binds an encrypted address to the token and account it was
+// issued for.
+func emailVerificationAADPrefix(v *EmailVerification) string {
+	return "email_verification#" + v.Token + "#" + strconv.FormatInt(v.AccountID, 10)
+}
+
+// deleteEmailVerification returns the transaction step that consumes a token, which fails
+// if the token has already been consumed or has expired.
+func (db *DynamoDBAccountsDatabase) deleteEmailVerification(token string, str_now string) *aws_dynamodb.Delete {
+
+	del := &aws_dynamodb.Delete{
+		TableName: aws.String(db.options.EmailVerificationsTableName),
+		Key: map[string]*aws_dynamodb.AttributeValue{
+			"token": {S: aws.String(token)},
+		},
+		ConditionExpression: aws.String("attribute_exists(#token) AND #expires > :now"),
+		ExpressionAttributeNames: map[string]*string{
+			"#token":   aws.String("token"),
+			"#expires": aws.String("expires"),
+		},
+		ExpressionAttributeValues: map[string]*aws_dynamodb.AttributeValue{
+			":now": {N: aws.String(str_now)},
+		},
+	}
+
+	return del
+}
+
+func (db *DynamoDBAccountsDatabase) emailReservationKey(addr string) string {
+	return "email#" + emailIndexValue(db.options, addr)
+}
@@ -0,0 +1,86 @@
+package dynamodb
+
+import (
+	"testing"
+)
+
+func TestEmailVerificationAddress(t *testing.T) {
+
+	opts := DefaultDynamoDBAccountsDatabaseOptions()
+	opts.KeyProvider = newTestKeyProvider(t, newTestKey(t, "k1"))
+
+	db := &DynamoDBAccountsDatabase{
+		options: opts,
+	}
+
+	v := &EmailVerification{
+		Token:     hashSecretToken("token"),
+		AccountID: 1234,
+		Email:     emailIndexValue(opts, "bob@example.com"),
+		Purpose:   EMAIL_VERIFICATION_PURPOSE_CHANGE,
+	}
+
+	err := db.setEmailVerificationAddress(v, "bob@example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if v.Address != "" || v.Encrypted == nil {
+		t.Fatal("Expected the address to be encrypted")
+	}
+
+	moved := *v
+	moved.AccountID = 5678
+
+	_, err = db.emailVerificationAddress(&moved)
+
+	if err == nil {
+		t.Fatal("Expected decrypting an address moved to another account to fail")
+	}
+
+	addr, err := db.emailVerificationAddress(v)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr != "bob@example.com" {
+		t.Fatalf("Unexpected address '%s'", addr)
+	}
+}
+
+func TestEmailVerificationAddressWithoutKeyProvider(t *testing.T) {
+
+	db := &DynamoDBAccountsDatabase{
+		options: DefaultDynamoDBAccountsDatabaseOptions(),
+	}
+
+	v := &EmailVerification{
+		Token:     hashSecretToken("token"),
+		AccountID: 1234,
+		Purpose:   EMAIL_VERIFICATION_PURPOSE_CHANGE,
+	}
+
+	_, err := db.emailVerificationAddress(v)
+
+	if err != ErrInvalidVerificationToken {
+		t.Fatalf("Expected ErrInvalidVerificationToken, got %v", err)
+	}
+
+	err = db.setEmailVerificationAddress(v, "bob@example.com")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	addr, err := db.emailVerificationAddress(v)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if addr != "bob@example.com" {
+		t.Fatalf("Unexpected address '%s'", addr)
+	}
+}